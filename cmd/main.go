@@ -3,81 +3,174 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/RudinMaxim/BarberBot.git/config"
 	"github.com/RudinMaxim/BarberBot.git/database"
 	"github.com/RudinMaxim/BarberBot.git/internal/bot"
+	"github.com/RudinMaxim/BarberBot.git/internal/calendar"
+	"github.com/RudinMaxim/BarberBot.git/internal/metrics"
+	"github.com/RudinMaxim/BarberBot.git/internal/server"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"gorm.io/gorm"
 )
 
+const (
+	// webhookBufferSize сколько обновлений вебхука может ждать обработки
+	webhookBufferSize = 100
+	// shutdownTimeout сколько ждём завершения текущих обновлений и рассылки при остановке
+	shutdownTimeout = 20 * time.Second
+	// readinessTimeout сколько ждём ответа зависимостей в /readyz
+	readinessTimeout = 5 * time.Second
+	// После cacheBreakerThreshold ошибок Redis подряд кэш не используется cacheBreakerCooldown
+	cacheBreakerThreshold = 3
+	cacheBreakerCooldown  = 30 * time.Second
+)
+
 type application struct {
+	cfg    *config.Config
 	db     *gorm.DB
 	bot    *tgbotapi.BotAPI
 	cache  *database.RedisCache
-	ctx    context.Context
-	cancel context.CancelFunc
+	server *server.Server
+	// logCloser закрывает файл журнала, если он настроен
+	logCloser io.Closer
+	ctx       context.Context
+	cancel    context.CancelFunc
+	// webhook принимает обновления в режиме webhook, в режиме polling nil
+	webhook *server.WebhookHandler
+	// receivingStopped закрывается, когда новые обновления больше не придут
+	receivingStopped chan struct{}
 }
 
 func main() {
-	app := &application{}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	app := &application{ctx: ctx, cancel: stop, receivingStopped: make(chan struct{})}
 
-	config.LogAction("Initializing application...")
+	slog.Info("initializing application")
 	if err := app.initialize(); err != nil {
-		config.LogAction(fmt.Sprintf("Failed to initialize application: %v", err))
-		log.Fatalf("Failed to initialize application: %v", err)
+		slog.Error("failed to initialize application", "error", err)
+		os.Exit(1)
 	}
 
-	botRepo := bot.NewRepository(app.db, app.cache)
-	botService := bot.NewService(botRepo)
-	botHandler := bot.NewHandler(botService, app.bot)
+	// Репозиторий и диалоги ходят в один Redis, поэтому делят предохранитель
+	cacheBreaker := database.NewCircuitBreaker(cacheBreakerThreshold, cacheBreakerCooldown)
+	botRepo := bot.NewRepository(app.db, database.NewFailOpenCache(app.cache, cacheBreaker))
+	cacheBreaker.OnClose(botRepo.FlushCache)
+	calendarProvider := app.initCalendar()
+	botService := bot.NewService(botRepo, app.cfg.Location(), calendarProvider != nil)
+	conversations := bot.NewFallbackConversationStore(
+		bot.NewRedisConversationStore(database.NewBreakerCache(app.cache, cacheBreaker), app.cfg.Conversation.TTL),
+		bot.NewMemoryConversationStore(app.cfg.Conversation.TTL),
+	)
+	callbacks := bot.NewCallbackCodec(app.cfg.CallbackSecretOrToken())
+	botHandler := bot.NewHandler(botService, app.bot, app.cfg, conversations, callbacks, calendarProvider)
+	reminderDispatcher := bot.NewReminderDispatcher(botService, app.bot, callbacks)
+	calendarSync := bot.NewCalendarSync(botHandler, app.cfg.Calendar.Sync)
+	calendarOutbox := bot.NewCalendarOutbox(botService, calendarProvider)
+	botHandler.RegisterCommands()
+	app.registerHealthChecks(botHandler)
+
+	slog.Info("bot started", "username", app.bot.Self.UserName)
+
+	var workers sync.WaitGroup
 
-	config.LogAction("Bot components created")
-	config.LogAction("Bot started")
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		reminderDispatcher.Run(ctx)
+	}()
 
-	app.runBot(botHandler)
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		calendarSync.Run(ctx)
+	}()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		calendarOutbox.Run(ctx)
+	}()
+
+	updates, err := app.updatesChannel()
+	if err != nil {
+		slog.Error("failed to start receiving updates", "error", err)
+		os.Exit(1)
+	}
+
+	go func() {
+		if err := app.server.Start(); err != nil {
+			slog.Error("HTTP server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	pool := bot.NewWorkerPool(botHandler, app.cfg.Workers.Count, app.cfg.Workers.QueueSize)
+	pool.Start()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		app.runBot(pool, updates)
+		// Дорабатываем обновления, которые уже стоят в очередях
+		pool.Stop()
+	}()
+
+	<-ctx.Done()
+	slog.Info("shutdown signal received, stopping")
+	app.shutdown(&workers)
 }
 
 func (app *application) initialize() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(app.ctx, 5*time.Second)
 	defer cancel()
-	app.ctx = ctx
-	app.cancel = cancel
 
-	config.LogAction("Initializing configuration...")
-	config.Init()
-	config.LogAction("Configuration initialized")
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	app.cfg = cfg
+
+	logCloser, err := config.SetupLogger(cfg.Log)
+	if err != nil {
+		return fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	app.logCloser = logCloser
+	slog.Info("configuration loaded", "mode", cfg.Node.Mode, "telegram_mode", cfg.Telegram.Mode)
 
-	config.LogAction("Initializing database...")
-	if err := app.initDatabase(); err != nil {
+	if err := app.initDatabase(ctx); err != nil {
 		return fmt.Errorf("failed to initialize database: %w", err)
 	}
-	config.LogAction("Database initialized")
 
-	config.LogAction("Initializing cache...")
-	if err := app.initCache(); err != nil {
-		return fmt.Errorf("failed to initialize cache: %w", err)
-	}
-	config.LogAction("Cache initialized")
+	app.initCache(ctx)
 
-	config.LogAction("Initializing bot...")
 	if err := app.initBot(); err != nil {
 		return fmt.Errorf("failed to initialize bot: %w", err)
 	}
-	config.LogAction("Bot initialized")
+
+	app.server = server.NewServer(app.cfg.HTTP.Addr)
+	app.server.Handle("/metrics", metrics.Handler())
 
 	return nil
 }
 
-func (app *application) initDatabase() error {
-	db, err := database.InitDatabase()
+func (app *application) initDatabase(ctx context.Context) error {
+	db, err := database.InitDatabase(app.cfg.Database, app.cfg.Location())
 	if err != nil {
 		return fmt.Errorf("could not initialize database connection: %w", err)
 	}
 
-	if err := database.PingDatabase(db); err != nil {
+	if err := database.PingDatabase(ctx, db); err != nil {
 		return fmt.Errorf("could not ping database: %w", err)
 	}
 
@@ -86,43 +179,208 @@ func (app *application) initDatabase() error {
 }
 
 func (app *application) initBot() error {
-	config.LogAction("Creating new BotAPI instance...")
-	bot, err := tgbotapi.NewBotAPI("8008874726:AAGyfIFIPUDnmDDN0Cr5xjCF84Cf0NzqYLs")
+	// Транспорт считает ошибки Bot API для метрик
+	httpClient := &http.Client{Transport: &metrics.TelegramTransport{}}
+	bot, err := tgbotapi.NewBotAPIWithClient(app.cfg.Telegram.Token, tgbotapi.APIEndpoint, httpClient)
 	if err != nil {
-		config.LogAction(fmt.Sprintf("Failed to create BotAPI: %v", err))
 		return err
 	}
-	config.LogAction("BotAPI instance created successfully")
 
 	app.bot = bot
 	return nil
 }
 
-func (app *application) initCache() error {
-	redisCache := database.NewRedisCache("redis:6379")
+// initCache подключает Redis. Кэш необязателен: если Redis недоступен,
+// бот стартует и работает напрямую с базой, пока Redis не вернётся.
+func (app *application) initCache(ctx context.Context) {
+	app.cache = database.NewRedisCache(app.cfg.Cache)
 
-	if err := redisCache.Ping(app.ctx); err != nil {
-		return fmt.Errorf("failed to ping Redis: %w", err)
+	if err := app.cache.Ping(ctx); err != nil {
+		slog.Warn("Redis is unavailable, starting without cache", "host", app.cfg.Cache.Host, "error", err)
+		return
 	}
+	slog.Info("cache initialized", "host", app.cfg.Cache.Host)
+}
 
-	app.cache = redisCache
-	return nil
+// initCalendar подключает календарь мастера. Ошибка не мешает запуску: бот
+// работает без календаря. Для OAuth Google регистрирует адрес возврата после согласия.
+func (app *application) initCalendar() calendar.CalendarProvider {
+	cfg := app.cfg.Calendar
+
+	var tokens calendar.TokenStore
+	if cfg.Provider == config.CalendarProviderGoogle && cfg.Google.Auth == config.GoogleAuthOAuth {
+		if cfg.Google.TokenKey == "" {
+			slog.Warn("calendar.google.token_key (GOOGLE_TOKEN_KEY) is not set, the OAuth token cannot be stored; continuing without calendar")
+			return nil
+		}
+		store, err := calendar.NewDBTokenStore(app.db, config.CalendarProviderGoogle, cfg.Google.TokenKey)
+		if err != nil {
+			slog.Error("error initializing calendar token store, continuing without calendar", "error", err)
+			return nil
+		}
+		tokens = store
+	}
+
+	provider, err := calendar.NewProvider(cfg, app.cfg.Location(), tokens)
+	if err != nil {
+		slog.Error("error initializing calendar, continuing without it", "provider", cfg.Provider, "error", err)
+		return nil
+	}
+
+	if authorizer, ok := calendar.AuthorizerOf(provider); ok {
+		app.server.Handle(calendar.GoogleOAuthCallbackPath, calendar.OAuthCallbackHandler(authorizer))
+	}
+	return provider
 }
 
-func (app *application) runBot(handler *bot.Handler) {
+// registerHealthChecks подключает /healthz и /readyz. Redis и календарь
+// необязательны: без них бот работает, но /readyz сообщает degraded.
+func (app *application) registerHealthChecks(botHandler *bot.Handler) {
+	app.server.Handle("/healthz", server.LivenessHandler())
+	app.server.Handle("/readyz", server.NewReadinessHandler(readinessTimeout,
+		server.Check{
+			Name: "postgres",
+			Probe: func(ctx context.Context) error {
+				return database.PingDatabase(ctx, app.db)
+			},
+		},
+		server.Check{
+			Name:     "redis",
+			Optional: true,
+			Probe:    app.cache.Ping,
+		},
+		server.Check{
+			Name: "telegram",
+			Probe: func(ctx context.Context) error {
+				_, err := app.bot.GetMe()
+				return err
+			},
+		},
+		server.Check{
+			Name:     "calendar",
+			Optional: true,
+			Probe:    botHandler.PingCalendar,
+		},
+	))
+}
+
+// updatesChannel возвращает канал обновлений в зависимости от telegram.mode.
+// В режиме webhook обновления приходят через встроенный HTTP-сервер.
+func (app *application) updatesChannel() (tgbotapi.UpdatesChannel, error) {
+	if app.cfg.Telegram.Mode == config.TelegramModeWebhook {
+		webhook := app.cfg.Telegram.Webhook
+		handler := server.NewWebhookHandler(webhook.Secret, webhookBufferSize)
+		app.server.Handle(webhook.Path, handler)
+		app.webhook = handler
+
+		if err := server.SetWebhook(app.bot, webhook.URL, webhook.Path, webhook.Secret); err != nil {
+			return nil, err
+		}
+
+		slog.Info("receiving updates via webhook", "path", webhook.Path)
+		return handler.Updates(), nil
+	}
+
+	// getUpdates не работает, пока у бота зарегистрирован вебхук
+	if _, err := app.bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		return nil, fmt.Errorf("failed to delete webhook: %w", err)
+	}
+
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 180
 
-	config.LogAction("Starting to receive updates...")
-	updates := app.bot.GetUpdatesChan(u)
+	slog.Info("receiving updates via long polling")
+	return app.bot.GetUpdatesChan(u), nil
+}
 
-	for update := range updates {
-		if update.Message != nil {
-			config.LogAction(fmt.Sprintf("Received message from user %d: %s", update.Message.From.ID, update.Message.Text))
-			handler.HandleUpdate(update)
-		} else if update.CallbackQuery != nil {
-			config.LogAction(fmt.Sprintf("Received callback query from user %d: %s", update.CallbackQuery.From.ID, update.CallbackQuery.Data))
-			handler.HandleUpdate(update)
+// runBot раздаёт обновления воркерам, пока shutdown не остановит приём,
+// затем передаёт воркерам то, что осталось в буфере канала.
+func (app *application) runBot(pool *bot.WorkerPool, updates tgbotapi.UpdatesChannel) {
+	for {
+		select {
+		case <-app.receivingStopped:
+			app.drainUpdates(pool, updates)
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			app.dispatch(pool, update)
 		}
 	}
 }
+
+// drainUpdates читает канал, пока он не закрыт или не опустел
+func (app *application) drainUpdates(pool *bot.WorkerPool, updates tgbotapi.UpdatesChannel) {
+	drained := 0
+	defer func() {
+		if drained > 0 {
+			slog.Info("dispatched buffered updates", "count", drained)
+		}
+	}()
+
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			app.dispatch(pool, update)
+			drained++
+		default:
+			return
+		}
+	}
+}
+
+// dispatch ждёт места в очереди воркера. Время ожидания при остановке
+// ограничивает shutdownTimeout.
+func (app *application) dispatch(pool *bot.WorkerPool, update tgbotapi.Update) {
+	if update.Message == nil && update.CallbackQuery == nil {
+		return
+	}
+	if err := pool.Dispatch(context.Background(), update); err != nil {
+		slog.Error("failed to dispatch update", "update_id", update.UpdateID, "error", err)
+	}
+}
+
+// shutdown перестаёт принимать обновления, ждёт текущие обработчики и рассылку
+// напоминаний не дольше shutdownTimeout, затем закрывает соединения.
+func (app *application) shutdown(workers *sync.WaitGroup) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	app.bot.StopReceivingUpdates()
+	if app.webhook != nil {
+		app.webhook.Close()
+	}
+	if err := app.server.Shutdown(ctx); err != nil {
+		slog.Error("failed to stop HTTP server", "error", err)
+	}
+	close(app.receivingStopped)
+
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		slog.Info("in-flight updates finished")
+	case <-ctx.Done():
+		slog.Warn("shutdown timeout exceeded, dropping in-flight work")
+	}
+
+	if err := app.cache.Close(); err != nil {
+		slog.Error("failed to close Redis client", "error", err)
+	}
+	if err := database.CloseDatabase(app.db); err != nil {
+		slog.Error("failed to close database", "error", err)
+	}
+
+	slog.Info("application stopped")
+	if err := app.logCloser.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to close log file: %v\n", err)
+	}
+}
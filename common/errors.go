@@ -4,4 +4,5 @@ import "errors"
 
 var (
 	ErrTelegramTokenNotFound = errors.New("TELEGRAM_TOKEN not set in environment")
+	ErrSlotTaken             = errors.New("selected time slot has just been taken")
 )
@@ -26,12 +26,14 @@ type Service struct {
 	Name      string    `gorm:"type:varchar(255);not null" json:"name"`
 	Duration  int       `gorm:"not null" json:"duration"`
 	Price     float64   `gorm:"type:decimal(10,2);not null" json:"price"`
+	SortOrder int       `gorm:"not null;default:0" json:"sort_order"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	IsActive  bool      `gorm:"default:true" json:"is_active"`
 }
 
-// WorkingHours модель рабочих часов
+// WorkingHours модель рабочих часов. Из StartTime и EndTime используется только
+// время суток в поясе салона, поэтому они остаются timestamp без пояса.
 type WorkingHours struct {
 	UUID      uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"uuid"`
 	DayOfWeek int       `json:"day_of_week"`
@@ -42,17 +44,69 @@ type WorkingHours struct {
 	IsActive  bool      `json:"is_active"`
 }
 
+// ScheduleException модель исключения из графика на конкретную дату:
+// выходной (IsClosed) или изменённые часы работы (StartTime, EndTime)
+type ScheduleException struct {
+	UUID      uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"uuid"`
+	Date      time.Time  `gorm:"type:date;uniqueIndex;not null" json:"date"`
+	IsClosed  bool       `gorm:"not null;default:false" json:"is_closed"`
+	StartTime *time.Time `gorm:"type:timestamp" json:"start_time,omitempty"`
+	EndTime   *time.Time `gorm:"type:timestamp" json:"end_time,omitempty"`
+	Reason    string     `json:"reason"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// Break модель регулярного перерыва внутри рабочего дня, время суток как в WorkingHours
+type Break struct {
+	UUID      uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"uuid"`
+	DayOfWeek int       `gorm:"index;not null" json:"day_of_week"`
+	StartTime time.Time `gorm:"type:timestamp;not null" json:"start_time"`
+	EndTime   time.Time `gorm:"type:timestamp;not null" json:"end_time"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Источники блокировок: manual задаёт мастер в боте, calendar создаёт синхронизация
+// с календарём и пересоздаёт при каждом проходе
+const (
+	BlockSourceManual   = "manual"
+	BlockSourceCalendar = "calendar"
+)
+
+// BlockedRange модель разово заблокированного интервала времени
+type BlockedRange struct {
+	UUID       uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"uuid"`
+	StartTime  time.Time `gorm:"type:timestamptz;index;not null" json:"start_time"`
+	EndTime    time.Time `gorm:"type:timestamptz;index;not null" json:"end_time"`
+	Reason     string    `json:"reason"`
+	Source     string    `gorm:"type:varchar(20);index;not null;default:'manual'" json:"source"`
+	ExternalID string    `gorm:"index" json:"external_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// OAuthToken токен доступа к внешнему сервису, Token зашифрован AES-GCM
+type OAuthToken struct {
+	Provider  string    `gorm:"type:varchar(50);primary_key" json:"provider"`
+	Token     []byte    `gorm:"type:bytea;not null" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 type Appointment struct {
 	UUID            uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"uuid"`
 	ClientID        uuid.UUID `gorm:"type:uuid;not null" json:"client_id"`
-	StartTime       time.Time `gorm:"type:timestamp" json:"start_time"`
-	EndTime         time.Time `gorm:"type:timestamp" json:"end_time"`
+	StartTime       time.Time `gorm:"type:timestamptz" json:"start_time"`
+	EndTime         time.Time `gorm:"type:timestamptz" json:"end_time"`
 	Name            string    `gorm:"not null" json:"name"`
 	TotalPrice      float64   `gorm:"type:decimal(10,2);not null" json:"total_price"`
 	Status          string    `gorm:"type:varchar(20);not null" json:"status"`
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
 	CancelledAt     time.Time `json:"cancelled_at,omitempty"`
+	ConfirmedAt     time.Time `json:"confirmed_at,omitempty"`
 	Services        []Service `gorm:"many2many:appointment_services;" json:"services"`
 	CalendarEventID string    `gorm:"column:calendar_event_id"`
 }
@@ -61,3 +115,48 @@ type AppointmentService struct {
 	AppointmentID uuid.UUID `gorm:"type:uuid;primary_key" json:"appointment_id"`
 	ServiceID     uuid.UUID `gorm:"type:uuid;primary_key" json:"service_id"`
 }
+
+// Reminder модель отложенного уведомления
+type Reminder struct {
+	UUID          uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"uuid"`
+	AppointmentID uuid.UUID  `gorm:"type:uuid;index;not null" json:"appointment_id"`
+	ChatID        int64      `gorm:"not null" json:"chat_id"`
+	Message       string     `gorm:"type:text;not null" json:"message"`
+	NotifyAt      time.Time  `gorm:"type:timestamptz;index;not null" json:"notify_at"`
+	Status        string     `gorm:"type:varchar(20);index;not null" json:"status"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	LockedUntil   *time.Time `gorm:"type:timestamptz" json:"locked_until,omitempty"`
+	SentAt        *time.Time `gorm:"type:timestamptz" json:"sent_at,omitempty"`
+	// CorrelationID обновление Telegram, из-за которого напоминание создано
+	CorrelationID string    `gorm:"type:varchar(36)" json:"correlation_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Действия CalendarOperation
+const (
+	// CalendarOpUpsert приводит событие записи к её текущему состоянию, создавая его при необходимости
+	CalendarOpUpsert = "upsert"
+	// CalendarOpDelete удаляет событие EventID
+	CalendarOpDelete = "delete"
+)
+
+// CalendarOperation операция с календарём мастера в исходящей очереди (outbox).
+// Создаётся в одной транзакции с изменением записи и выполняется фоновым обработчиком.
+type CalendarOperation struct {
+	UUID          uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"uuid"`
+	AppointmentID uuid.UUID `gorm:"type:uuid;index;not null" json:"appointment_id"`
+	Action        string    `gorm:"type:varchar(20);not null" json:"action"`
+	// EventID событие, с которым работает операция. Для новой записи это ID,
+	// под которым событие будет создано, он же ключ идемпотентности.
+	EventID       string     `gorm:"not null" json:"event_id"`
+	Status        string     `gorm:"type:varchar(20);index;not null" json:"status"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"type:timestamptz;index;not null" json:"next_attempt_at"`
+	LockedUntil   *time.Time `gorm:"type:timestamptz" json:"locked_until,omitempty"`
+	LastError     string     `gorm:"type:text" json:"last_error,omitempty"`
+	// CorrelationID обновление Telegram, из-за которого операция поставлена в очередь
+	CorrelationID string    `gorm:"type:varchar(36)" json:"correlation_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
@@ -1,75 +1,481 @@
 package config
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"os"
+	"strings"
+	"time"
+	// База часовых поясов встроена в бинарник: в образе может не быть tzdata
+	_ "time/tzdata"
 
+	"github.com/RudinMaxim/BarberBot.git/common"
 	"github.com/joho/godotenv"
 	"github.com/spf13/viper"
 )
 
 var Texts map[string]string
 
-func Init() {
+const (
+	TelegramModePolling = "polling"
+	TelegramModeWebhook = "webhook"
+)
+
+// Config настройки приложения. Значения берутся из config.yaml, переменных
+// окружения и файлов секретов (<ПЕРЕМЕННАЯ>_FILE), в порядке возрастания приоритета.
+type Config struct {
+	Node         Node         `mapstructure:"node"`
+	Telegram     Telegram     `mapstructure:"telegram"`
+	Database     Database     `mapstructure:"database"`
+	Cache        Cache        `mapstructure:"cache"`
+	HTTP         HTTP         `mapstructure:"http"`
+	Workers      Workers      `mapstructure:"workers"`
+	Conversation Conversation `mapstructure:"conversation"`
+	Log          Log          `mapstructure:"log"`
+	Calendar     Calendar     `mapstructure:"calendar"`
+	Admins       []int64      `mapstructure:"admins"`
+	Reminders    []string     `mapstructure:"reminders"`
+	// Timezone часовой пояс салона (IANA), в нём считаются рабочие часы и слоты
+	Timezone string `mapstructure:"timezone"`
+
+	location *time.Location
+}
+
+type Node struct {
+	Mode string `mapstructure:"mode"`
+}
+
+type Telegram struct {
+	Token          string  `mapstructure:"token"`
+	Mode           string  `mapstructure:"mode"`
+	CallbackSecret string  `mapstructure:"callback_secret"`
+	Webhook        Webhook `mapstructure:"webhook"`
+}
+
+// Webhook настройки приёма обновлений Telegram через HTTP. URL публичный адрес
+// бота, Path секретный путь на встроенном сервере, Secret значение заголовка
+// X-Telegram-Bot-Api-Secret-Token.
+type Webhook struct {
+	URL    string `mapstructure:"url"`
+	Path   string `mapstructure:"path"`
+	Secret string `mapstructure:"secret"`
+}
+
+type Database struct {
+	Host            string `mapstructure:"host"`
+	Port            string `mapstructure:"port"`
+	User            string `mapstructure:"user"`
+	Password        string `mapstructure:"password"`
+	Name            string `mapstructure:"name"`
+	SSLMode         string `mapstructure:"ssl_mode"`
+	MaxIdleConns    int    `mapstructure:"max_idle_conns"`
+	MaxOpenConns    int    `mapstructure:"max_open_conns"`
+	ConnMaxLifetime int    `mapstructure:"conn_max_lifetime"`
+}
+
+type Cache struct {
+	Host     string `mapstructure:"host"`
+	Password string `mapstructure:"password"`
+}
+
+type HTTP struct {
+	Addr string `mapstructure:"addr"`
+}
+
+const (
+	CalendarProviderNone   = "none"
+	CalendarProviderGoogle = "google"
+	CalendarProviderCalDAV = "caldav"
+)
+
+// Calendar календарь мастера, в который бот заносит записи
+type Calendar struct {
+	Provider string         `mapstructure:"provider"`
+	Google   GoogleCalendar `mapstructure:"google"`
+	CalDAV   CalDAV         `mapstructure:"caldav"`
+	Sync     CalendarSync   `mapstructure:"sync"`
+}
+
+const (
+	GoogleAuthOAuth          = "oauth"
+	GoogleAuthServiceAccount = "service_account"
+)
+
+// GoogleCalendar доступ к Google Calendar. При auth: oauth мастер выдаёт доступ
+// командой /calendar, токен хранится в базе зашифрованным ключом TokenKey.
+// TokenFile читается только для переноса старого токена в базу.
+// При auth: service_account календарь нужно открыть сервисному аккаунту
+// и указать его calendar_id.
+type GoogleCalendar struct {
+	Auth               string `mapstructure:"auth"`
+	CredentialsFile    string `mapstructure:"credentials_file"`
+	ServiceAccountFile string `mapstructure:"service_account_file"`
+	TokenFile          string `mapstructure:"token_file"`
+	TokenKey           string `mapstructure:"token_key"`
+	// RedirectURL внешний адрес /oauth/google/callback; пустой берётся из credentials
+	RedirectURL string `mapstructure:"redirect_url"`
+	CalendarID  string `mapstructure:"calendar_id"`
+}
+
+// CalDAV подключение к календарю по CalDAV (Nextcloud, Яндекс Календарь, iCloud).
+// URL адрес коллекции календаря, Password обычно пароль приложения.
+type CalDAV struct {
+	URL      string `mapstructure:"url"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// CalendarSync чтение календаря мастера: его личные события блокируют слоты.
+// Interval 0 отключает синхронизацию, Horizon насколько вперёд читается календарь.
+type CalendarSync struct {
+	Interval time.Duration `mapstructure:"interval"`
+	Horizon  time.Duration `mapstructure:"horizon"`
+}
+
+type Workers struct {
+	Count     int `mapstructure:"count"`
+	QueueSize int `mapstructure:"queue_size"`
+}
+
+type Conversation struct {
+	TTL time.Duration `mapstructure:"ttl"`
+}
+
+// envBindings имена переменных окружения для ключей конфигурации
+var envBindings = map[string]string{
+	"node.mode":                            "NODE_MODE",
+	"telegram.token":                       "TELEGRAM_TOKEN",
+	"telegram.mode":                        "TELEGRAM_MODE",
+	"telegram.callback_secret":             "TELEGRAM_CALLBACK_SECRET",
+	"telegram.webhook.url":                 "TELEGRAM_WEBHOOK_URL",
+	"telegram.webhook.path":                "TELEGRAM_WEBHOOK_PATH",
+	"telegram.webhook.secret":              "TELEGRAM_WEBHOOK_SECRET",
+	"database.host":                        "DB_HOST",
+	"database.port":                        "DB_PORT",
+	"database.user":                        "DB_USER",
+	"database.password":                    "DB_PASSWORD",
+	"database.name":                        "DB_NAME",
+	"database.ssl_mode":                    "DB_SSL_MODE",
+	"database.max_idle_conns":              "DB_MAX_IDLE_CONNS",
+	"database.max_open_conns":              "DB_MAX_OPEN_CONNS",
+	"database.conn_max_lifetime":           "DB_CONN_MAX_LIFETIME",
+	"cache.host":                           "REDIS_HOST",
+	"cache.password":                       "REDIS_PASSWORD",
+	"http.addr":                            "HTTP_ADDR",
+	"workers.count":                        "WORKERS_COUNT",
+	"workers.queue_size":                   "WORKERS_QUEUE_SIZE",
+	"conversation.ttl":                     "CONVERSATION_TTL",
+	"log.level":                            "LOG_LEVEL",
+	"log.format":                           "LOG_FORMAT",
+	"log.file":                             "LOG_FILE",
+	"log.max_size_mb":                      "LOG_MAX_SIZE_MB",
+	"log.max_backups":                      "LOG_MAX_BACKUPS",
+	"log.max_age_days":                     "LOG_MAX_AGE_DAYS",
+	"log.compress":                         "LOG_COMPRESS",
+	"calendar.provider":                    "CALENDAR_PROVIDER",
+	"calendar.google.auth":                 "GOOGLE_AUTH",
+	"calendar.google.credentials_file":     "GOOGLE_CREDENTIALS_FILE",
+	"calendar.google.service_account_file": "GOOGLE_SERVICE_ACCOUNT_FILE",
+	"calendar.google.token_key":            "GOOGLE_TOKEN_KEY",
+	"calendar.google.redirect_url":         "GOOGLE_REDIRECT_URL",
+	"calendar.google.token_file":           "GOOGLE_TOKEN_FILE",
+	"calendar.google.calendar_id":          "GOOGLE_CALENDAR_ID",
+	"calendar.caldav.url":                  "CALDAV_URL",
+	"calendar.caldav.username":             "CALDAV_USERNAME",
+	"calendar.caldav.password":             "CALDAV_PASSWORD",
+	"calendar.sync.interval":               "CALENDAR_SYNC_INTERVAL",
+	"calendar.sync.horizon":                "CALENDAR_SYNC_HORIZON",
+	"admins":                               "ADMINS",
+	"reminders":                            "REMINDERS",
+	"timezone":                             "TIMEZONE",
+}
+
+// secretKeys ключи, которые можно передать файлом через <ПЕРЕМЕННАЯ>_FILE (Docker secrets)
+var secretKeys = []string{
+	"telegram.token",
+	"telegram.callback_secret",
+	"telegram.webhook.secret",
+	"database.user",
+	"database.password",
+	"cache.password",
+	"calendar.caldav.password",
+	"calendar.google.token_key",
+}
+
+// Load читает конфигурацию и тексты, проверяет обязательные значения.
+func Load() (*Config, error) {
 	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, proceeding without it.")
+		slog.Info("no .env file found, proceeding without it")
 	}
 
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
+	v := viper.New()
+	v.SetConfigName("config")
+	v.SetConfigType("yaml")
+
+	v.AddConfigPath(".")
+	v.AddConfigPath("./config")
+	v.AddConfigPath("../config")
 
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("./config")
-	viper.AddConfigPath("../config")
+	setDefaults(v)
 
-	viper.AutomaticEnv()
+	for key, env := range envBindings {
+		if err := v.BindEnv(key, env); err != nil {
+			return nil, fmt.Errorf("failed to bind %s: %w", env, err)
+		}
+	}
 
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			log.Println("Config file not found. Creating a default config file.")
-			createDefaultConfig()
-		} else {
-			log.Fatalf("Error reading config file: %v", err)
+	if err := v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return nil, fmt.Errorf("error reading config file: %w", err)
 		}
+		slog.Info("config file not found, using environment only")
 	} else {
-		log.Println("Using config file:", viper.ConfigFileUsed())
+		slog.Info("using config file", "path", v.ConfigFileUsed())
+	}
+
+	for _, key := range secretKeys {
+		if err := readSecretFile(v, key); err != nil {
+			return nil, err
+		}
+	}
+
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("unable to decode config: %w", err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	// Пояс уже проверен в Validate
+	cfg.location, _ = time.LoadLocation(cfg.Timezone)
+
+	if err := loadTexts(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("node.mode", "production")
+	v.SetDefault("telegram.mode", TelegramModePolling)
+	v.SetDefault("database.port", "5432")
+	v.SetDefault("database.ssl_mode", "disable")
+	v.SetDefault("database.max_idle_conns", 10)
+	v.SetDefault("database.max_open_conns", 100)
+	v.SetDefault("database.conn_max_lifetime", 3600)
+	v.SetDefault("cache.host", "redis:6379")
+	v.SetDefault("http.addr", ":8080")
+	v.SetDefault("workers.count", 8)
+	v.SetDefault("workers.queue_size", 100)
+	v.SetDefault("conversation.ttl", "24h")
+	v.SetDefault("log.level", "info")
+	v.SetDefault("log.format", LogFormatText)
+	v.SetDefault("log.max_size_mb", 100)
+	v.SetDefault("log.max_backups", 5)
+	v.SetDefault("log.max_age_days", 30)
+	v.SetDefault("calendar.provider", CalendarProviderGoogle)
+	v.SetDefault("calendar.google.auth", GoogleAuthOAuth)
+	v.SetDefault("calendar.google.credentials_file", "credentials/credentials.json")
+	v.SetDefault("calendar.google.service_account_file", "credentials/service_account.json")
+	v.SetDefault("calendar.google.token_file", "credentials/token.json")
+	v.SetDefault("calendar.google.calendar_id", "primary")
+	v.SetDefault("calendar.sync.interval", "5m")
+	v.SetDefault("calendar.sync.horizon", "720h")
+	v.SetDefault("reminders", defaultReminders)
+	v.SetDefault("admins", []int64{})
+	v.SetDefault("timezone", "Asia/Yekaterinburg")
+}
+
+// readSecretFile подставляет значение из файла, если задана переменная <ИМЯ>_FILE
+func readSecretFile(v *viper.Viper, key string) error {
+	path := os.Getenv(envBindings[key] + "_FILE")
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s_FILE: %w", envBindings[key], err)
+	}
+	v.Set(key, strings.TrimSpace(string(data)))
+	return nil
+}
+
+func loadTexts() error {
+	// Тексты читаются отдельным экземпляром, чтобы не смешивать их с настройками
+	texts := viper.New()
+	texts.SetConfigName("texts")
+	texts.SetConfigType("yaml")
+
+	texts.AddConfigPath(".")
+	texts.AddConfigPath("./texts")
+	texts.AddConfigPath("../texts")
+
+	if err := texts.ReadInConfig(); err != nil {
+		return fmt.Errorf("error reading texts file: %w", err)
+	}
+
+	if err := texts.Unmarshal(&Texts); err != nil {
+		return fmt.Errorf("unable to decode texts into map: %w", err)
+	}
+
+	return nil
+}
+
+// Validate проверяет обязательные значения и возвращает все ошибки разом.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Telegram.Token == "" {
+		errs = append(errs, common.ErrTelegramTokenNotFound)
+	}
+
+	switch c.Telegram.Mode {
+	case TelegramModePolling:
+	case TelegramModeWebhook:
+		if c.Telegram.Webhook.URL == "" {
+			errs = append(errs, errors.New("telegram.webhook.url (TELEGRAM_WEBHOOK_URL) is required in webhook mode"))
+		}
+		if !strings.HasPrefix(c.Telegram.Webhook.Path, "/") {
+			errs = append(errs, errors.New("telegram.webhook.path (TELEGRAM_WEBHOOK_PATH) must start with /"))
+		}
+		if c.Telegram.Webhook.Secret == "" {
+			errs = append(errs, errors.New("telegram.webhook.secret (TELEGRAM_WEBHOOK_SECRET) is required in webhook mode"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("telegram.mode must be %q or %q, got %q", TelegramModePolling, TelegramModeWebhook, c.Telegram.Mode))
+	}
+
+	if c.Database.Host == "" {
+		errs = append(errs, errors.New("database.host (DB_HOST) is required"))
+	}
+	if c.Database.User == "" {
+		errs = append(errs, errors.New("database.user (DB_USER) is required"))
+	}
+	if c.Database.Name == "" {
+		errs = append(errs, errors.New("database.name (DB_NAME) is required"))
+	}
+	if c.Cache.Host == "" {
+		errs = append(errs, errors.New("cache.host (REDIS_HOST) is required"))
+	}
+
+	if c.Workers.Count <= 0 {
+		errs = append(errs, errors.New("workers.count must be positive"))
+	}
+	if c.Workers.QueueSize <= 0 {
+		errs = append(errs, errors.New("workers.queue_size must be positive"))
+	}
+	if c.Conversation.TTL <= 0 {
+		errs = append(errs, errors.New("conversation.ttl must be positive"))
+	}
+	if c.Timezone == "" {
+		errs = append(errs, errors.New("timezone is required"))
+	} else if _, err := time.LoadLocation(c.Timezone); err != nil {
+		errs = append(errs, fmt.Errorf("timezone must be an IANA zone like Asia/Yekaterinburg: %w", err))
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("log.level must be debug, info, warn or error, got %q", c.Log.Level))
+	}
+	if c.Log.Format != LogFormatJSON && c.Log.Format != LogFormatText {
+		errs = append(errs, fmt.Errorf("log.format must be %q or %q, got %q", LogFormatJSON, LogFormatText, c.Log.Format))
+	}
+
+	if c.Calendar.Sync.Interval < 0 {
+		errs = append(errs, errors.New("calendar.sync.interval must not be negative"))
+	}
+	if c.Calendar.Sync.Interval > 0 && c.Calendar.Sync.Horizon <= 0 {
+		errs = append(errs, errors.New("calendar.sync.horizon must be positive"))
+	}
+
+	switch c.Calendar.Provider {
+	case CalendarProviderNone:
+	case CalendarProviderGoogle:
+		errs = append(errs, c.Calendar.Google.validate()...)
+	case CalendarProviderCalDAV:
+		if c.Calendar.CalDAV.URL == "" {
+			errs = append(errs, errors.New("calendar.caldav.url (CALDAV_URL) is required for the caldav provider"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("calendar.provider must be %q, %q or %q, got %q",
+			CalendarProviderNone, CalendarProviderGoogle, CalendarProviderCalDAV, c.Calendar.Provider))
 	}
 
-	viper.SetConfigName("texts")
-	viper.SetConfigType("yaml")
+	for _, value := range c.Reminders {
+		if offset, err := time.ParseDuration(value); err != nil || offset <= 0 {
+			errs = append(errs, fmt.Errorf("invalid reminder offset %q", value))
+		}
+	}
 
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("./texts")
-	viper.AddConfigPath("../texts")
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
 
-	if err := viper.ReadInConfig(); err != nil {
-		log.Fatalf("Error reading texts file: %v", err)
+// Location часовой пояс салона. Все даты и время, которые видят клиенты
+// и мастер, считаются в нём.
+func (c *Config) Location() *time.Location {
+	if c.location == nil {
+		return time.UTC
 	}
+	return c.location
+}
 
-	if err := viper.Unmarshal(&Texts); err != nil {
-		log.Fatalf("Unable to decode texts into map: %v", err)
+// CallbackSecretOrToken ключ подписи callback-данных кнопок. Если не задан,
+// используется токен бота, чтобы кнопки оставались валидными после перезапуска.
+func (c *Config) CallbackSecretOrToken() string {
+	if c.Telegram.CallbackSecret != "" {
+		return c.Telegram.CallbackSecret
 	}
+	return c.Telegram.Token
 }
 
-func createDefaultConfig() {
-	viper.SetDefault("node.mode", os.Getenv("NODE_MODE"))
+// defaultReminders смещения напоминаний, если reminders не задан ни в файле, ни в окружении
+var defaultReminders = []string{"24h", "2h"}
 
-	viper.SetDefault("telegram.token", os.Getenv("TELEGRAM_TOKEN"))
+// Reminder описывает одно напоминание: за сколько до записи его отправить и каким текстом.
+type Reminder struct {
+	Offset  time.Duration
+	TextKey string
+}
 
-	viper.SetDefault("database.host", os.Getenv("DB_HOST"))
-	viper.SetDefault("database.port", os.Getenv("DB_PORT"))
-	viper.SetDefault("database.user", os.Getenv("DB_USER"))
-	viper.SetDefault("database.password", os.Getenv("DB_PASSWORD"))
-	viper.SetDefault("database.name", os.Getenv("DB_NAME"))
-	viper.SetDefault("database.max_idle_conns", 10)
-	viper.SetDefault("database.max_open_conns", 100)
-	viper.SetDefault("database.conn_max_lifetime", 3600)
-	viper.SetDefault("cache.host", os.Getenv("REDIS_HOST"))
+// ReminderSchedule разбирает список смещений из ключа reminders (например, [24h, 2h]).
+// Текст каждого напоминания берётся из texts.yaml по ключу reminder_<смещение>.
+func (c *Config) ReminderSchedule() []Reminder {
+	values := c.Reminders
+	if len(values) == 0 {
+		values = defaultReminders
+	}
 
-	if err := viper.SafeWriteConfig(); err != nil {
-		log.Fatalf("Error writing default config file: %v", err)
+	reminders := make([]Reminder, 0, len(values))
+	for _, value := range values {
+		offset, err := time.ParseDuration(value)
+		if err != nil || offset <= 0 {
+			slog.Warn("skipping invalid reminder offset", "offset", value, "error", err)
+			continue
+		}
+		reminders = append(reminders, Reminder{
+			Offset:  offset,
+			TextKey: "reminder_" + value,
+		})
 	}
 
-	log.Println("Default config file created. Please edit it with your settings and restart the application.")
-	os.Exit(0)
+	return reminders
+}
+
+// validate не требует token_key: без него бот запускается без календаря
+// (см. initCalendar), чтобы обновление не ломало существующие установки.
+func (g GoogleCalendar) validate() []error {
+	switch g.Auth {
+	case GoogleAuthOAuth, GoogleAuthServiceAccount:
+	default:
+		return []error{fmt.Errorf("calendar.google.auth must be %q or %q, got %q",
+			GoogleAuthOAuth, GoogleAuthServiceAccount, g.Auth)}
+	}
+	return nil
 }
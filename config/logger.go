@@ -1,26 +1,163 @@
 package config
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
-	"sync"
+	"regexp"
+	"strings"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
 )
 
-var (
-	actionLogger *log.Logger
-	once         sync.Once
+// Log настройки журнала. Если File пуст, записи пишутся в stdout,
+// иначе в файл с ротацией по размеру.
+type Log struct {
+	Level      string `mapstructure:"level"`
+	Format     string `mapstructure:"format"`
+	File       string `mapstructure:"file"`
+	MaxSizeMB  int    `mapstructure:"max_size_mb"`
+	MaxBackups int    `mapstructure:"max_backups"`
+	MaxAgeDays int    `mapstructure:"max_age_days"`
+	Compress   bool   `mapstructure:"compress"`
+}
+
+// SetupLogger настраивает slog по умолчанию, в том числе для стандартного log.
+// Возвращённый io.Closer закрывает файл журнала при остановке.
+func SetupLogger(cfg Log) (io.Closer, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", cfg.Level, err)
+	}
+
+	var out io.WriteCloser = nopCloser{os.Stdout}
+	if cfg.File != "" {
+		out = &lumberjack.Logger{
+			Filename:   cfg.File,
+			MaxSize:    cfg.MaxSizeMB,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAgeDays,
+			Compress:   cfg.Compress,
+		}
+	}
+
+	options := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}
+
+	var handler slog.Handler
+	switch cfg.Format {
+	case LogFormatJSON:
+		handler = slog.NewJSONHandler(out, options)
+	case LogFormatText:
+		handler = slog.NewTextHandler(out, options)
+	default:
+		return nil, fmt.Errorf("invalid log format %q", cfg.Format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return out, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// ================Context==================
+
+// LogKeyCorrelationID связывает записи журнала одного обновления Telegram.
+// Напоминания и операции календаря хранят его, поэтому отложенная работа
+// пишется в журнал под тем же идентификатором.
+const LogKeyCorrelationID = "correlation_id"
+
+type loggerContextKey struct{}
+
+// WithLogger кладёт журнал запроса в контекст
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// LoggerFrom возвращает журнал из контекста, а без него журнал по умолчанию
+func LoggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// ================Redaction==================
+
+// Ключи, значения которых никогда не попадают в журнал целиком
+const (
+	LogKeyText  = "text"
+	LogKeyPhone = "phone"
 )
 
-func init() {
-	once.Do(func() {
-		actionFile, err := os.OpenFile("actions.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			log.Fatal(err)
+// phonePattern российские номера в форматах +7 900 123-45-67, 89001234567 и т.п.
+var phonePattern = regexp.MustCompile(`(?:\+7|\b8)[\s\-(]*\d{3}[\s\-)]*\d{3}[\s\-]*\d{2}[\s\-]*\d{2}\b|\+\d{10,14}\b`)
+
+// redactAttr скрывает текст сообщений и номера телефонов: по ключу атрибута
+// и по совпадению с шаблоном номера в любой строке, включая сообщение записи.
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	switch a.Key {
+	case LogKeyText:
+		return slog.String(a.Key, fmt.Sprintf("[%d chars]", len([]rune(a.Value.String()))))
+	case LogKeyPhone:
+		return slog.String(a.Key, MaskPhone(a.Value.String()))
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		if value := a.Value.String(); phonePattern.MatchString(value) {
+			return slog.String(a.Key, RedactPhones(value))
+		}
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok && phonePattern.MatchString(err.Error()) {
+			return slog.String(a.Key, RedactPhones(err.Error()))
 		}
-		actionLogger = log.New(actionFile, "ACTION: ", log.Ldate|log.Ltime|log.Lshortfile)
-	})
+	}
+	return a
+}
+
+// RedactPhones маскирует все номера телефонов в строке
+func RedactPhones(s string) string {
+	return phonePattern.ReplaceAllStringFunc(s, MaskPhone)
 }
 
-func LogAction(message string) {
-	actionLogger.Println(message)
+// MaskPhone оставляет от номера только две последние цифры
+func MaskPhone(phone string) string {
+	digits := 0
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	if digits <= 2 {
+		return strings.Repeat("*", digits)
+	}
+
+	var b strings.Builder
+	seen := 0
+	for _, r := range phone {
+		if r < '0' || r > '9' {
+			continue
+		}
+		seen++
+		if seen > digits-2 {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('*')
+		}
+	}
+	return b.String()
 }
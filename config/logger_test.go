@@ -0,0 +1,95 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestMaskPhone(t *testing.T) {
+	tests := []struct {
+		phone string
+		want  string
+	}{
+		{"+79001234567", "*********67"},
+		{"+7 (900) 123-45-67", "*********67"},
+		{"89001234567", "*********67"},
+		{"12", "**"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := MaskPhone(tt.phone); got != tt.want {
+			t.Errorf("MaskPhone(%q) = %q, want %q", tt.phone, got, tt.want)
+		}
+	}
+}
+
+func TestRedactPhones(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"international", "звоните +79001234567", "звоните *********67"},
+		{"with separators", "номер +7 900 123-45-67.", "номер *********67."},
+		{"leading eight", "8(900)123-45-67 и 8 900 765 43 21", "*********67 и *********21"},
+		{"foreign", "contact +491701234567", "contact **********67"},
+		{"no phone", "запись на 10:30, 2 услуги", "запись на 10:30, 2 услуги"},
+		{"short number", "код 123456", "код 123456"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RedactPhones(tt.in); got != tt.want {
+				t.Errorf("RedactPhones(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactAttr(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{ReplaceAttr: redactAttr}))
+
+	logger.Info("client +79001234567 registered",
+		LogKeyText, "привет, мой номер 89001234567",
+		LogKeyPhone, "+79001234567",
+		"note", "перезвоните на +7 900 123 45 67",
+		"error", errors.New("duplicate phone +79001234567"),
+		"user_id", 42,
+	)
+	out := buf.String()
+
+	for _, leaked := range []string{"9001234567", "900 123 45", "привет"} {
+		if strings.Contains(out, leaked) {
+			t.Errorf("log output leaks %q: %s", leaked, out)
+		}
+	}
+	for _, want := range []string{
+		`msg="client *********67 registered"`,
+		"text=\"[29 chars]\"",
+		"phone=*********67",
+		`note="перезвоните на *********67"`,
+		`error="duplicate phone *********67"`,
+		"user_id=42",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output misses %s: %s", want, out)
+		}
+	}
+}
+
+func TestLoggerFrom(t *testing.T) {
+	if got := LoggerFrom(context.Background()); got != slog.Default() {
+		t.Errorf("LoggerFrom(empty context) = %p, want slog.Default()", got)
+	}
+
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	if got := LoggerFrom(WithLogger(context.Background(), logger)); got != logger {
+		t.Errorf("LoggerFrom(WithLogger(logger)) = %p, want %p", got, logger)
+	}
+}
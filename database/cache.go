@@ -0,0 +1,187 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/RudinMaxim/BarberBot.git/config"
+)
+
+// ErrCacheUnavailable возвращается Get, пока кэш недоступен или разомкнут предохранитель
+var ErrCacheUnavailable = errors.New("cache unavailable")
+
+// Cache операции кэша, которыми пользуется репозиторий
+type Cache interface {
+	Get(ctx context.Context, key string, dest interface{}) error
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
+	Delete(ctx context.Context, key string) error
+	DeleteByPattern(ctx context.Context, pattern string) error
+}
+
+// FailOpenCache не даёт сбоям Redis ломать запросы: Get при ошибке отвечает
+// ErrCacheUnavailable, и вызывающий идёт в базу, а Set только пишет в журнал.
+// Вызовы проходят через предохранитель, см. BreakerCache.
+type FailOpenCache struct {
+	cache *BreakerCache
+}
+
+func NewFailOpenCache(cache Cache, breaker *CircuitBreaker) *FailOpenCache {
+	return &FailOpenCache{cache: NewBreakerCache(cache, breaker)}
+}
+
+func (c *FailOpenCache) Get(ctx context.Context, key string, dest interface{}) error {
+	err := c.cache.Get(ctx, key, dest)
+	if err == nil || errors.Is(err, ErrCacheMiss) || errors.Is(err, ErrCacheUnavailable) {
+		return err
+	}
+	config.LoggerFrom(ctx).Warn("cache read failed, falling back to database", "key", key, "error", err)
+	return fmt.Errorf("%w: %v", ErrCacheUnavailable, err)
+}
+
+func (c *FailOpenCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	err := c.cache.Set(ctx, key, value, expiration)
+	if err != nil && !errors.Is(err, ErrCacheUnavailable) {
+		config.LoggerFrom(ctx).Warn("cache write failed", "key", key, "error", err)
+	}
+	return nil
+}
+
+// Delete возвращает ошибку: пропущенная инвалидация означает устаревшие данные
+// до истечения TTL, вызывающий должен хотя бы записать это в журнал.
+func (c *FailOpenCache) Delete(ctx context.Context, key string) error {
+	return c.cache.Delete(ctx, key)
+}
+
+func (c *FailOpenCache) DeleteByPattern(ctx context.Context, pattern string) error {
+	return c.cache.DeleteByPattern(ctx, pattern)
+}
+
+// BreakerCache пропускает вызовы к кэшу через предохранитель и возвращает все
+// ошибки как есть. Пока предохранитель разомкнут, кэш не вызывается, а методы
+// отвечают ErrCacheUnavailable.
+type BreakerCache struct {
+	cache   Cache
+	breaker *CircuitBreaker
+}
+
+func NewBreakerCache(cache Cache, breaker *CircuitBreaker) *BreakerCache {
+	return &BreakerCache{cache: cache, breaker: breaker}
+}
+
+func (c *BreakerCache) Get(ctx context.Context, key string, dest interface{}) error {
+	if !c.breaker.allow() {
+		return ErrCacheUnavailable
+	}
+
+	err := c.cache.Get(ctx, key, dest)
+	// Промах значит, что Redis ответил
+	if errors.Is(err, ErrCacheMiss) {
+		c.breaker.record(nil)
+		return err
+	}
+	c.breaker.record(err)
+	return err
+}
+
+func (c *BreakerCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	if !c.breaker.allow() {
+		return ErrCacheUnavailable
+	}
+
+	err := c.cache.Set(ctx, key, value, expiration)
+	c.breaker.record(err)
+	return err
+}
+
+func (c *BreakerCache) Delete(ctx context.Context, key string) error {
+	if !c.breaker.allow() {
+		return ErrCacheUnavailable
+	}
+
+	err := c.cache.Delete(ctx, key)
+	c.breaker.record(err)
+	return err
+}
+
+func (c *BreakerCache) DeleteByPattern(ctx context.Context, pattern string) error {
+	if !c.breaker.allow() {
+		return ErrCacheUnavailable
+	}
+
+	err := c.cache.DeleteByPattern(ctx, pattern)
+	c.breaker.record(err)
+	return err
+}
+
+// CircuitBreaker размыкается после threshold ошибок подряд, через cooldown
+// пропускает один пробный запрос и замыкается после его успеха. Один
+// предохранитель делят все обёртки одного Redis.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+	onClose   func()
+	now       func() time.Time
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// OnClose задаёт функцию, которая вызывается, когда кэш снова доступен. Пока
+// предохранитель был разомкнут, инвалидации не доходили до Redis, и в нём
+// могли остаться устаревшие значения.
+func (b *CircuitBreaker) OnClose(fn func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onClose = fn
+}
+
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || b.now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *CircuitBreaker) record(err error) {
+	b.mu.Lock()
+	b.probing = false
+	if err == nil {
+		closed := b.failures >= b.threshold
+		b.failures = 0
+		onClose := b.onClose
+		b.mu.Unlock()
+
+		if closed {
+			slog.Info("cache is reachable again, closing circuit")
+			if onClose != nil {
+				onClose()
+			}
+		}
+		return
+	}
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+		if b.failures == b.threshold {
+			slog.Warn("cache keeps failing, opening circuit", "cooldown", b.cooldown, "error", err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errRedisDown = errors.New("connection refused")
+
+// stubCache отвечает ошибкой err и считает вызовы
+type stubCache struct {
+	err   error
+	calls int
+}
+
+func (c *stubCache) Get(ctx context.Context, key string, dest interface{}) error {
+	c.calls++
+	return c.err
+}
+
+func (c *stubCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	c.calls++
+	return c.err
+}
+
+func (c *stubCache) Delete(ctx context.Context, key string) error {
+	c.calls++
+	return c.err
+}
+
+func (c *stubCache) DeleteByPattern(ctx context.Context, pattern string) error {
+	c.calls++
+	return c.err
+}
+
+func newTestBreaker(threshold int, cooldown time.Duration) (*CircuitBreaker, *time.Time) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	breaker := NewCircuitBreaker(threshold, cooldown)
+	breaker.now = func() time.Time { return now }
+	return breaker, &now
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	ctx := context.Background()
+	breaker, now := newTestBreaker(3, 30*time.Second)
+	stub := &stubCache{err: errRedisDown}
+	cache := NewBreakerCache(stub, breaker)
+
+	closes := 0
+	breaker.OnClose(func() { closes++ })
+
+	// Закрыт: ошибки до порога доходят до Redis
+	for i := 0; i < 3; i++ {
+		if err := cache.Set(ctx, "key", 1, time.Minute); !errors.Is(err, errRedisDown) {
+			t.Fatalf("Set #%d error = %v, want %v", i+1, err, errRedisDown)
+		}
+	}
+	if stub.calls != 3 {
+		t.Fatalf("Redis called %d times before opening, want 3", stub.calls)
+	}
+
+	// Разомкнут: Redis не вызывается до конца cooldown
+	if err := cache.Get(ctx, "key", new(int)); !errors.Is(err, ErrCacheUnavailable) {
+		t.Fatalf("Get while open error = %v, want ErrCacheUnavailable", err)
+	}
+	*now = now.Add(29 * time.Second)
+	if err := cache.Delete(ctx, "key"); !errors.Is(err, ErrCacheUnavailable) {
+		t.Fatalf("Delete before cooldown error = %v, want ErrCacheUnavailable", err)
+	}
+	if stub.calls != 3 {
+		t.Fatalf("Redis called %d times while open, want 3", stub.calls)
+	}
+
+	// Полуоткрыт: один пробный запрос, неудачный размыкает снова
+	*now = now.Add(2 * time.Second)
+	if !breaker.allow() {
+		t.Fatal("allow() after cooldown = false, want a probe")
+	}
+	if breaker.allow() {
+		t.Fatal("allow() during probe = true, want false")
+	}
+	breaker.record(errRedisDown)
+	if err := cache.Get(ctx, "key", new(int)); !errors.Is(err, ErrCacheUnavailable) {
+		t.Fatalf("Get after failed probe error = %v, want ErrCacheUnavailable", err)
+	}
+
+	// Удачная проба замыкает и вызывает OnClose один раз
+	*now = now.Add(31 * time.Second)
+	stub.err = ErrCacheMiss
+	if err := cache.Get(ctx, "key", new(int)); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("probe Get error = %v, want ErrCacheMiss", err)
+	}
+	if closes != 1 {
+		t.Fatalf("OnClose called %d times, want 1", closes)
+	}
+
+	stub.err = nil
+	for i := 0; i < 5; i++ {
+		if err := cache.Set(ctx, "key", 1, time.Minute); err != nil {
+			t.Fatalf("Set after closing error = %v", err)
+		}
+	}
+	if closes != 1 {
+		t.Errorf("OnClose called %d times after further successes, want 1", closes)
+	}
+}
+
+func TestCircuitBreakerResetsOnSuccess(t *testing.T) {
+	ctx := context.Background()
+	breaker, _ := newTestBreaker(3, time.Minute)
+	stub := &stubCache{}
+	cache := NewBreakerCache(stub, breaker)
+
+	for _, err := range []error{errRedisDown, errRedisDown, nil, errRedisDown, errRedisDown} {
+		stub.err = err
+		cache.Delete(ctx, "key")
+	}
+	if !breaker.allow() {
+		t.Error("breaker opened although failures were not consecutive")
+	}
+}
+
+func TestFailOpenCacheSharesBreaker(t *testing.T) {
+	ctx := context.Background()
+	breaker, _ := newTestBreaker(2, time.Minute)
+	stub := &stubCache{err: errRedisDown}
+	failOpen := NewFailOpenCache(stub, breaker)
+	strict := NewBreakerCache(stub, breaker)
+
+	// FailOpenCache глотает ошибки записи, но они размыкают общий предохранитель
+	for i := 0; i < 2; i++ {
+		if err := failOpen.Set(ctx, "key", 1, time.Minute); err != nil {
+			t.Fatalf("FailOpenCache.Set error = %v, want nil", err)
+		}
+	}
+	if err := failOpen.Get(ctx, "key", new(int)); !errors.Is(err, ErrCacheUnavailable) {
+		t.Errorf("FailOpenCache.Get error = %v, want ErrCacheUnavailable", err)
+	}
+	if err := strict.Set(ctx, "key", 1, time.Minute); !errors.Is(err, ErrCacheUnavailable) {
+		t.Errorf("BreakerCache.Set error = %v, want ErrCacheUnavailable", err)
+	}
+	if stub.calls != 2 {
+		t.Errorf("Redis called %d times, want 2", stub.calls)
+	}
+}
@@ -1,45 +1,76 @@
 package database
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"time"
 
-	"github.com/spf13/viper"
+	"github.com/RudinMaxim/BarberBot.git/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/stdlib"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
-func getDSN() string {
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=UTC",
-		"aws-0-eu-central-1.pooler.supabase.com",
-		"postgres.hzqptekopyduwhazytud",
-		"JRUkRf4hYqe0uxLM",
-		"postgres",
-		"5432",
+func getDSN(cfg config.Database) string {
+	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
+		cfg.Host,
+		cfg.User,
+		cfg.Password,
+		cfg.Name,
+		cfg.Port,
+		cfg.SSLMode,
 	)
-	log.Printf("Attempting to connect to database with DSN: %s", dsn)
+	slog.Info("connecting to database", "name", cfg.Name, "host", cfg.Host, "port", cfg.Port, "user", cfg.User)
 	return dsn
 }
 
-func InitDatabase() (*gorm.DB, error) {
-	db, err := gorm.Open(postgres.Open(getDSN()), &gorm.Config{})
+// openConnPool открывает пул pgx, который отдаёт timestamptz в поясе салона.
+// Моменты времени хранятся в timestamptz, пояс влияет только на отображение.
+func openConnPool(cfg config.Database, location *time.Location) (gorm.ConnPool, error) {
+	connConfig, err := pgx.ParseConfig(getDSN(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("invalid database configuration: %w", err)
+	}
+	connConfig.RuntimeParams["timezone"] = location.String()
+
+	return stdlib.OpenDB(*connConfig, stdlib.OptionAfterConnect(func(ctx context.Context, conn *pgx.Conn) error {
+		conn.TypeMap().RegisterType(&pgtype.Type{
+			Name:  "timestamptz",
+			OID:   pgtype.TimestamptzOID,
+			Codec: &pgtype.TimestamptzCodec{ScanLocation: location},
+		})
+		return nil
+	})), nil
+}
+
+// InitDatabase подключается к Postgres и применяет недостающие миграции.
+// location часовой пояс салона, в нём возвращаются даты из базы.
+func InitDatabase(cfg config.Database, location *time.Location) (*gorm.DB, error) {
+	connPool, err := openConnPool(cfg, location)
 	if err != nil {
-		log.Printf("Failed to connect to database: %v", err)
 		return nil, err
 	}
 
-	if err := configureConnectionPool(db); err != nil {
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: connPool}), &gorm.Config{})
+	if err != nil {
+		slog.Error("failed to connect to database", "error", err)
 		return nil, err
 	}
 
-	log.Println("Successfully connected to database")
+	if err := configureConnectionPool(db, cfg); err != nil {
+		return nil, err
+	}
 
-	if viper.GetString("node.mode") == "development" {
-		err = AutoMigrate(db)
-		if err != nil {
-			log.Printf("Failed to auto migrate: %v", err)
-			return nil, err
-		}
+	slog.Info("successfully connected to database")
+
+	// Миграции идут при каждом старте в любом режиме: без них новый код
+	// работал бы со старой схемой. Применённые версии пропускаются.
+	if err := AutoMigrate(db, location); err != nil {
+		slog.Error("failed to auto migrate", "error", err)
+		return nil, err
 	}
 
 	return db, nil
@@ -2,7 +2,7 @@ package database
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/RudinMaxim/BarberBot.git/common"
@@ -19,58 +19,309 @@ type Migration struct {
 var migrations = []struct {
 	Version int
 	Name    string
-	Up      func(*gorm.DB) error
-	Down    func(*gorm.DB) error
+	// location пояс салона, в нём считается время, записанное до миграции 10
+	Up   func(db *gorm.DB, location *time.Location) error
+	Down func(db *gorm.DB, location *time.Location) error
 }{
 	{
 		Version: 1,
 		Name:    "create_client_table",
-		Up: func(db *gorm.DB) error {
+		Up: func(db *gorm.DB, location *time.Location) error {
 			return db.AutoMigrate(&common.Client{})
 		},
-		Down: func(db *gorm.DB) error {
+		Down: func(db *gorm.DB, location *time.Location) error {
 			return db.Migrator().DropTable(&common.Client{})
 		},
 	},
 	{
 		Version: 1,
 		Name:    "create_service_table",
-		Up: func(db *gorm.DB) error {
+		Up: func(db *gorm.DB, location *time.Location) error {
 			return db.AutoMigrate(&common.Service{})
 		},
-		Down: func(db *gorm.DB) error {
+		Down: func(db *gorm.DB, location *time.Location) error {
 			return db.Migrator().DropTable(&common.Service{})
 		},
 	},
 	{
 		Version: 1,
 		Name:    "create_working_hours_table",
-		Up: func(db *gorm.DB) error {
+		Up: func(db *gorm.DB, location *time.Location) error {
 			return db.AutoMigrate(&common.WorkingHours{})
 		},
-		Down: func(db *gorm.DB) error {
+		Down: func(db *gorm.DB, location *time.Location) error {
 			return db.Migrator().DropTable(&common.WorkingHours{})
 		},
 	},
 	{
 		Version: 1,
 		Name:    "create_appointment_table",
-		Up: func(db *gorm.DB) error {
+		Up: func(db *gorm.DB, location *time.Location) error {
 			return db.AutoMigrate(&common.Appointment{})
 		},
-		Down: func(db *gorm.DB) error {
+		Down: func(db *gorm.DB, location *time.Location) error {
 			return db.Migrator().DropTable(&common.Appointment{})
 		},
 	},
+	{
+		Version: 2,
+		Name:    "create_reminder_table",
+		Up: func(db *gorm.DB, location *time.Location) error {
+			return db.AutoMigrate(&common.Reminder{})
+		},
+		Down: func(db *gorm.DB, location *time.Location) error {
+			return db.Migrator().DropTable(&common.Reminder{})
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add_appointment_confirmed_at",
+		Up: func(db *gorm.DB, location *time.Location) error {
+			return db.AutoMigrate(&common.Appointment{})
+		},
+		Down: func(db *gorm.DB, location *time.Location) error {
+			return db.Migrator().DropColumn(&common.Appointment{}, "confirmed_at")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add_service_sort_order",
+		Up: func(db *gorm.DB, location *time.Location) error {
+			return db.AutoMigrate(&common.Service{})
+		},
+		Down: func(db *gorm.DB, location *time.Location) error {
+			return db.Migrator().DropColumn(&common.Service{}, "sort_order")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create_schedule_exception_table",
+		Up: func(db *gorm.DB, location *time.Location) error {
+			return db.AutoMigrate(&common.ScheduleException{})
+		},
+		Down: func(db *gorm.DB, location *time.Location) error {
+			return db.Migrator().DropTable(&common.ScheduleException{})
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create_break_and_blocked_range_tables",
+		Up: func(db *gorm.DB, location *time.Location) error {
+			return db.AutoMigrate(&common.Break{}, &common.BlockedRange{})
+		},
+		Down: func(db *gorm.DB, location *time.Location) error {
+			return db.Migrator().DropTable(&common.Break{}, &common.BlockedRange{})
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_appointment_overlap_constraint",
+		Up: func(db *gorm.DB, location *time.Location) error {
+			return db.Transaction(func(tx *gorm.DB) error {
+				if err := cancelOverlappingAppointments(tx); err != nil {
+					return err
+				}
+				return addAppointmentOverlapConstraint(tx)
+			})
+		},
+		Down: func(db *gorm.DB, location *time.Location) error {
+			return db.Exec("ALTER TABLE appointments DROP CONSTRAINT IF EXISTS appointments_no_overlap").Error
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add_blocked_range_source",
+		Up: func(db *gorm.DB, location *time.Location) error {
+			return db.AutoMigrate(&common.BlockedRange{})
+		},
+		Down: func(db *gorm.DB, location *time.Location) error {
+			if err := db.Migrator().DropColumn(&common.BlockedRange{}, "external_id"); err != nil {
+				return err
+			}
+			return db.Migrator().DropColumn(&common.BlockedRange{}, "source")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "create_oauth_token_table",
+		Up: func(db *gorm.DB, location *time.Location) error {
+			return db.AutoMigrate(&common.OAuthToken{})
+		},
+		Down: func(db *gorm.DB, location *time.Location) error {
+			return db.Migrator().DropTable(&common.OAuthToken{})
+		},
+	},
+	{
+		Version: 10,
+		Name:    "convert_instants_to_timestamptz",
+		Up: func(db *gorm.DB, location *time.Location) error {
+			return convertTimestamps(db, "timestamptz", location)
+		},
+		Down: func(db *gorm.DB, location *time.Location) error {
+			return convertTimestamps(db, "timestamp", location)
+		},
+	},
+	{
+		Version: 11,
+		Name:    "create_calendar_operation_table",
+		Up: func(db *gorm.DB, location *time.Location) error {
+			return db.AutoMigrate(&common.CalendarOperation{})
+		},
+		Down: func(db *gorm.DB, location *time.Location) error {
+			return db.Migrator().DropTable(&common.CalendarOperation{})
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add_correlation_id_columns",
+		Up: func(db *gorm.DB, location *time.Location) error {
+			return db.AutoMigrate(&common.Reminder{}, &common.CalendarOperation{})
+		},
+		Down: func(db *gorm.DB, location *time.Location) error {
+			if err := db.Migrator().DropColumn(&common.Reminder{}, "correlation_id"); err != nil {
+				return err
+			}
+			return db.Migrator().DropColumn(&common.CalendarOperation{}, "correlation_id")
+		},
+	},
+}
+
+// instantColumns колонки с моментами времени. wallClock колонки до миграции 10
+// хранили время салона в timestamp без пояса, остальные время сервера в UTC.
+var instantColumns = []struct {
+	Table     string
+	Column    string
+	WallClock bool
+}{
+	{"appointments", "start_time", true},
+	{"appointments", "end_time", true},
+	{"blocked_ranges", "start_time", true},
+	{"blocked_ranges", "end_time", true},
+	{"reminders", "notify_at", true},
+	{"reminders", "locked_until", false},
+	{"reminders", "sent_at", false},
+}
+
+// convertTimestamps переводит колонки моментов времени в timestamptz или обратно.
+// Время салона переводится в поясе location. Ограничение на пересечение записей
+// зависит от типа колонок и пересоздаётся.
+func convertTimestamps(db *gorm.DB, target string, location *time.Location) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("ALTER TABLE appointments DROP CONSTRAINT IF EXISTS appointments_no_overlap").Error; err != nil {
+			return err
+		}
+
+		for _, c := range instantColumns {
+			current, err := columnType(tx, c.Table, c.Column)
+			if err != nil {
+				return err
+			}
+			// На новой базе таблицы сразу создаются с timestamptz
+			if current == target {
+				continue
+			}
+
+			zone := "UTC"
+			if c.WallClock {
+				zone = location.String()
+			}
+			sql := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s AT TIME ZONE '%s'",
+				c.Table, c.Column, target, c.Column, zone)
+			if err := tx.Exec(sql).Error; err != nil {
+				return fmt.Errorf("failed to convert %s.%s: %w", c.Table, c.Column, err)
+			}
+		}
+
+		return addAppointmentOverlapConstraint(tx)
+	})
+}
+
+// columnType возвращает timestamptz или timestamp для колонки времени
+func columnType(db *gorm.DB, table, column string) (string, error) {
+	var dataType string
+	err := db.Raw(`SELECT data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?`, table, column).
+		Scan(&dataType).Error
+	if err != nil {
+		return "", err
+	}
+	if dataType == "timestamp with time zone" {
+		return "timestamptz", nil
+	}
+	return "timestamp", nil
+}
+
+// cancelOverlappingAppointments отменяет записи, пересекающиеся с записанными раньше:
+// без этого ограничение appointments_no_overlap не создастся на старых данных.
+// Каждая отмена пишется в журнал, чтобы мастер мог связаться с клиентом.
+func cancelOverlappingAppointments(db *gorm.DB) error {
+	var appointments []struct {
+		UUID      string
+		ClientID  string
+		StartTime time.Time
+		EndTime   time.Time
+	}
+	err := db.Table("appointments").
+		Select("uuid, client_id, start_time, end_time").
+		Where("status <> ?", "cancelled").
+		Order("created_at, uuid").
+		Scan(&appointments).Error
+	if err != nil {
+		return fmt.Errorf("failed to load appointments: %w", err)
+	}
+
+	type interval struct{ start, end time.Time }
+	var kept []interval
+	var conflicting []string
+	for _, a := range appointments {
+		overlaps := false
+		for _, k := range kept {
+			if a.StartTime.Before(k.end) && a.EndTime.After(k.start) {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			kept = append(kept, interval{a.StartTime, a.EndTime})
+			continue
+		}
+		conflicting = append(conflicting, a.UUID)
+		slog.Warn("cancelling appointment that overlaps an earlier booking",
+			"appointment_id", a.UUID, "client_id", a.ClientID, "start", a.StartTime, "end", a.EndTime)
+	}
+	if len(conflicting) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	return db.Table("appointments").
+		Where("uuid IN ?", conflicting).
+		Updates(map[string]interface{}{"status": "cancelled", "cancelled_at": now, "updated_at": now}).Error
+}
+
+// addAppointmentOverlapConstraint запрещает пересечение действующих записей
+func addAppointmentOverlapConstraint(db *gorm.DB) error {
+	dataType, err := columnType(db, "appointments", "start_time")
+	if err != nil {
+		return err
+	}
+	rangeType := "tsrange"
+	if dataType == "timestamptz" {
+		rangeType = "tstzrange"
+	}
+
+	return db.Exec(fmt.Sprintf(`ALTER TABLE appointments ADD CONSTRAINT appointments_no_overlap
+		EXCLUDE USING gist (%s(start_time, end_time, '[)') WITH &&)
+		WHERE (status <> 'cancelled')`, rangeType)).Error
 }
 
-func AutoMigrate(db *gorm.DB) error {
-	log.Println("Running auto migration")
-	if err := RunMigrations(db); err != nil {
-		log.Printf("Migration failed: %v", err)
+func AutoMigrate(db *gorm.DB, location *time.Location) error {
+	slog.Info("running auto migration")
+	if err := RunMigrations(db, location); err != nil {
+		slog.Error("migration failed", "error", err)
 		return err
 	}
-	log.Println("Auto migration completed successfully")
+	slog.Info("auto migration completed successfully")
 	return nil
 }
 
@@ -78,7 +329,7 @@ func InitMigrationTable(db *gorm.DB) error {
 	return db.AutoMigrate(&Migration{})
 }
 
-func RunMigrations(db *gorm.DB) error {
+func RunMigrations(db *gorm.DB, location *time.Location) error {
 	if err := InitMigrationTable(db); err != nil {
 		return fmt.Errorf("failed to initialize migration table: %v", err)
 	}
@@ -86,37 +337,37 @@ func RunMigrations(db *gorm.DB) error {
 	for _, migration := range migrations {
 		var m Migration
 		if err := db.Where("version = ?", migration.Version).First(&m).Error; err == gorm.ErrRecordNotFound {
-			log.Printf("Applying migration %d: %s", migration.Version, migration.Name)
-			if err := migration.Up(db); err != nil {
+			slog.Info("applying migration", "version", migration.Version, "name", migration.Name)
+			if err := migration.Up(db, location); err != nil {
 				return fmt.Errorf("failed to apply migration %d (%s): %v", migration.Version, migration.Name, err)
 			}
 			db.Create(&Migration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()})
-			log.Printf("Successfully applied migration %d: %s", migration.Version, migration.Name)
+			slog.Info("successfully applied migration", "version", migration.Version, "name", migration.Name)
 		}
 	}
 
 	return nil
 }
 
-func RollbackLastMigration(db *gorm.DB) error {
+func RollbackLastMigration(db *gorm.DB, location *time.Location) error {
 	var lastMigration Migration
 	if err := db.Order("version DESC").First(&lastMigration).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			log.Println("No migrations to rollback")
+			slog.Info("no migrations to rollback")
 			return nil
 		}
 		return fmt.Errorf("failed to get last migration: %v", err)
 	}
 	for i := len(migrations) - 1; i >= 0; i-- {
 		if migrations[i].Version == lastMigration.Version {
-			log.Printf("Rolling back migration %d: %s", lastMigration.Version, lastMigration.Name)
-			if err := migrations[i].Down(db); err != nil {
+			slog.Info("rolling back migration", "version", lastMigration.Version, "name", lastMigration.Name)
+			if err := migrations[i].Down(db, location); err != nil {
 				return fmt.Errorf("failed to rollback migration %d (%s): %v", lastMigration.Version, lastMigration.Name, err)
 			}
 			if err := db.Delete(&lastMigration).Error; err != nil {
 				return fmt.Errorf("failed to delete migration record: %v", err)
 			}
-			log.Printf("Successfully rolled back migration %d: %s", lastMigration.Version, lastMigration.Name)
+			slog.Info("successfully rolled back migration", "version", lastMigration.Version, "name", lastMigration.Name)
 			return nil
 		}
 	}
@@ -0,0 +1,228 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var alterColumnType = regexp.MustCompile(`ALTER TABLE (\w+) ALTER COLUMN (\w+) TYPE (\w+)`)
+
+// fakeSchema изображает information_schema: хранит типы колонок, меняет их
+// по ALTER COLUMN и записывает выполненные команды
+type fakeSchema struct {
+	types     map[string]string
+	execs     []string
+	failOn    string
+	committed bool
+	rollback  bool
+}
+
+func newFakeSchema(dataType string) *fakeSchema {
+	s := &fakeSchema{types: make(map[string]string)}
+	for _, c := range instantColumns {
+		s.types[c.Table+"."+c.Column] = dataType
+	}
+	return s
+}
+
+func (s *fakeSchema) open(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(s)}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open gorm: %v", err)
+	}
+	return db
+}
+
+func (s *fakeSchema) Connect(context.Context) (driver.Conn, error) { return &fakeConn{schema: s}, nil }
+func (s *fakeSchema) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct {
+	schema *fakeSchema
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("unexpected prepare: %s", query)
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{c.schema}, nil }
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	s := c.schema
+	s.execs = append(s.execs, query)
+	if s.failOn != "" && strings.Contains(query, s.failOn) {
+		return nil, errors.New("permission denied")
+	}
+	if m := alterColumnType.FindStringSubmatch(query); m != nil {
+		dataType := "timestamp without time zone"
+		if m[3] == "timestamptz" {
+			dataType = "timestamp with time zone"
+		}
+		s.types[m[1]+"."+m[2]] = dataType
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if !strings.Contains(query, "information_schema.columns") || len(args) != 2 {
+		return nil, fmt.Errorf("unexpected query: %s", query)
+	}
+	dataType, ok := c.schema.types[fmt.Sprintf("%v.%v", args[0].Value, args[1].Value)]
+	if !ok {
+		return &fakeRows{}, nil
+	}
+	return &fakeRows{values: []string{dataType}}, nil
+}
+
+type fakeTx struct {
+	schema *fakeSchema
+}
+
+func (tx fakeTx) Commit() error   { tx.schema.committed = true; return nil }
+func (tx fakeTx) Rollback() error { tx.schema.rollback = true; return nil }
+
+type fakeRows struct {
+	values []string
+}
+
+func (r *fakeRows) Columns() []string { return []string{"data_type"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0] = r.values[0]
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestConvertTimestamps(t *testing.T) {
+	allColumns := []string{
+		"ALTER TABLE appointments ALTER COLUMN start_time TYPE %[1]s USING start_time AT TIME ZONE '%[2]s'",
+		"ALTER TABLE appointments ALTER COLUMN end_time TYPE %[1]s USING end_time AT TIME ZONE '%[2]s'",
+		"ALTER TABLE blocked_ranges ALTER COLUMN start_time TYPE %[1]s USING start_time AT TIME ZONE '%[2]s'",
+		"ALTER TABLE blocked_ranges ALTER COLUMN end_time TYPE %[1]s USING end_time AT TIME ZONE '%[2]s'",
+		"ALTER TABLE reminders ALTER COLUMN notify_at TYPE %[1]s USING notify_at AT TIME ZONE '%[2]s'",
+		"ALTER TABLE reminders ALTER COLUMN locked_until TYPE %[1]s USING locked_until AT TIME ZONE 'UTC'",
+		"ALTER TABLE reminders ALTER COLUMN sent_at TYPE %[1]s USING sent_at AT TIME ZONE 'UTC'",
+	}
+
+	tests := []struct {
+		name      string
+		current   string
+		converted []string
+		target    string
+		zone      string
+		want      []string
+		rangeType string
+	}{
+		{
+			name:      "legacy columns to timestamptz",
+			current:   "timestamp without time zone",
+			target:    "timestamptz",
+			zone:      "Europe/Moscow",
+			want:      allColumns,
+			rangeType: "tstzrange",
+		},
+		{
+			name:      "fresh database already timestamptz",
+			current:   "timestamp with time zone",
+			target:    "timestamptz",
+			zone:      "Asia/Yekaterinburg",
+			rangeType: "tstzrange",
+		},
+		{
+			name:      "partially converted database",
+			current:   "timestamp without time zone",
+			converted: []string{"appointments.start_time", "appointments.end_time", "blocked_ranges.start_time", "blocked_ranges.end_time"},
+			target:    "timestamptz",
+			zone:      "Asia/Yekaterinburg",
+			want:      allColumns[4:],
+			rangeType: "tstzrange",
+		},
+		{
+			name:      "rollback to timestamp",
+			current:   "timestamp with time zone",
+			target:    "timestamp",
+			zone:      "America/New_York",
+			want:      allColumns,
+			rangeType: "tsrange",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := newFakeSchema(tt.current)
+			for _, column := range tt.converted {
+				schema.types[column] = "timestamp with time zone"
+			}
+
+			location, err := time.LoadLocation(tt.zone)
+			if err != nil {
+				t.Fatalf("load zone: %v", err)
+			}
+
+			if err := convertTimestamps(schema.open(t), tt.target, location); err != nil {
+				t.Fatalf("convertTimestamps: %v", err)
+			}
+			if !schema.committed {
+				t.Error("transaction was not committed")
+			}
+
+			execs := schema.execs
+			if len(execs) != len(tt.want)+2 {
+				t.Fatalf("executed %d statements, want %d: %q", len(execs), len(tt.want)+2, execs)
+			}
+			if !strings.Contains(execs[0], "DROP CONSTRAINT IF EXISTS appointments_no_overlap") {
+				t.Errorf("first statement = %q, want constraint drop", execs[0])
+			}
+			for i, want := range tt.want {
+				if want := fmt.Sprintf(want, tt.target, tt.zone); execs[i+1] != want {
+					t.Errorf("statement %d = %q, want %q", i+1, execs[i+1], want)
+				}
+			}
+			last := execs[len(execs)-1]
+			if !strings.Contains(last, "ADD CONSTRAINT appointments_no_overlap") || !strings.Contains(last, tt.rangeType+"(start_time, end_time") {
+				t.Errorf("last statement = %q, want constraint with %s", last, tt.rangeType)
+			}
+
+			for column, dataType := range schema.types {
+				if want := map[string]string{"timestamptz": "timestamp with time zone", "timestamp": "timestamp without time zone"}[tt.target]; dataType != want {
+					t.Errorf("%s is %s, want %s", column, dataType, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertTimestampsRollsBackOnFailure(t *testing.T) {
+	schema := newFakeSchema("timestamp without time zone")
+	schema.failOn = "ALTER COLUMN notify_at"
+
+	err := convertTimestamps(schema.open(t), "timestamptz", time.UTC)
+	if err == nil || !strings.Contains(err.Error(), "reminders.notify_at") {
+		t.Fatalf("convertTimestamps error = %v, want failure on reminders.notify_at", err)
+	}
+	if schema.committed || !schema.rollback {
+		t.Errorf("committed = %v, rollback = %v, want rollback only", schema.committed, schema.rollback)
+	}
+	for _, exec := range schema.execs {
+		if strings.Contains(exec, "ADD CONSTRAINT") {
+			t.Errorf("constraint recreated after failure: %q", exec)
+		}
+	}
+}
@@ -3,19 +3,30 @@ package database
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/RudinMaxim/BarberBot.git/config"
 	"github.com/go-redis/redis/v8"
 )
 
+// ErrCacheMiss возвращается Get, если ключа нет в кэше
+var ErrCacheMiss = errors.New("key not found")
+
 type RedisCache struct {
 	client *redis.Client
 }
 
-func NewRedisCache(addr string) *RedisCache {
+func NewRedisCache(cfg config.Cache) *RedisCache {
+	// Короткие таймауты: при недоступном Redis запрос должен быстро уйти в базу
 	rdb := redis.NewClient(&redis.Options{
-		Addr: addr,
+		Addr:         cfg.Host,
+		Password:     cfg.Password,
+		DialTimeout:  time.Second,
+		ReadTimeout:  500 * time.Millisecond,
+		WriteTimeout: 500 * time.Millisecond,
+		MaxRetries:   1,
 	})
 
 	return &RedisCache{
@@ -40,7 +51,7 @@ func (rc *RedisCache) Set(ctx context.Context, key string, value interface{}, ex
 func (rc *RedisCache) Get(ctx context.Context, key string, dest interface{}) error {
 	data, err := rc.client.Get(ctx, key).Bytes()
 	if err == redis.Nil {
-		return fmt.Errorf("key not found")
+		return ErrCacheMiss
 	} else if err != nil {
 		return fmt.Errorf("failed to get cache: %w", err)
 	}
@@ -57,6 +68,29 @@ func (rc *RedisCache) Delete(ctx context.Context, key string) error {
 	return rc.client.Del(ctx, key).Err()
 }
 
+// DeleteByPattern удаляет все ключи, подходящие под шаблон (например, "services:ids:*").
+func (rc *RedisCache) DeleteByPattern(ctx context.Context, pattern string) error {
+	iter := rc.client.Scan(ctx, 0, pattern, 100).Iterator()
+
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to scan keys: %w", err)
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return rc.client.Del(ctx, keys...).Err()
+}
+
 func (rc *RedisCache) Ping(ctx context.Context) error {
 	return rc.client.Ping(ctx).Err()
 }
+
+func (rc *RedisCache) Close() error {
+	return rc.client.Close()
+}
@@ -3,34 +3,35 @@ package database
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
-	"github.com/spf13/viper"
+	"github.com/RudinMaxim/BarberBot.git/config"
 	"gorm.io/gorm"
 )
 
-func configureConnectionPool(db *gorm.DB) error {
+func configureConnectionPool(db *gorm.DB, cfg config.Database) error {
 	sqlDB, err := db.DB()
 	if err != nil {
-		log.Printf("Failed to get database instance: %v", err)
+		slog.Error("failed to get database instance", "error", err)
 		return err
 	}
 
-	sqlDB.SetMaxIdleConns(viper.GetInt("database.max_idle_conns"))
-	sqlDB.SetMaxOpenConns(viper.GetInt("database.max_open_conns"))
-	sqlDB.SetConnMaxLifetime(time.Duration(viper.GetInt("database.conn_max_lifetime")) * time.Second)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime) * time.Second)
 
 	return nil
 }
 
-func PingDatabase(db *gorm.DB) error {
+// PingDatabase проверяет соединение не дольше 5 секунд или до отмены ctx
+func PingDatabase(ctx context.Context, db *gorm.DB) error {
 	sqlDB, err := db.DB()
 	if err != nil {
 		return fmt.Errorf("failed to get database instance: %v", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	if err := sqlDB.PingContext(ctx); err != nil {
@@ -39,3 +40,13 @@ func PingDatabase(db *gorm.DB) error {
 
 	return nil
 }
+
+// CloseDatabase закрывает пул соединений
+func CloseDatabase(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %v", err)
+	}
+
+	return sqlDB.Close()
+}
@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
 	"regexp"
 
 	"github.com/RudinMaxim/BarberBot.git/config"
@@ -46,22 +46,26 @@ type TemplateData struct {
 }
 
 func GetFormattedMessage(key string, name string) string {
+	return FormatText(key, TemplateData{Name: name})
+}
+
+// FormatText подставляет data в шаблон из texts.yaml
+func FormatText(key string, data interface{}) string {
 	text := GetText(key)
 
 	tmpl, err := template.New("message").Parse(text)
 	if err != nil {
-		log.Printf("Error parsing template: %v", err)
+		slog.Error("error parsing template", "error", err)
 		return ""
 	}
 
 	// Подготовка буфера для записи результата
 	var result bytes.Buffer
-	data := TemplateData{Name: name}
 
 	// Выполняем подстановку значений
 	err = tmpl.Execute(&result, data)
 	if err != nil {
-		log.Printf("Error executing template: %v", err)
+		slog.Error("error executing template", "error", err)
 		return ""
 	}
 
@@ -0,0 +1,281 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/RudinMaxim/BarberBot.git/common"
+	"github.com/RudinMaxim/BarberBot.git/helper"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/google/uuid"
+)
+
+var adminCommands = []tgbotapi.BotCommand{
+	{Command: "today", Description: "Расписание на сегодня"},
+	{Command: "tomorrow", Description: "Расписание на завтра"},
+	{Command: "catalog", Description: "Каталог услуг"},
+	{Command: "hours", Description: "График работы"},
+	{Command: "exceptions", Description: "Выходные и особые дни"},
+	{Command: "breaks", Description: "Регулярные перерывы"},
+	{Command: "blocks", Description: "Заблокированное время"},
+	{Command: "calendar", Description: "Подключение календаря"},
+	{Command: "calendar_queue", Description: "Несинхронизированные события календаря"},
+}
+
+func (h *Handler) isAdmin(userID int64) bool {
+	return h.admins[userID]
+}
+
+// ================Admin commands==================
+
+func (h *Handler) handleAdminCommand(update tgbotapi.Update) {
+	chatID := update.Message.Chat.ID
+
+	if !h.isAdmin(update.Message.From.ID) {
+		h.handleUnknownCommand(update)
+		return
+	}
+
+	switch update.Message.Command() {
+	case "today":
+		h.sendSchedule(chatID, time.Now().In(h.location))
+	case "tomorrow":
+		h.sendSchedule(chatID, time.Now().In(h.location).AddDate(0, 0, 1))
+	case "catalog":
+		h.sendServiceCatalogue(chatID)
+	case "hours":
+		h.sendWorkingHoursEditor(chatID)
+	case "exceptions":
+		h.sendScheduleExceptions(chatID)
+	case "breaks":
+		h.sendBreaks(chatID)
+	case "blocks":
+		h.sendBlockedRanges(chatID)
+	case "calendar":
+		h.sendCalendarAuth(chatID, update.Message.From.ID)
+	case "calendar_queue":
+		h.sendCalendarQueue(chatID)
+	case "test_notify":
+		testID := uuid.New().String()
+		h.ScheduleNotification(
+			testID,
+			chatID,
+			"🔔 Тестовое уведомление через 10 секунд!",
+			time.Now().Add(10*time.Second),
+		)
+		h.sendMessage(chatID, "Уведомление запланировано! ID: "+testID)
+	case "cancel_notify":
+		args := strings.Split(update.Message.Text, " ")
+		if len(args) < 2 {
+			h.sendMessage(chatID, "Укажите ID уведомления")
+			return
+		}
+		h.CancelNotification(args[1])
+		h.sendMessage(chatID, "Уведомление отменено!")
+	}
+}
+
+func (h *Handler) handleAdminCallback(chatID int64, userID int64, action string, value string) {
+	if !h.isAdmin(userID) {
+		h.log.Warn("admin action rejected for non-admin user", "action", action)
+		h.handleUnknownCommand(tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}, From: &tgbotapi.User{ID: userID}}})
+		return
+	}
+
+	if action == "admin_schedule" {
+		date, err := time.ParseInLocation("2006-01-02", value, h.location)
+		if err != nil {
+			h.log.Error("error parsing date", "error", err)
+			h.sendMessage(chatID, "Ошибка при обработке даты")
+			return
+		}
+		h.sendSchedule(chatID, date)
+		return
+	}
+
+	appointmentID, err := uuid.Parse(value)
+	if err != nil {
+		h.sendMessage(chatID, "Неверный идентификатор записи.")
+		return
+	}
+
+	switch action {
+	case "admin_appointment":
+		h.sendAdminAppointment(chatID, appointmentID)
+	case "admin_complete":
+		h.handleAdminStatusChange(chatID, appointmentID, "completed")
+	case "admin_no_show":
+		h.handleAdminStatusChange(chatID, appointmentID, "no_show")
+	case "admin_cancel":
+		h.handleAdminCancellation(chatID, appointmentID)
+	}
+}
+
+// ================Schedule==================
+
+func (h *Handler) sendSchedule(chatID int64, date time.Time) {
+	appointments, clients, err := h.service.GetScheduleForDate(date)
+	if err != nil {
+		h.log.Error("error getting schedule", "error", err)
+		h.sendMessage(chatID, helper.GetText("invalid_get_appointments"))
+		return
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "📋 Расписание на %s\n", date.Format("02.01.2006"))
+
+	if len(appointments) == 0 {
+		text.WriteString("\nЗаписей нет.")
+	}
+
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	for _, appointment := range appointments {
+		clientName := "—"
+		if client, ok := clients[appointment.ClientID]; ok {
+			clientName = fmt.Sprintf("%s, %s", client.Name, client.Phone)
+		}
+
+		fmt.Fprintf(&text, "\n🕒 %s–%s %s\n💇 %s, %.2f руб.\n👤 %s\n",
+			appointment.StartTime.Format("15:04"),
+			appointment.EndTime.Format("15:04"),
+			getStatusEmoji(appointment.Status),
+			appointment.Name,
+			appointment.TotalPrice,
+			clientName,
+		)
+		if !appointment.ConfirmedAt.IsZero() {
+			text.WriteString("🙋 Визит подтверждён\n")
+		}
+
+		if appointment.Status == "scheduled" {
+			button := h.button(
+				chatID,
+				fmt.Sprintf("%s - %s", appointment.StartTime.Format("15:04"), appointment.Name),
+				"admin_appointment",
+				appointment.UUID.String(),
+			)
+			keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{button})
+		}
+	}
+
+	blocks, err := h.service.GetDayBlocks(date)
+	if err != nil {
+		h.log.Error("error getting day blocks", "error", err)
+	}
+	for _, block := range blocks {
+		fmt.Fprintf(&text, "\n⛔ %s–%s %s\n", block.Start.Format("15:04"), block.End.Format("15:04"), block.Reason)
+	}
+
+	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{
+		h.button(chatID, "⬅️", "admin_schedule", date.AddDate(0, 0, -1).Format("2006-01-02")),
+		h.button(chatID, "➡️", "admin_schedule", date.AddDate(0, 0, 1).Format("2006-01-02")),
+	})
+
+	msg := tgbotapi.NewMessage(chatID, text.String())
+	msg.ReplyMarkup = inlineKeyboard(keyboard...)
+	h.bot.Send(msg)
+}
+
+func (h *Handler) sendAdminAppointment(chatID int64, appointmentID uuid.UUID) {
+	appointment, err := h.service.GetAppointmentByID(appointmentID)
+	if err != nil {
+		h.log.Error("error getting appointment", "error", err)
+		h.sendMessage(chatID, helper.GetText("invalid_get_appointment"))
+		return
+	}
+
+	client, err := h.service.GetClientBy("uuid", appointment.ClientID)
+	if err != nil {
+		h.log.Error("error getting client", "error", err)
+		h.sendMessage(chatID, helper.GetText("invalid_get_user"))
+		return
+	}
+
+	messageText := fmt.Sprintf(
+		"Запись клиента:\n\n"+
+			"👤 %s\n"+
+			"📞 %s\n"+
+			"✈️ @%s\n\n"+
+			"🗓 %s %s - %s\n"+
+			"💇 %s\n"+
+			"💰 %.2f руб.\n"+
+			"📊 %s",
+		client.Name,
+		client.Phone,
+		client.Telegram,
+		appointment.StartTime.Format("02.01.2006"),
+		appointment.StartTime.Format("15:04"),
+		appointment.EndTime.Format("15:04"),
+		appointment.Name,
+		appointment.TotalPrice,
+		getStatusEmoji(appointment.Status),
+	)
+
+	id := appointment.UUID.String()
+	keyboard := inlineKeyboard(
+		tgbotapi.NewInlineKeyboardRow(
+			h.button(chatID, "✔️ Завершено", "admin_complete", id),
+			h.button(chatID, "🚫 Не пришёл", "admin_no_show", id),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			h.button(chatID, "❌ Отменить запись", "admin_cancel", id),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			h.button(chatID, helper.GetText("back_button"), "admin_schedule", appointment.StartTime.Format("2006-01-02")),
+		),
+	)
+
+	msg := tgbotapi.NewMessage(chatID, messageText)
+	msg.ReplyMarkup = keyboard
+	h.bot.Send(msg)
+}
+
+func (h *Handler) handleAdminStatusChange(chatID int64, appointmentID uuid.UUID, status string) {
+	appointment, err := h.service.SetAppointmentStatus(appointmentID, status)
+	if err != nil {
+		h.log.Error("error changing appointment status", "error", err)
+		h.sendMessage(chatID, "Не удалось изменить статус записи")
+		return
+	}
+
+	h.CancelNotification(appointmentID.String())
+	h.sendMessage(chatID, fmt.Sprintf("Статус записи на %s: %s",
+		appointment.StartTime.Format("02.01.2006 15:04"), getStatusEmoji(appointment.Status)))
+}
+
+func (h *Handler) handleAdminCancellation(chatID int64, appointmentID uuid.UUID) {
+	appointment, client, err := h.service.CancelAppointmentByMaster(appointmentID)
+	if err != nil {
+		h.log.Error("error cancelling appointment by master", "error", err)
+		h.sendMessage(chatID, "Произошла ошибка при отмене записи")
+		return
+	}
+
+	h.CancelNotification(appointmentID.String())
+
+	h.notifyClient(client, helper.FormatText("appointment_cancelled_by_master", newAppointmentTemplateData(appointment)))
+	h.sendMessage(chatID, fmt.Sprintf("❌ Запись на %s отменена, клиент уведомлён",
+		appointment.StartTime.Format("02.01.2006 15:04")))
+}
+
+// notifyAdmins отправляет сообщение всем мастерам. Кнопки подписываются на
+// получателя, поэтому keyboard строит клавиатуру для каждого; может быть nil.
+func (h *Handler) notifyAdmins(text string, keyboard func(chatID int64) tgbotapi.InlineKeyboardMarkup) {
+	for adminID := range h.admins {
+		msg := tgbotapi.NewMessage(adminID, text)
+		if keyboard != nil {
+			msg.ReplyMarkup = keyboard(adminID)
+		}
+		if _, err := h.bot.Send(msg); err != nil {
+			h.log.Error("error notifying admin", "admin_id", adminID, "error", err)
+		}
+	}
+}
+
+func (h *Handler) notifyClient(client *common.Client, text string) {
+	if client == nil || client.TelegramID == 0 {
+		return
+	}
+	h.sendMessage(client.TelegramID, text)
+}
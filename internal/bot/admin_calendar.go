@@ -0,0 +1,206 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/RudinMaxim/BarberBot.git/common"
+	"github.com/RudinMaxim/BarberBot.git/internal/calendar"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	adminStepCalendarCode = "calendar_code"
+	// calendarQueueLimit сколько неудавшихся операций показывать мастеру
+	calendarQueueLimit = 20
+	// calendarErrorPreview длина текста ошибки в списке, чтобы сообщение влезло в лимит Telegram
+	calendarErrorPreview = 150
+	// telegramMessageLimit предельная длина сообщения Telegram в символах UTF-16
+	telegramMessageLimit = 4096
+	// calendarQueueFooterReserve место под строку о не показанных операциях
+	calendarQueueFooterReserve = 100
+)
+
+// ================Calendar auth==================
+
+// sendCalendarAuth показывает состояние календаря и, если нужен OAuth, ссылку на согласие.
+// Код после согласия принимается по адресу возврата или сообщением боту.
+func (h *Handler) sendCalendarAuth(chatID int64, userID int64) {
+	if h.calendar == nil {
+		h.sendMessage(chatID, "📅 Календарь не подключён: проверьте calendar.provider и журнал запуска")
+		return
+	}
+
+	authorizer, ok := calendar.AuthorizerOf(h.calendar)
+	if !ok {
+		h.sendMessage(chatID, "📅 Календарь подключён, выдавать доступ не нужно")
+		return
+	}
+
+	status := "❌ Доступ к Google Календарю не выдан."
+	if authorizer.Authorized() {
+		status = "✅ Google Календарь подключён. Выдать доступ заново можно по ссылке ниже."
+	}
+
+	h.saveAdminState(userID, &AdminState{Step: adminStepCalendarCode})
+
+	msg := tgbotapi.NewMessage(chatID, status+"\n\n"+
+		"Откройте ссылку и разрешите доступ. Если после этого браузер не покажет «Календарь подключён», "+
+		"скопируйте адрес из адресной строки или код и пришлите сюда.")
+	msg.ReplyMarkup = inlineKeyboard(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL("🔑 Выдать доступ", authorizer.AuthURL()),
+		),
+	)
+	if _, err := h.bot.Send(msg); err != nil {
+		h.log.Error("error sending calendar authorization link", "error", err)
+	}
+}
+
+func (h *Handler) handleCalendarCodeInput(chatID int64, userID int64, text string) {
+	h.clearAdminState(userID)
+
+	authorizer, ok := calendar.AuthorizerOf(h.calendar)
+	if !ok {
+		return
+	}
+
+	code := text
+	// Мастер мог прислать весь адрес, на который его вернул Google
+	if strings.HasPrefix(text, "http") {
+		redirect, err := url.Parse(text)
+		if err != nil || redirect.Query().Get("code") == "" {
+			h.sendMessage(chatID, "В адресе нет кода. Запросите новую ссылку командой /calendar")
+			return
+		}
+		if !authorizer.CheckState(redirect.Query().Get("state")) {
+			h.sendMessage(chatID, "Ссылка устарела. Запросите новую командой /calendar")
+			return
+		}
+		code = redirect.Query().Get("code")
+	}
+
+	ctx, cancel := context.WithTimeout(h.requestContext(), calendarTimeout)
+	defer cancel()
+
+	if err := authorizer.Authorize(ctx, code); err != nil {
+		h.log.Error("error authorizing google calendar", "error", err)
+		h.sendMessage(chatID, "Не удалось подключить календарь. Запросите новую ссылку командой /calendar")
+		return
+	}
+	h.sendMessage(chatID, "✅ Google Календарь подключён")
+}
+
+// ================Calendar queue==================
+
+// sendCalendarQueue показывает операции календаря, от которых обработчик очереди
+// отказался после всех попыток, с кнопками повтора.
+func (h *Handler) sendCalendarQueue(chatID int64) {
+	if h.calendar == nil {
+		h.sendMessage(chatID, "📅 Календарь не подключён: проверьте calendar.provider и журнал запуска")
+		return
+	}
+
+	pending, err := h.service.CountCalendarOperations(calendarOpStatusPending)
+	if err != nil {
+		h.log.Error("error counting calendar operations", "error", err)
+		h.sendMessage(chatID, "Не удалось получить очередь календаря")
+		return
+	}
+	dead, err := h.service.CountCalendarOperations(calendarOpStatusDead)
+	if err != nil {
+		h.log.Error("error counting calendar operations", "error", err)
+		h.sendMessage(chatID, "Не удалось получить очередь календаря")
+		return
+	}
+	ops, err := h.service.GetDeadCalendarOperations(calendarQueueLimit)
+	if err != nil {
+		h.log.Error("error getting dead calendar operations", "error", err)
+		h.sendMessage(chatID, "Не удалось получить очередь календаря")
+		return
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "📅 Очередь календаря\n\nОжидают отправки: %d", pending)
+	if len(ops) == 0 {
+		text.WriteString("\n\nНеудавшихся операций нет.")
+		h.sendMessage(chatID, text.String())
+		return
+	}
+
+	text.WriteString("\n\nНе удалось выполнить:")
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	for _, op := range ops {
+		description := h.describeCalendarOperation(op)
+		entry := fmt.Sprintf("\n\n%s\nПопыток: %d\nОшибка: %s", description, op.Attempts, shortenError(op.LastError))
+		if telegramLength(text.String()+entry) > telegramMessageLimit-calendarQueueFooterReserve {
+			break
+		}
+		text.WriteString(entry)
+		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{
+			h.button(chatID, "🔁 "+description, "cal_retry", op.UUID.String()),
+		})
+	}
+	if hidden := dead - int64(len(keyboard)); hidden > 0 {
+		fmt.Fprintf(&text, "\n\nНе показано операций: %d. Повторите показанные, и остальные появятся в списке.", hidden)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text.String())
+	msg.ReplyMarkup = inlineKeyboard(keyboard...)
+	if _, err := h.bot.Send(msg); err != nil {
+		h.log.Error("error sending calendar queue", "error", err)
+	}
+}
+
+// telegramLength длина текста так, как её считает Telegram
+func telegramLength(text string) int {
+	return len(utf16.Encode([]rune(text)))
+}
+
+// describeCalendarOperation подпись операции: действие и запись, к которой она относится
+func (h *Handler) describeCalendarOperation(op common.CalendarOperation) string {
+	action := "Обновить событие"
+	if op.Action == common.CalendarOpDelete {
+		action = "Удалить событие"
+	}
+
+	appointment, err := h.service.GetAppointmentByID(op.AppointmentID)
+	if err != nil {
+		return fmt.Sprintf("%s записи %s", action, op.AppointmentID)
+	}
+	return fmt.Sprintf("%s: %s %s", action, appointment.StartTime.In(h.location).Format("02.01 15:04"), appointment.Name)
+}
+
+func shortenError(text string) string {
+	runes := []rune(text)
+	if len(runes) <= calendarErrorPreview {
+		return text
+	}
+	return string(runes[:calendarErrorPreview]) + "…"
+}
+
+func (h *Handler) handleCalendarRetry(chatID int64, userID int64, value string) {
+	if !h.isAdmin(userID) {
+		h.log.Warn("admin action rejected for non-admin user", "action", "cal_retry")
+		h.handleUnknownCommand(tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}, From: &tgbotapi.User{ID: userID}}})
+		return
+	}
+
+	opID, err := uuid.Parse(value)
+	if err != nil {
+		h.sendMessage(chatID, "Неверный идентификатор операции")
+		return
+	}
+	if err := h.service.RequeueCalendarOperation(opID); err != nil {
+		h.log.Error("error requeueing calendar operation", "operation_id", opID, "error", err)
+		h.sendMessage(chatID, "Не удалось повторить операцию, возможно она уже выполнена")
+		return
+	}
+
+	h.sendMessage(chatID, "🔁 Операция снова в очереди")
+	h.sendCalendarQueue(chatID)
+}
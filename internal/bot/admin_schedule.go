@@ -0,0 +1,396 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RudinMaxim/BarberBot.git/common"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	adminStepWorkingHours    = "working_hours"
+	adminStepExceptionDate   = "exception_date"
+	adminStepExceptionHours  = "exception_hours"
+	adminStepExceptionReason = "exception_reason"
+	adminStepBreakHours      = "break_hours"
+	adminStepBlockRange      = "block_range"
+	adminStepBlockReason     = "block_reason"
+
+	dayOffInput = "выходной"
+)
+
+var weekdayNames = [...]string{"Воскресенье", "Понедельник", "Вторник", "Среда", "Четверг", "Пятница", "Суббота"}
+
+// weekdayOrder задаёт порядок дней в редакторе графика, начиная с понедельника
+var weekdayOrder = []int{1, 2, 3, 4, 5, 6, 0}
+
+// ================Weekly hours==================
+
+func (h *Handler) sendWorkingHoursEditor(chatID int64) {
+	workingHours, err := h.service.GetWorkingHours()
+	if err != nil {
+		h.log.Error("error getting working hours", "error", err)
+		h.sendMessage(chatID, "Не удалось получить график работы")
+		return
+	}
+
+	byDay := make(map[int]common.WorkingHours)
+	for _, wh := range workingHours {
+		byDay[wh.DayOfWeek] = wh
+	}
+
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	for _, day := range weekdayOrder {
+		hours := dayOffInput
+		if wh, ok := byDay[day]; ok && wh.IsActive {
+			hours = fmt.Sprintf("%s-%s", wh.StartTime.Format("15:04"), wh.EndTime.Format("15:04"))
+		}
+		button := h.button(
+			chatID,
+			fmt.Sprintf("%s: %s", weekdayNames[day], hours),
+			"wh_day",
+			strconv.Itoa(day),
+		)
+		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{button})
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "🕰 График работы. Выберите день, чтобы изменить часы:")
+	msg.ReplyMarkup = inlineKeyboard(keyboard...)
+	h.bot.Send(msg)
+}
+
+// ================Exceptions==================
+
+func (h *Handler) sendScheduleExceptions(chatID int64) {
+	exceptions, err := h.service.GetUpcomingScheduleExceptions()
+	if err != nil {
+		h.log.Error("error getting schedule exceptions", "error", err)
+		h.sendMessage(chatID, "Не удалось получить исключения из графика")
+		return
+	}
+
+	text := "📆 Исключения из графика:"
+	if len(exceptions) == 0 {
+		text += "\n\nИсключений нет."
+	}
+
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	for _, exception := range exceptions {
+		button := h.button(
+			chatID,
+			"🗑 "+describeScheduleException(exception),
+			"exc_del",
+			exception.UUID.String(),
+		)
+		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{button})
+	}
+	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{
+		h.button(chatID, "➕ Добавить исключение", "exc_add", ""),
+	})
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = inlineKeyboard(keyboard...)
+	h.bot.Send(msg)
+}
+
+func describeScheduleException(exception common.ScheduleException) string {
+	hours := dayOffInput
+	if !exception.IsClosed && exception.StartTime != nil && exception.EndTime != nil {
+		hours = fmt.Sprintf("%s-%s", exception.StartTime.Format("15:04"), exception.EndTime.Format("15:04"))
+	}
+
+	description := fmt.Sprintf("%s: %s", exception.Date.Format("02.01.2006"), hours)
+	if exception.Reason != "" {
+		description += " (" + exception.Reason + ")"
+	}
+	return description
+}
+
+// ================Breaks and blocks==================
+
+func (h *Handler) sendBreaks(chatID int64) {
+	breaks, err := h.service.GetBreaks()
+	if err != nil {
+		h.log.Error("error getting breaks", "error", err)
+		h.sendMessage(chatID, "Не удалось получить перерывы")
+		return
+	}
+
+	text := "☕ Регулярные перерывы:"
+	if len(breaks) == 0 {
+		text += "\n\nПерерывов нет."
+	}
+
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	for _, b := range breaks {
+		button := h.button(
+			chatID,
+			fmt.Sprintf("🗑 %s: %s-%s", weekdayNames[b.DayOfWeek], b.StartTime.Format("15:04"), b.EndTime.Format("15:04")),
+			"brk_del",
+			b.UUID.String(),
+		)
+		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{button})
+	}
+	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{
+		h.button(chatID, "➕ Добавить перерыв", "brk_add", ""),
+	})
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = inlineKeyboard(keyboard...)
+	h.bot.Send(msg)
+}
+
+func (h *Handler) sendBreakDaySelection(chatID int64) {
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	for _, day := range weekdayOrder {
+		button := h.button(chatID, weekdayNames[day], "brk_day", strconv.Itoa(day))
+		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{button})
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "Выберите день недели для перерыва:")
+	msg.ReplyMarkup = inlineKeyboard(keyboard...)
+	h.bot.Send(msg)
+}
+
+func (h *Handler) sendBlockedRanges(chatID int64) {
+	blocked, err := h.service.GetUpcomingBlockedRanges()
+	if err != nil {
+		h.log.Error("error getting blocked ranges", "error", err)
+		h.sendMessage(chatID, "Не удалось получить блокировки")
+		return
+	}
+
+	text := "⛔ Заблокированное время:"
+	if len(blocked) == 0 {
+		text += "\n\nБлокировок нет."
+	}
+
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	var fromCalendar []string
+	for _, b := range blocked {
+		description := fmt.Sprintf("%s %s-%s", b.StartTime.Format("02.01.2006"), b.StartTime.Format("15:04"), b.EndTime.Format("15:04"))
+		if b.Reason != "" {
+			description += " (" + b.Reason + ")"
+		}
+		// Блокировки из календаря пересоздаются синхронизацией, удалять их нужно в календаре
+		if b.Source == common.BlockSourceCalendar {
+			fromCalendar = append(fromCalendar, description)
+			continue
+		}
+		description = "🗑 " + description
+		button := h.button(chatID, description, "blk_del", b.UUID.String())
+		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{button})
+	}
+	if len(fromCalendar) > 0 {
+		text += "\n\nИз календаря (меняются в самом календаре):\n" + strings.Join(fromCalendar, "\n")
+	}
+	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{
+		h.button(chatID, "➕ Заблокировать время", "blk_add", ""),
+	})
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = inlineKeyboard(keyboard...)
+	h.bot.Send(msg)
+}
+
+func (h *Handler) handleScheduleAdminCallback(chatID int64, userID int64, action string, value string) {
+	if !h.isAdmin(userID) {
+		h.log.Warn("admin action rejected for non-admin user", "action", action)
+		h.handleUnknownCommand(tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}, From: &tgbotapi.User{ID: userID}}})
+		return
+	}
+
+	switch action {
+	case "wh_day":
+		day, err := strconv.Atoi(value)
+		if err != nil || day < 0 || day > 6 {
+			h.sendMessage(chatID, "Неверный день недели")
+			return
+		}
+		h.saveAdminState(userID, &AdminState{Step: adminStepWorkingHours, DayOfWeek: day})
+		h.sendMessage(chatID, fmt.Sprintf("%s: введите часы работы в формате 10:00-20:00 или «%s»:", weekdayNames[day], dayOffInput))
+	case "exc_add":
+		h.saveAdminState(userID, &AdminState{Step: adminStepExceptionDate})
+		h.sendMessage(chatID, "Введите дату в формате ДД.ММ.ГГГГ:")
+	case "exc_del":
+		exceptionID, err := uuid.Parse(value)
+		if err != nil {
+			h.sendMessage(chatID, "Неверный идентификатор исключения")
+			return
+		}
+		if err := h.service.DeleteScheduleException(exceptionID); err != nil {
+			h.log.Error("error deleting schedule exception", "error", err)
+			h.sendMessage(chatID, "Не удалось удалить исключение")
+			return
+		}
+		h.sendScheduleExceptions(chatID)
+	case "brk_add":
+		h.sendBreakDaySelection(chatID)
+	case "brk_day":
+		day, err := strconv.Atoi(value)
+		if err != nil || day < 0 || day > 6 {
+			h.sendMessage(chatID, "Неверный день недели")
+			return
+		}
+		h.saveAdminState(userID, &AdminState{Step: adminStepBreakHours, DayOfWeek: day})
+		h.sendMessage(chatID, fmt.Sprintf("%s: введите время перерыва в формате 13:00-14:00:", weekdayNames[day]))
+	case "brk_del":
+		breakID, err := uuid.Parse(value)
+		if err != nil {
+			h.sendMessage(chatID, "Неверный идентификатор перерыва")
+			return
+		}
+		if err := h.service.DeleteBreak(breakID); err != nil {
+			h.log.Error("error deleting break", "error", err)
+			h.sendMessage(chatID, "Не удалось удалить перерыв")
+			return
+		}
+		h.sendBreaks(chatID)
+	case "blk_add":
+		h.saveAdminState(userID, &AdminState{Step: adminStepBlockRange})
+		h.sendMessage(chatID, "Введите дату и время в формате ДД.ММ.ГГГГ 13:00-15:00:")
+	case "blk_del":
+		blockedID, err := uuid.Parse(value)
+		if err != nil {
+			h.sendMessage(chatID, "Неверный идентификатор блокировки")
+			return
+		}
+		if err := h.service.DeleteBlockedRange(blockedID); err != nil {
+			h.log.Error("error deleting blocked range", "error", err)
+			h.sendMessage(chatID, "Не удалось удалить блокировку")
+			return
+		}
+		h.sendBlockedRanges(chatID)
+	}
+}
+
+func (h *Handler) handleScheduleAdminInput(chatID int64, userID int64, state *AdminState, text string) {
+	switch state.Step {
+	case adminStepWorkingHours:
+		h.clearAdminState(userID)
+
+		start, end, closed, err := parseHoursInput(text)
+		if err != nil {
+			h.sendMessage(chatID, fmt.Sprintf("Не удалось разобрать часы. Пример: 10:00-20:00 или «%s»", dayOffInput))
+			return
+		}
+		if err := h.service.SetWorkingHours(state.DayOfWeek, start, end, !closed); err != nil {
+			h.log.Error("error saving working hours", "error", err)
+			h.sendMessage(chatID, "Не удалось сохранить график")
+			return
+		}
+		h.sendWorkingHoursEditor(chatID)
+	case adminStepExceptionDate:
+		date, err := time.Parse("02.01.2006", text)
+		if err != nil {
+			h.sendMessage(chatID, "Дата должна быть в формате ДД.ММ.ГГГГ, например 31.12.2024")
+			return
+		}
+		state.Exception.Date = date
+		state.Step = adminStepExceptionHours
+		h.saveAdminState(userID, state)
+		h.sendMessage(chatID, fmt.Sprintf("Введите часы работы в этот день (10:00-15:00) или «%s»:", dayOffInput))
+	case adminStepExceptionHours:
+		start, end, closed, err := parseHoursInput(text)
+		if err != nil {
+			h.sendMessage(chatID, fmt.Sprintf("Не удалось разобрать часы. Пример: 10:00-15:00 или «%s»", dayOffInput))
+			return
+		}
+		state.Exception.IsClosed = closed
+		if !closed {
+			state.Exception.StartTime = &start
+			state.Exception.EndTime = &end
+		}
+		state.Step = adminStepExceptionReason
+		h.saveAdminState(userID, state)
+		h.sendMessage(chatID, "Укажите причину (например, «отпуск») или «-»:")
+	case adminStepExceptionReason:
+		h.clearAdminState(userID)
+
+		if text != "-" {
+			state.Exception.Reason = text
+		}
+		if err := h.service.AddScheduleException(&state.Exception); err != nil {
+			h.log.Error("error saving schedule exception", "error", err)
+			h.sendMessage(chatID, "Не удалось сохранить исключение")
+			return
+		}
+		h.sendScheduleExceptions(chatID)
+	case adminStepBreakHours:
+		h.clearAdminState(userID)
+
+		start, end, closed, err := parseHoursInput(text)
+		if err != nil || closed {
+			h.sendMessage(chatID, "Не удалось разобрать время. Пример: 13:00-14:00")
+			return
+		}
+		if err := h.service.AddBreak(state.DayOfWeek, start, end, "Перерыв"); err != nil {
+			h.log.Error("error saving break", "error", err)
+			h.sendMessage(chatID, "Не удалось сохранить перерыв")
+			return
+		}
+		h.sendBreaks(chatID)
+	case adminStepBlockRange:
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			h.sendMessage(chatID, "Формат: ДД.ММ.ГГГГ 13:00-15:00")
+			return
+		}
+		date, dateErr := time.ParseInLocation("02.01.2006", fields[0], h.location)
+		start, end, closed, err := parseHoursInput(fields[1])
+		if dateErr != nil || err != nil || closed {
+			h.sendMessage(chatID, "Формат: ДД.ММ.ГГГГ 13:00-15:00")
+			return
+		}
+		state.Block.StartTime = time.Date(date.Year(), date.Month(), date.Day(), start.Hour(), start.Minute(), 0, 0, h.location)
+		state.Block.EndTime = time.Date(date.Year(), date.Month(), date.Day(), end.Hour(), end.Minute(), 0, 0, h.location)
+		state.Step = adminStepBlockReason
+		h.saveAdminState(userID, state)
+		h.sendMessage(chatID, "Укажите причину (например, «к врачу») или «-»:")
+	case adminStepBlockReason:
+		h.clearAdminState(userID)
+
+		reason := ""
+		if text != "-" {
+			reason = text
+		}
+		if err := h.service.AddBlockedRange(state.Block.StartTime, state.Block.EndTime, reason); err != nil {
+			h.log.Error("error saving blocked range", "error", err)
+			h.sendMessage(chatID, "Не удалось сохранить блокировку")
+			return
+		}
+		h.sendBlockedRanges(chatID)
+	}
+}
+
+// parseHoursInput разбирает ввод мастера: «10:00-20:00» или «выходной».
+func parseHoursInput(text string) (time.Time, time.Time, bool, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == dayOffInput {
+		return time.Time{}, time.Time{}, true, nil
+	}
+
+	parts := strings.Split(strings.ReplaceAll(text, " ", ""), "-")
+	if len(parts) != 2 {
+		return time.Time{}, time.Time{}, false, errors.New("expected HH:MM-HH:MM")
+	}
+
+	start, err := time.Parse("15:04", parts[0])
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+	end, err := time.Parse("15:04", parts[1])
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, false, errors.New("start must be before end")
+	}
+
+	return start, end, false, nil
+}
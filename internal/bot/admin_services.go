@@ -0,0 +1,271 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/RudinMaxim/BarberBot.git/common"
+	"github.com/RudinMaxim/BarberBot.git/helper"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	adminStepServiceName     = "service_name"
+	adminStepServiceDuration = "service_duration"
+	adminStepServicePrice    = "service_price"
+	adminStepEditName        = "edit_name"
+	adminStepEditDuration    = "edit_duration"
+	adminStepEditPrice       = "edit_price"
+)
+
+// AdminState хранит незавершённый диалог мастера, ожидающий текстового ввода
+type AdminState struct {
+	Step      string
+	ServiceID uuid.UUID
+	Draft     common.Service
+	DayOfWeek int
+	Exception common.ScheduleException
+	Block     common.BlockedRange
+}
+
+// ================Catalogue==================
+
+func (h *Handler) sendServiceCatalogue(chatID int64) {
+	services, err := h.service.GetAllServices()
+	if err != nil {
+		h.log.Error("error getting services", "error", err)
+		h.sendMessage(chatID, helper.GetText("invalid_get_services"))
+		return
+	}
+
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	for _, service := range services {
+		status := "✅"
+		if !service.IsActive {
+			status = "🚫"
+		}
+		button := h.button(
+			chatID,
+			fmt.Sprintf("%s %s (%d мин, %.2f руб)", status, service.Name, service.Duration, service.Price),
+			"svc",
+			service.UUID.String(),
+		)
+		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{button})
+	}
+	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{
+		h.button(chatID, "➕ Добавить услугу", "svc_add", ""),
+	})
+
+	msg := tgbotapi.NewMessage(chatID, "🗂 Каталог услуг:")
+	msg.ReplyMarkup = inlineKeyboard(keyboard...)
+	h.bot.Send(msg)
+}
+
+func (h *Handler) sendServiceCard(chatID int64, serviceID uuid.UUID) {
+	service, err := h.service.GetServiceByID(serviceID)
+	if err != nil {
+		h.log.Error("error getting service", "error", err)
+		h.sendMessage(chatID, helper.GetText("invalid_id_service"))
+		return
+	}
+
+	status := "✅ Доступна для записи"
+	toggleText := "🚫 Скрыть"
+	if !service.IsActive {
+		status = "🚫 Скрыта"
+		toggleText = "✅ Показать"
+	}
+
+	messageText := fmt.Sprintf(
+		"💇 %s\n\n⏱ Длительность: %d мин\n💰 Цена: %.2f руб.\n📊 %s",
+		service.Name, service.Duration, service.Price, status,
+	)
+
+	id := service.UUID.String()
+	keyboard := inlineKeyboard(
+		tgbotapi.NewInlineKeyboardRow(
+			h.button(chatID, "✏️ Название", "svc_name", id),
+			h.button(chatID, "⏱ Длительность", "svc_duration", id),
+			h.button(chatID, "💰 Цена", "svc_price", id),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			h.button(chatID, "⬆️", "svc_up", id),
+			h.button(chatID, "⬇️", "svc_down", id),
+			h.button(chatID, toggleText, "svc_toggle", id),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			h.button(chatID, helper.GetText("back_button"), "svc_list", ""),
+		),
+	)
+
+	msg := tgbotapi.NewMessage(chatID, messageText)
+	msg.ReplyMarkup = keyboard
+	h.bot.Send(msg)
+}
+
+func (h *Handler) handleServiceAdminCallback(chatID int64, userID int64, action string, value string) {
+	if !h.isAdmin(userID) {
+		h.log.Warn("admin action rejected for non-admin user", "action", action)
+		h.handleUnknownCommand(tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}, From: &tgbotapi.User{ID: userID}}})
+		return
+	}
+
+	switch action {
+	case "svc_list":
+		h.sendServiceCatalogue(chatID)
+		return
+	case "svc_add":
+		h.saveAdminState(userID, &AdminState{Step: adminStepServiceName})
+		h.sendMessage(chatID, "Введите название новой услуги:")
+		return
+	}
+
+	serviceID, err := uuid.Parse(value)
+	if err != nil {
+		h.sendMessage(chatID, helper.GetText("invalid_id_service"))
+		return
+	}
+
+	switch action {
+	case "svc":
+		h.sendServiceCard(chatID, serviceID)
+	case "svc_name":
+		h.saveAdminState(userID, &AdminState{Step: adminStepEditName, ServiceID: serviceID})
+		h.sendMessage(chatID, "Введите новое название услуги:")
+	case "svc_duration":
+		h.saveAdminState(userID, &AdminState{Step: adminStepEditDuration, ServiceID: serviceID})
+		h.sendMessage(chatID, "Введите новую длительность в минутах:")
+	case "svc_price":
+		h.saveAdminState(userID, &AdminState{Step: adminStepEditPrice, ServiceID: serviceID})
+		h.sendMessage(chatID, "Введите новую цену в рублях:")
+	case "svc_toggle":
+		if _, err := h.service.ToggleService(serviceID); err != nil {
+			h.log.Error("error toggling service", "error", err)
+			h.sendMessage(chatID, "Не удалось изменить услугу")
+			return
+		}
+		h.sendServiceCard(chatID, serviceID)
+	case "svc_up", "svc_down":
+		step := 1
+		if action == "svc_up" {
+			step = -1
+		}
+		if err := h.service.MoveService(serviceID, step); err != nil {
+			h.log.Error("error moving service", "error", err)
+			h.sendMessage(chatID, "Не удалось изменить порядок услуг")
+			return
+		}
+		h.sendServiceCatalogue(chatID)
+	}
+}
+
+// ================Admin input==================
+
+func (h *Handler) hasAdminInput(userID int64) bool {
+	return h.isAdmin(userID) && h.loadAdminState(userID) != nil
+}
+
+func (h *Handler) handleAdminInput(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	userID := message.From.ID
+	state := h.loadAdminState(userID)
+	if state == nil {
+		return
+	}
+	text := strings.TrimSpace(message.Text)
+
+	switch state.Step {
+	case adminStepServiceName:
+		state.Draft.Name = text
+		state.Step = adminStepServiceDuration
+		h.saveAdminState(userID, state)
+		h.sendMessage(chatID, "Введите длительность в минутах:")
+	case adminStepServiceDuration:
+		duration, ok := h.parseDurationInput(chatID, text)
+		if !ok {
+			return
+		}
+		state.Draft.Duration = duration
+		state.Step = adminStepServicePrice
+		h.saveAdminState(userID, state)
+		h.sendMessage(chatID, "Введите цену в рублях:")
+	case adminStepServicePrice:
+		price, ok := h.parsePriceInput(chatID, text)
+		if !ok {
+			return
+		}
+		h.clearAdminState(userID)
+
+		service, err := h.service.CreateService(state.Draft.Name, state.Draft.Duration, price)
+		if err != nil {
+			h.log.Error("error creating service", "error", err)
+			h.sendMessage(chatID, "Не удалось создать услугу")
+			return
+		}
+		h.sendServiceCard(chatID, service.UUID)
+	case adminStepEditName, adminStepEditDuration, adminStepEditPrice:
+		h.handleServiceEditInput(chatID, userID, state, text)
+	case adminStepWorkingHours, adminStepExceptionDate, adminStepExceptionHours, adminStepExceptionReason,
+		adminStepBreakHours, adminStepBlockRange, adminStepBlockReason:
+		h.handleScheduleAdminInput(chatID, userID, state, text)
+	case adminStepCalendarCode:
+		h.handleCalendarCodeInput(chatID, userID, text)
+	default:
+		h.clearAdminState(userID)
+	}
+}
+
+func (h *Handler) handleServiceEditInput(chatID int64, userID int64, state *AdminState, text string) {
+	service, err := h.service.GetServiceByID(state.ServiceID)
+	if err != nil {
+		h.log.Error("error getting service", "error", err)
+		h.clearAdminState(userID)
+		h.sendMessage(chatID, helper.GetText("invalid_id_service"))
+		return
+	}
+
+	switch state.Step {
+	case adminStepEditName:
+		service.Name = text
+	case adminStepEditDuration:
+		duration, ok := h.parseDurationInput(chatID, text)
+		if !ok {
+			return
+		}
+		service.Duration = duration
+	case adminStepEditPrice:
+		price, ok := h.parsePriceInput(chatID, text)
+		if !ok {
+			return
+		}
+		service.Price = price
+	}
+	h.clearAdminState(userID)
+
+	if err := h.service.UpdateService(&service); err != nil {
+		h.log.Error("error updating service", "error", err)
+		h.sendMessage(chatID, "Не удалось обновить услугу")
+		return
+	}
+	h.sendServiceCard(chatID, service.UUID)
+}
+
+func (h *Handler) parseDurationInput(chatID int64, text string) (int, bool) {
+	duration, err := strconv.Atoi(text)
+	if err != nil || duration <= 0 {
+		h.sendMessage(chatID, "Длительность должна быть целым числом минут, например 45")
+		return 0, false
+	}
+	return duration, true
+}
+
+func (h *Handler) parsePriceInput(chatID int64, text string) (float64, bool) {
+	price, err := strconv.ParseFloat(strings.ReplaceAll(text, ",", "."), 64)
+	if err != nil || price < 0 {
+		h.sendMessage(chatID, "Цена должна быть числом, например 1500")
+		return 0, false
+	}
+	return price, true
+}
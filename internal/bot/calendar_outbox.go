@@ -0,0 +1,255 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/RudinMaxim/BarberBot.git/common"
+	"github.com/RudinMaxim/BarberBot.git/config"
+	"github.com/RudinMaxim/BarberBot.git/internal/calendar"
+	"github.com/RudinMaxim/BarberBot.git/internal/metrics"
+	"gorm.io/gorm"
+)
+
+const (
+	calendarOpStatusPending = "pending"
+	calendarOpStatusDone    = "done"
+	// calendarOpStatusDead попытки исчерпаны, операцию можно повторить из /calendar_queue
+	calendarOpStatusDead = "dead"
+
+	calendarOutboxPollInterval = 10 * time.Second
+	calendarOutboxBatchSize    = 20
+	// calendarOutboxLease аренда пакета. Её хватает, даже если каждая операция пакета
+	// упрётся в calendarTimeout, иначе операции конца пакета заберёт второй обработчик.
+	calendarOutboxLease       = calendarOutboxBatchSize*calendarTimeout + time.Minute
+	calendarOutboxMaxAttempts = 10
+	calendarOutboxBaseDelay   = 30 * time.Second
+	calendarOutboxMaxDelay    = time.Hour
+	// calendarOutboxRetention сколько хранить выполненные операции
+	calendarOutboxRetention       = 7 * 24 * time.Hour
+	calendarOutboxCleanupInterval = time.Hour
+)
+
+// CalendarOutbox выполняет операции календаря из очереди в базе. Операции ставятся
+// в очередь вместе с изменением записи, поэтому сбой календаря не теряет событие:
+// попытка повторяется с растущей паузой, а после calendarOutboxMaxAttempts
+// операция ждёт мастера в /calendar_queue.
+type CalendarOutbox struct {
+	service     *Service
+	calendar    calendar.CalendarProvider
+	log         *slog.Logger
+	lastCleanup time.Time
+}
+
+func NewCalendarOutbox(service *Service, calendarProvider calendar.CalendarProvider) *CalendarOutbox {
+	return &CalendarOutbox{
+		service:  service,
+		calendar: calendarProvider,
+		log:      slog.Default().With("component", "calendar_outbox"),
+	}
+}
+
+// Run обрабатывает очередь, пока не отменён ctx. Без календаря сразу возвращается.
+func (o *CalendarOutbox) Run(ctx context.Context) {
+	if o.calendar == nil {
+		return
+	}
+
+	ticker := time.NewTicker(calendarOutboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		o.processDue(ctx)
+		o.cleanup()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (o *CalendarOutbox) processDue(ctx context.Context) {
+	// Пока мастер не выдал доступ, попытки не тратятся
+	if authorizer, ok := calendar.AuthorizerOf(o.calendar); ok && !authorizer.Authorized() {
+		o.log.Debug("calendar is not authorized yet, outbox is paused")
+		return
+	}
+
+	leasedUntil := time.Now().Add(calendarOutboxLease)
+	ops, err := o.service.ClaimDueCalendarOperations(calendarOutboxLease, calendarOutboxBatchSize)
+	if err != nil {
+		o.log.Error("error claiming calendar operations", "error", err)
+		return
+	}
+
+	for i, op := range ops {
+		if ctx.Err() != nil {
+			// Аренда истечёт, и операции заберёт следующий запуск
+			return
+		}
+		// Операцию, которая может не успеть до конца аренды, лучше отдать следующему проходу
+		if time.Now().Add(calendarTimeout).After(leasedUntil) {
+			o.log.Warn("calendar outbox lease is running out, leaving the rest of the batch", "left", len(ops)-i)
+			return
+		}
+		o.process(ctx, op)
+	}
+
+	o.observeQueue()
+}
+
+func (o *CalendarOutbox) process(ctx context.Context, op common.CalendarOperation) {
+	logger := o.log.With(
+		config.LogKeyCorrelationID, op.CorrelationID,
+		"operation_id", op.UUID,
+		"appointment_id", op.AppointmentID,
+		"action", op.Action,
+	)
+	service := o.service.forRequest(op.CorrelationID, logger)
+	attempt := op.Attempts + 1
+
+	callCtx, cancel := context.WithTimeout(config.WithLogger(ctx, logger), calendarTimeout)
+	defer cancel()
+
+	err := o.apply(callCtx, service, op)
+	if err != nil && ctx.Err() != nil {
+		// Попытку прервала остановка бота, а не календарь: операцию заберёт следующий запуск
+		logger.Info("calendar operation interrupted by shutdown", "attempt", attempt)
+		return
+	}
+
+	status, delay := calendarOutboxNext(attempt, err)
+	switch status {
+	case calendarOpStatusDone:
+		if err := service.MarkCalendarOperationDone(op.UUID); err != nil {
+			logger.Error("error marking calendar operation as done", "error", err)
+			return
+		}
+		logger.Info("calendar operation applied", "attempt", attempt)
+	case calendarOpStatusDead:
+		logger.Error("calendar operation failed, giving up", "attempt", attempt, "error", err)
+		if err := service.MarkCalendarOperationDead(op.UUID, err.Error()); err != nil {
+			logger.Error("error marking calendar operation as dead", "error", err)
+		}
+	default:
+		logger.Warn("calendar operation failed, will retry", "attempt", attempt, "retry_in", delay, "error", err)
+		if err := service.RetryCalendarOperation(op.UUID, time.Now().Add(delay), err.Error()); err != nil {
+			logger.Error("error rescheduling calendar operation", "error", err)
+		}
+	}
+}
+
+// calendarOutboxNext решает судьбу операции после попытки attempt, закончившейся err:
+// выполнена, повторить через паузу или отдать мастеру. Удалённое мастером событие
+// повтор не вернёт, поэтому такая операция сразу попадает в /calendar_queue.
+func calendarOutboxNext(attempt int, err error) (string, time.Duration) {
+	switch {
+	case err == nil:
+		return calendarOpStatusDone, 0
+	case attempt >= calendarOutboxMaxAttempts || errors.Is(err, calendar.ErrEventNotFound):
+		return calendarOpStatusDead, 0
+	default:
+		return calendarOpStatusPending, calendarOutboxBackoff(attempt)
+	}
+}
+
+// cleanup раз в calendarOutboxCleanupInterval удаляет выполненные операции старше calendarOutboxRetention
+func (o *CalendarOutbox) cleanup() {
+	if time.Since(o.lastCleanup) < calendarOutboxCleanupInterval {
+		return
+	}
+	o.lastCleanup = time.Now()
+
+	deleted, err := o.service.DeleteDoneCalendarOperations(calendarOutboxRetention)
+	if err != nil {
+		o.log.Error("error deleting done calendar operations", "error", err)
+		return
+	}
+	if deleted > 0 {
+		o.log.Info("done calendar operations deleted", "count", deleted)
+	}
+}
+
+func (o *CalendarOutbox) apply(ctx context.Context, service *Service, op common.CalendarOperation) error {
+	switch op.Action {
+	case common.CalendarOpUpsert:
+		return o.upsert(ctx, service, op)
+	case common.CalendarOpDelete:
+		err := o.calendar.RemoveEvent(ctx, op.EventID)
+		// Событие уже удалено мастером или прошлой попыткой
+		if errors.Is(err, calendar.ErrEventNotFound) {
+			return nil
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown calendar operation %q", op.Action)
+	}
+}
+
+// upsert приводит событие к текущему состоянию записи, а не к состоянию на момент
+// постановки в очередь: устаревшая операция не откатит более позднее изменение.
+func (o *CalendarOutbox) upsert(ctx context.Context, service *Service, op common.CalendarOperation) error {
+	appointment, err := service.GetAppointmentByID(op.AppointmentID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get appointment: %w", err)
+	}
+	// Событие отменённой записи удаляет своя операция
+	if appointment.Status == "cancelled" {
+		return nil
+	}
+
+	client, err := service.GetClientBy("uuid", appointment.ClientID)
+	if err != nil {
+		return fmt.Errorf("failed to get client: %w", err)
+	}
+	event := calendar.AppointmentEvent(appointment, client)
+
+	if appointment.CalendarEventID != "" {
+		err := o.calendar.UpdateEvent(ctx, event)
+		if errors.Is(err, calendar.ErrEventNotFound) {
+			// Мастер удалил событие: запись отменит синхронизация календаря, а если
+			// она этого не сделает, мастер увидит операцию в /calendar_queue
+			return fmt.Errorf("appointment event %s was removed from calendar: %w", appointment.CalendarEventID, err)
+		}
+		return err
+	}
+
+	// ID события задан заранее, повтор после сбоя не создаст второе событие
+	event.ID = op.EventID
+	eventID, err := o.calendar.AddEvent(ctx, event)
+	if err != nil {
+		return err
+	}
+	return service.SaveCalendarEventID(appointment.UUID, eventID)
+}
+
+func (o *CalendarOutbox) observeQueue() {
+	for _, status := range []string{calendarOpStatusPending, calendarOpStatusDead} {
+		count, err := o.service.CountCalendarOperations(status)
+		if err != nil {
+			o.log.Error("error counting calendar operations", "status", status, "error", err)
+			return
+		}
+		metrics.CalendarOutbox.WithLabelValues(status).Set(float64(count))
+	}
+}
+
+// calendarOutboxBackoff пауза перед следующей попыткой: 30s, 1m, 2m, ... но не больше часа
+func calendarOutboxBackoff(attempt int) time.Duration {
+	delay := calendarOutboxBaseDelay
+	for i := 1; i < attempt && delay < calendarOutboxMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > calendarOutboxMaxDelay {
+		return calendarOutboxMaxDelay
+	}
+	return delay
+}
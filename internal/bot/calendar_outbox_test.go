@@ -0,0 +1,233 @@
+package bot
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RudinMaxim/BarberBot.git/internal/calendar"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func TestCalendarOutboxBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{5, 8 * time.Minute},
+		{6, 16 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{9, time.Hour},
+		{100, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := calendarOutboxBackoff(tt.attempt); got != tt.want {
+			t.Errorf("calendarOutboxBackoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestCalendarOutboxNext(t *testing.T) {
+	errTimeout := errors.New("context deadline exceeded")
+	errRemoved := fmt.Errorf("appointment event was removed from calendar: %w", calendar.ErrEventNotFound)
+
+	tests := []struct {
+		name       string
+		attempt    int
+		err        error
+		wantStatus string
+		wantDelay  time.Duration
+	}{
+		{"applied", 1, nil, calendarOpStatusDone, 0},
+		{"applied on last attempt", calendarOutboxMaxAttempts, nil, calendarOpStatusDone, 0},
+		{"first failure", 1, errTimeout, calendarOpStatusPending, 30 * time.Second},
+		{"later failure", 4, errTimeout, calendarOpStatusPending, 4 * time.Minute},
+		{"last attempt failed", calendarOutboxMaxAttempts, errTimeout, calendarOpStatusDead, 0},
+		{"event removed by master", 1, errRemoved, calendarOpStatusDead, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, delay := calendarOutboxNext(tt.attempt, tt.err)
+			if status != tt.wantStatus || delay != tt.wantDelay {
+				t.Errorf("calendarOutboxNext(%d, %v) = %s, %s, want %s, %s",
+					tt.attempt, tt.err, status, delay, tt.wantStatus, tt.wantDelay)
+			}
+		})
+	}
+}
+
+func TestCalendarOutboxLeaseCoversBatch(t *testing.T) {
+	if worst := calendarOutboxBatchSize * calendarTimeout; calendarOutboxLease <= worst {
+		t.Errorf("lease %s does not cover a batch of %d operations timing out after %s", calendarOutboxLease, calendarOutboxBatchSize, calendarTimeout)
+	}
+}
+
+func TestClaimDueCalendarOperations(t *testing.T) {
+	first, second := uuid.New(), uuid.New()
+	db := &recordingDB{
+		columns: []string{"uuid", "action", "status", "attempts"},
+		rows: [][]driver.Value{
+			{first.String(), "upsert", calendarOpStatusPending, int64(0)},
+			{second.String(), "delete", calendarOpStatusPending, int64(3)},
+		},
+	}
+	now := time.Date(2024, 3, 31, 8, 0, 0, 0, time.UTC)
+
+	ops, err := db.repository(t).ClaimDueCalendarOperations(now, calendarOutboxLease, calendarOutboxBatchSize)
+	if err != nil {
+		t.Fatalf("ClaimDueCalendarOperations: %v", err)
+	}
+	if len(ops) != 2 || ops[0].UUID != first || ops[1].UUID != second || ops[1].Attempts != 3 {
+		t.Fatalf("claimed %+v, want both pending operations", ops)
+	}
+	if !db.committed {
+		t.Error("claim transaction was not committed")
+	}
+
+	selects := db.execs("SELECT")
+	if len(selects) != 1 {
+		t.Fatalf("got %d selects, want 1", len(selects))
+	}
+	query := selects[0].query
+	for _, want := range []string{"status = $1 AND next_attempt_at <= $2", "locked_until IS NULL OR locked_until < $3", "ORDER BY created_at", "FOR UPDATE SKIP LOCKED"} {
+		if !strings.Contains(query, want) {
+			t.Errorf("claim query %q does not contain %q", query, want)
+		}
+	}
+	if args := selects[0].args; args[0] != calendarOpStatusPending || args[1] != now || args[2] != now {
+		t.Errorf("claim query args = %v, want pending and now", args)
+	}
+
+	updates := db.execs("UPDATE")
+	if len(updates) != 1 {
+		t.Fatalf("got %d updates, want 1", len(updates))
+	}
+	update := updates[0]
+	if !strings.Contains(update.query, `"attempts"=attempts + 1`) {
+		t.Errorf("claim does not count the attempt: %q", update.query)
+	}
+	if got := update.set()["locked_until"]; got != now.Add(calendarOutboxLease) {
+		t.Errorf("locked_until = %v, want %v", got, now.Add(calendarOutboxLease))
+	}
+	ids := fmt.Sprint(update.args)
+	if !strings.Contains(ids, first.String()) || !strings.Contains(ids, second.String()) {
+		t.Errorf("claim update args %v do not lease both operations", update.args)
+	}
+}
+
+func TestClaimDueCalendarOperationsEmpty(t *testing.T) {
+	db := &recordingDB{columns: []string{"uuid"}}
+
+	ops, err := db.repository(t).ClaimDueCalendarOperations(time.Now(), calendarOutboxLease, calendarOutboxBatchSize)
+	if err != nil || len(ops) != 0 {
+		t.Fatalf("ClaimDueCalendarOperations = %v, %v, want nothing", ops, err)
+	}
+	if updates := db.execs("UPDATE"); len(updates) != 0 {
+		t.Errorf("nothing to claim, but got updates %v", updates)
+	}
+}
+
+func TestCalendarOperationTransitions(t *testing.T) {
+	opID := uuid.New()
+	retryAt := time.Date(2024, 11, 3, 6, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		transition func(s *Service) error
+		wantStatus string
+		wantError  string
+		wantNext   *time.Time
+	}{
+		{"done", func(s *Service) error { return s.MarkCalendarOperationDone(opID) }, calendarOpStatusDone, "", nil},
+		{"retry", func(s *Service) error { return s.RetryCalendarOperation(opID, retryAt, "timeout") }, calendarOpStatusPending, "timeout", &retryAt},
+		{"dead", func(s *Service) error { return s.MarkCalendarOperationDead(opID, "event removed") }, calendarOpStatusDead, "event removed", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &recordingDB{rowsAffected: 1}
+			if err := tt.transition(NewService(db.repository(t), time.UTC, true)); err != nil {
+				t.Fatalf("transition: %v", err)
+			}
+
+			updates := db.execs("UPDATE")
+			if len(updates) != 1 {
+				t.Fatalf("got %d updates, want 1", len(updates))
+			}
+			set := updates[0].set()
+			if set["status"] != tt.wantStatus {
+				t.Errorf("status = %v, want %s", set["status"], tt.wantStatus)
+			}
+			if set["last_error"] != tt.wantError {
+				t.Errorf("last_error = %v, want %q", set["last_error"], tt.wantError)
+			}
+			// Аренда снимается при любом исходе, иначе операция ждала бы её истечения
+			if value, ok := set["locked_until"]; !ok || value != nil {
+				t.Errorf("locked_until = %v, want NULL", value)
+			}
+			if tt.wantNext != nil && set["next_attempt_at"] != *tt.wantNext {
+				t.Errorf("next_attempt_at = %v, want %v", set["next_attempt_at"], *tt.wantNext)
+			}
+			if args := updates[0].args; args[len(args)-1] != opID.String() {
+				t.Errorf("update targets %v, want %s", args[len(args)-1], opID)
+			}
+		})
+	}
+}
+
+func TestRequeueCalendarOperation(t *testing.T) {
+	opID := uuid.New()
+
+	db := &recordingDB{rowsAffected: 1}
+	if err := NewService(db.repository(t), time.UTC, true).RequeueCalendarOperation(opID); err != nil {
+		t.Fatalf("RequeueCalendarOperation: %v", err)
+	}
+	update := db.execs("UPDATE")[0]
+	if !strings.Contains(update.query, "status = $") {
+		t.Errorf("requeue does not check the status: %q", update.query)
+	}
+	set := update.set()
+	if set["status"] != calendarOpStatusPending || set["attempts"] != int64(0) {
+		t.Errorf("requeue sets %v, want pending with zero attempts", set)
+	}
+
+	// Операцию уже повторили или она выполнена
+	db = &recordingDB{rowsAffected: 0}
+	err := NewService(db.repository(t), time.UTC, true).RequeueCalendarOperation(opID)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("RequeueCalendarOperation of a non-dead operation = %v, want ErrRecordNotFound", err)
+	}
+}
+
+func TestDeleteDoneCalendarOperations(t *testing.T) {
+	db := &recordingDB{rowsAffected: 42}
+
+	before := time.Now().Add(-calendarOutboxRetention)
+	deleted, err := NewService(db.repository(t), time.UTC, true).DeleteDoneCalendarOperations(calendarOutboxRetention)
+	if err != nil || deleted != 42 {
+		t.Fatalf("DeleteDoneCalendarOperations = %d, %v, want 42", deleted, err)
+	}
+
+	deletes := db.execs("DELETE")
+	if len(deletes) != 1 {
+		t.Fatalf("got %d deletes, want 1", len(deletes))
+	}
+	args := deletes[0].args
+	if args[0] != calendarOpStatusDone {
+		t.Errorf("deletes status %v, want %s", args[0], calendarOpStatusDone)
+	}
+	if cutoff, ok := args[1].(time.Time); !ok || cutoff.Before(before) || cutoff.After(before.Add(time.Minute)) {
+		t.Errorf("deletes operations updated before %v, want about %v", args[1], before)
+	}
+}
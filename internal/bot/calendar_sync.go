@@ -0,0 +1,294 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RudinMaxim/BarberBot.git/common"
+	"github.com/RudinMaxim/BarberBot.git/config"
+	"github.com/RudinMaxim/BarberBot.git/helper"
+	"github.com/RudinMaxim/BarberBot.git/internal/calendar"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// calendarSyncTimeout ограничивает один проход синхронизации
+	calendarSyncTimeout = time.Minute
+	// calendarSyncMaxCancellations сколько записей проход отменяет сам. Больше похоже
+	// на сбой календаря, чем на решение мастера, и отмену подтверждает мастер.
+	calendarSyncMaxCancellations = 3
+	// calendarAlertButtons сколько записей показывать кнопками в предупреждении
+	calendarAlertButtons = 10
+)
+
+// CalendarSync периодически читает календарь мастера. Его личные события становятся
+// блокировками, а удалённые или перенесённые мастером события записей отменяют
+// или переносят сами записи с уведомлением клиента.
+type CalendarSync struct {
+	handler  *Handler
+	interval time.Duration
+	horizon  time.Duration
+	log      *slog.Logger
+	// alerts предупреждения, уже отправленные мастеру. Пока ситуация не изменилась,
+	// они не повторяются на каждом проходе.
+	alerts map[string]bool
+	// passAlerts предупреждения, актуальные в текущем проходе
+	passAlerts map[string]bool
+}
+
+func NewCalendarSync(handler *Handler, cfg config.CalendarSync) *CalendarSync {
+	logger := slog.Default().With("component", "calendar_sync")
+
+	// Копия обработчика пишет уведомления клиентам в журнал синхронизации
+	scoped := *handler
+	scoped.log = logger
+	scoped.service = handler.service.forRequest("", logger)
+
+	return &CalendarSync{
+		handler:  &scoped,
+		interval: cfg.Interval,
+		horizon:  cfg.Horizon,
+		log:      logger,
+		alerts:   make(map[string]bool),
+	}
+}
+
+// Run синхронизирует календарь каждые interval, пока не отменён ctx.
+// Без календаря или с нулевым интервалом сразу возвращается.
+func (s *CalendarSync) Run(ctx context.Context) {
+	if s.handler.calendar == nil || s.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.sync(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *CalendarSync) sync(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(config.WithLogger(ctx, s.log), calendarSyncTimeout)
+	defer cancel()
+
+	from := time.Now()
+	to := from.Add(s.horizon)
+	s.passAlerts = make(map[string]bool)
+
+	events, err := s.handler.calendar.ListEvents(ctx, from, to)
+	if errors.Is(err, calendar.ErrNotAuthorized) {
+		s.log.Debug("calendar is not authorized yet, skipping sync")
+		return
+	}
+	if err != nil {
+		s.log.Error("error listing calendar events", "error", err)
+		return
+	}
+
+	eventIDs := make([]string, 0, len(events))
+	for _, event := range events {
+		eventIDs = append(eventIDs, event.ID)
+	}
+	appointments, err := s.handler.service.GetAppointmentsByCalendarEventIDs(eventIDs)
+	if err != nil {
+		s.log.Error("error getting appointments for calendar events", "error", err)
+		return
+	}
+	byEventID := make(map[string]common.Appointment, len(appointments))
+	for _, appointment := range appointments {
+		if appointment.CalendarEventID != "" {
+			byEventID[appointment.CalendarEventID] = appointment
+		}
+		// Очередь создаёт событие под этим ID и сохраняет его в записи позже
+		byEventID[calendar.AppointmentEventID(appointment.UUID)] = appointment
+	}
+
+	// ownEvents события бота, найденные в выборке
+	ownEvents := make(map[string]common.Appointment)
+	var blocks []common.BlockedRange
+	for _, event := range events {
+		if appointment, ok := byEventID[event.ID]; ok {
+			ownEvents[event.ID] = appointment
+			if appointment.Status == "scheduled" {
+				s.applyEventTime(appointment, event)
+			}
+			continue
+		}
+		if event.Free {
+			continue
+		}
+		blocks = append(blocks, common.BlockedRange{
+			StartTime:  event.Start,
+			EndTime:    event.End,
+			Reason:     "📅 " + event.Summary,
+			ExternalID: event.ID,
+		})
+	}
+
+	if err := s.handler.service.ReplaceCalendarBlocks(from, to, blocks); err != nil {
+		s.log.Error("error saving calendar blocks", "error", err)
+		return
+	}
+
+	if !s.checkMissingEvents(ctx, from, to, ownEvents) {
+		return
+	}
+	// Предупреждения о том, что уже разрешилось, забываются
+	s.alerts = s.passAlerts
+	s.log.Debug("calendar synced", "events", len(events), "blocks", len(blocks))
+}
+
+// checkMissingEvents ищет записи, чьих событий не оказалось в выборке. Событие
+// могли удалить или перенести за горизонт, поэтому каждое проверяется отдельно.
+// Возвращает false, если проверку не удалось провести.
+func (s *CalendarSync) checkMissingEvents(ctx context.Context, from, to time.Time, ownEvents map[string]common.Appointment) bool {
+	appointments, err := s.handler.service.GetScheduledAppointmentsWithEvents(from, to)
+	if err != nil {
+		s.log.Error("error getting appointments with calendar events", "error", err)
+		return false
+	}
+
+	var missing []common.Appointment
+	for _, appointment := range appointments {
+		if _, ok := ownEvents[appointment.CalendarEventID]; ok {
+			continue
+		}
+
+		event, err := s.handler.calendar.GetEvent(ctx, appointment.CalendarEventID)
+		if errors.Is(err, calendar.ErrEventNotFound) {
+			missing = append(missing, appointment)
+			continue
+		}
+		if err != nil {
+			s.log.Error("error getting calendar event", "appointment_id", appointment.UUID, "error", err)
+			continue
+		}
+		s.applyEventTime(appointment, event)
+	}
+
+	if len(missing) > 0 {
+		s.cancelMissing(ctx, missing, len(ownEvents))
+	}
+	return true
+}
+
+// cancelMissing отменяет записи, события которых удалены из календаря. Ответ от
+// чужого календаря или после отзыва доступа выглядит так же, поэтому записи
+// отменяются, только если календарь доступен, в нём видны другие события бота
+// и удалённых не больше calendarSyncMaxCancellations. Иначе решает мастер.
+func (s *CalendarSync) cancelMissing(ctx context.Context, missing []common.Appointment, visible int) {
+	if err := s.handler.calendar.Ping(ctx); err != nil {
+		s.log.Error("calendar is unavailable, not cancelling appointments", "missing", len(missing), "error", err)
+		return
+	}
+
+	if visible == 0 || len(missing) > calendarSyncMaxCancellations {
+		ids := make([]string, 0, len(missing))
+		for _, appointment := range missing {
+			ids = append(ids, appointment.UUID.String())
+		}
+		sort.Strings(ids)
+
+		text := fmt.Sprintf("⚠️ В календаре не найдены события записей: %d. "+
+			"Если мастер удалил их сам, отмените записи вручную. Иначе проверьте, "+
+			"что бот подключён к нужному календарю.", len(missing))
+		if s.alertOnce("missing:"+strings.Join(ids, ","), text, missing) {
+			s.log.Warn("appointment events are missing, waiting for the master to confirm",
+				"missing", len(missing), "visible", visible)
+		}
+		return
+	}
+
+	for _, appointment := range missing {
+		s.cancelAppointment(appointment)
+	}
+}
+
+// alertOnce предупреждает мастеров, если предупреждение key ещё не отправлялось,
+// и прикладывает кнопки записей. Возвращает true, если сообщение отправлено.
+func (s *CalendarSync) alertOnce(key string, text string, appointments []common.Appointment) bool {
+	s.passAlerts[key] = true
+	if s.alerts[key] {
+		return false
+	}
+	s.alerts[key] = true
+
+	s.handler.notifyAdmins(text, func(chatID int64) tgbotapi.InlineKeyboardMarkup {
+		var keyboard [][]tgbotapi.InlineKeyboardButton
+		for i, appointment := range appointments {
+			if i == calendarAlertButtons {
+				break
+			}
+			label := fmt.Sprintf("%s %s", appointment.StartTime.In(s.handler.location).Format("02.01 15:04"), appointment.Name)
+			keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(
+				s.handler.button(chatID, label, "admin_appointment", appointment.UUID.String()),
+			))
+		}
+		return inlineKeyboard(keyboard...)
+	})
+	return true
+}
+
+// applyEventTime переносит запись, если мастер передвинул её событие в календаре
+func (s *CalendarSync) applyEventTime(appointment common.Appointment, event calendar.Event) {
+	start, end := event.Start, event.End
+	if start.Equal(appointment.StartTime) && end.Equal(appointment.EndTime) {
+		return
+	}
+
+	logger := s.log.With("appointment_id", appointment.UUID)
+	moved, client, err := s.handler.service.MoveAppointmentByMaster(appointment.UUID, start, end)
+	if errors.Is(err, common.ErrSlotTaken) {
+		key := fmt.Sprintf("slot_taken:%s:%d", appointment.UUID, start.Unix())
+		text := fmt.Sprintf("⚠️ Событие записи на %s перенесено в календаре на %s, но это время занято "+
+			"другой записью. Запись осталась на прежнем времени, верните событие или перенесите запись.",
+			appointment.StartTime.In(s.handler.location).Format("02.01.2006 15:04"),
+			start.In(s.handler.location).Format("02.01.2006 15:04"))
+		if s.alertOnce(key, text, []common.Appointment{appointment}) {
+			logger.Warn("calendar event moved onto another appointment, keeping the old time", "start", start)
+		}
+		return
+	}
+	if err != nil {
+		logger.Error("error moving appointment from calendar", "error", err)
+		return
+	}
+
+	s.handler.CancelNotification(moved.UUID.String())
+	if client != nil {
+		s.handler.scheduleAppointmentReminders(client.TelegramID, moved)
+	}
+	s.handler.notifyClient(client, helper.FormatText("appointment_moved_by_master", newAppointmentTemplateData(moved)))
+	logger.Info("appointment moved from calendar", "start", moved.StartTime)
+}
+
+// cancelAppointment отменяет запись, событие которой мастер удалил из календаря
+func (s *CalendarSync) cancelAppointment(appointment common.Appointment) {
+	logger := s.log.With("appointment_id", appointment.UUID)
+
+	cancelled, client, err := s.handler.service.CancelAppointmentByMaster(appointment.UUID)
+	if err != nil {
+		logger.Error("error cancelling appointment removed from calendar", "error", err)
+		return
+	}
+	// Событие уже удалено, ссылка на него больше не нужна
+	if err := s.handler.service.SaveCalendarEventID(cancelled.UUID, ""); err != nil {
+		logger.Error("error clearing calendar event ID", "error", err)
+	}
+
+	s.handler.CancelNotification(cancelled.UUID.String())
+	s.handler.notifyClient(client, helper.FormatText("appointment_cancelled_by_master", newAppointmentTemplateData(cancelled)))
+	logger.Info("appointment cancelled from calendar")
+}
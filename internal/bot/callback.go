@@ -0,0 +1,239 @@
+package bot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	callbackVersion   = "1"
+	callbackSeparator = "|"
+	callbackSigLen    = 8
+	// callbackMaxLen ограничение Telegram на callback_data
+	callbackMaxLen = 64
+)
+
+var (
+	ErrCallbackUnknownAction = errors.New("unknown callback action")
+	ErrCallbackMalformed     = errors.New("malformed callback data")
+	ErrCallbackVersion       = errors.New("unsupported callback data version")
+	ErrCallbackSignature     = errors.New("invalid callback signature")
+	ErrCallbackTooLong       = errors.New("callback data exceeds 64 bytes")
+)
+
+type callbackValueKind int
+
+const (
+	callbackValueText callbackValueKind = iota
+	// callbackValueUUID хранится в 16 байтах base64 вместо 36 символов
+	callbackValueUUID
+)
+
+type callbackAction struct {
+	Code string
+	Kind callbackValueKind
+}
+
+const (
+	// callbackUUIDLen длина UUID в base64 без выравнивания
+	callbackUUIDLen = 22
+	// callbackMinTextLen место, которое должно оставаться под текстовое значение (время слота с датой)
+	callbackMinTextLen = len(slotCallbackLayout)
+)
+
+// maxEncodedLen длина callback_data с самым длинным значением, которое
+// действие обязано вмещать
+func (a callbackAction) maxEncodedLen() int {
+	valueLen := callbackMinTextLen
+	if a.Kind == callbackValueUUID {
+		valueLen = callbackUUIDLen
+	}
+	sigLen := base64.RawURLEncoding.EncodedLen(callbackSigLen)
+	return len(callbackVersion) + len(a.Code) + valueLen + sigLen + 3*len(callbackSeparator)
+}
+
+// callbackActions реестр всех действий кнопок. Коды должны быть уникальны
+// и не меняться, иначе кнопки в уже отправленных сообщениях перестанут работать.
+var callbackActions = map[string]callbackAction{
+	"go_home":                  {Code: "h"},
+	"new_appointment":          {Code: "n"},
+	"back_to_appointments":     {Code: "ba"},
+	"back_to_services":         {Code: "bs"},
+	"back_to_dates":            {Code: "bd"},
+	"service":                  {Code: "s", Kind: callbackValueUUID},
+	"services_done":            {Code: "sd"},
+	"date":                     {Code: "d"},
+	"time":                     {Code: "t"},
+	"confirm_booking":          {Code: "cb"},
+	"page":                     {Code: "p"},
+	"appointment":              {Code: "a", Kind: callbackValueUUID},
+	"cancel":                   {Code: "c", Kind: callbackValueUUID},
+	"confirm_visit":            {Code: "cv", Kind: callbackValueUUID},
+	"reschedule":               {Code: "r", Kind: callbackValueUUID},
+	"reschedule_date":          {Code: "rd"},
+	"reschedule_time":          {Code: "rt"},
+	"back_to_reschedule_dates": {Code: "rb"},
+	"admin_schedule":           {Code: "as"},
+	"admin_appointment":        {Code: "aa", Kind: callbackValueUUID},
+	"admin_complete":           {Code: "ac", Kind: callbackValueUUID},
+	"admin_no_show":            {Code: "an", Kind: callbackValueUUID},
+	"admin_cancel":             {Code: "ax", Kind: callbackValueUUID},
+	"svc":                      {Code: "v", Kind: callbackValueUUID},
+	"svc_list":                 {Code: "vl"},
+	"svc_add":                  {Code: "va"},
+	"svc_name":                 {Code: "vn", Kind: callbackValueUUID},
+	"svc_duration":             {Code: "vd", Kind: callbackValueUUID},
+	"svc_price":                {Code: "vp", Kind: callbackValueUUID},
+	"svc_toggle":               {Code: "vt", Kind: callbackValueUUID},
+	"svc_up":                   {Code: "vu", Kind: callbackValueUUID},
+	"svc_down":                 {Code: "vw", Kind: callbackValueUUID},
+	"wh_day":                   {Code: "w"},
+	"exc_add":                  {Code: "ea"},
+	"exc_del":                  {Code: "ed", Kind: callbackValueUUID},
+	"brk_add":                  {Code: "ka"},
+	"brk_day":                  {Code: "kd"},
+	"brk_del":                  {Code: "kx", Kind: callbackValueUUID},
+	"blk_add":                  {Code: "la"},
+	"blk_del":                  {Code: "lx", Kind: callbackValueUUID},
+	"cal_retry":                {Code: "qr", Kind: callbackValueUUID},
+}
+
+// CallbackCodec упаковывает действие кнопки в callback_data вида
+// "<версия>|<код>|<значение>|<подпись>". Подпись HMAC включает Telegram ID
+// получателя, поэтому кнопку нельзя подделать или переслать другому пользователю.
+type CallbackCodec struct {
+	secret  []byte
+	actions map[string]callbackAction
+	byCode  map[string]string
+}
+
+func NewCallbackCodec(secret string) *CallbackCodec {
+	byCode := make(map[string]string, len(callbackActions))
+	for name, action := range callbackActions {
+		if other, ok := byCode[action.Code]; ok {
+			panic(fmt.Sprintf("callback code %q is used by both %s and %s", action.Code, other, name))
+		}
+		if size := action.maxEncodedLen(); size > callbackMaxLen {
+			panic(fmt.Sprintf("callback action %s takes %d bytes, limit is %d", name, size, callbackMaxLen))
+		}
+		byCode[action.Code] = name
+	}
+
+	return &CallbackCodec{
+		secret:  []byte(secret),
+		actions: callbackActions,
+		byCode:  byCode,
+	}
+}
+
+func (c *CallbackCodec) Encode(userID int64, action string, value string) (string, error) {
+	spec, ok := c.actions[action]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrCallbackUnknownAction, action)
+	}
+
+	if spec.Kind == callbackValueUUID {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s expects UUID, got %q", ErrCallbackMalformed, action, value)
+		}
+		value = base64.RawURLEncoding.EncodeToString(id[:])
+	} else if strings.Contains(value, callbackSeparator) {
+		return "", fmt.Errorf("%w: value contains %q", ErrCallbackMalformed, callbackSeparator)
+	}
+
+	payload := callbackVersion + callbackSeparator + spec.Code + callbackSeparator + value
+	data := payload + callbackSeparator + c.sign(userID, payload)
+	if len(data) > callbackMaxLen {
+		return "", fmt.Errorf("%w: %s", ErrCallbackTooLong, action)
+	}
+	return data, nil
+}
+
+// Decode проверяет версию и подпись и возвращает действие со значением.
+func (c *CallbackCodec) Decode(userID int64, data string) (string, string, error) {
+	parts := strings.Split(data, callbackSeparator)
+	if len(parts) != 4 {
+		return "", "", ErrCallbackMalformed
+	}
+	if parts[0] != callbackVersion {
+		return "", "", fmt.Errorf("%w: %q", ErrCallbackVersion, parts[0])
+	}
+
+	payload := strings.Join(parts[:3], callbackSeparator)
+	if !hmac.Equal([]byte(parts[3]), []byte(c.sign(userID, payload))) {
+		return "", "", ErrCallbackSignature
+	}
+
+	action, ok := c.byCode[parts[1]]
+	if !ok {
+		return "", "", fmt.Errorf("%w: code %q", ErrCallbackUnknownAction, parts[1])
+	}
+
+	value := parts[2]
+	if c.actions[action].Kind == callbackValueUUID {
+		raw, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: %v", ErrCallbackMalformed, err)
+		}
+		id, err := uuid.FromBytes(raw)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: %v", ErrCallbackMalformed, err)
+		}
+		value = id.String()
+	}
+
+	return action, value, nil
+}
+
+func (c *CallbackCodec) sign(userID int64, payload string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(strconv.FormatInt(userID, 10)))
+	mac.Write([]byte(callbackSeparator))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:callbackSigLen])
+}
+
+// newCallbackButton создаёт кнопку, подписанную для пользователя userID.
+// Если значение не удалось закодировать, кнопка остаётся с пустыми данными:
+// Telegram отклонил бы с ней всё сообщение, поэтому inlineKeyboard её убирает.
+func newCallbackButton(codec *CallbackCodec, userID int64, text string, action string, value string) tgbotapi.InlineKeyboardButton {
+	data, err := codec.Encode(userID, action, value)
+	if err != nil {
+		slog.Error("error encoding callback, button is dropped", "action", action, "error", err)
+	}
+	return tgbotapi.NewInlineKeyboardButtonData(text, data)
+}
+
+// inlineKeyboard собирает клавиатуру без кнопок, которые не удалось закодировать.
+// Опустевшие ряды тоже убираются.
+func inlineKeyboard(rows ...[]tgbotapi.InlineKeyboardButton) tgbotapi.InlineKeyboardMarkup {
+	keyboard := make([][]tgbotapi.InlineKeyboardButton, 0, len(rows))
+	for _, row := range rows {
+		kept := make([]tgbotapi.InlineKeyboardButton, 0, len(row))
+		for _, button := range row {
+			if button.CallbackData != nil && *button.CallbackData == "" {
+				continue
+			}
+			kept = append(kept, button)
+		}
+		if len(kept) > 0 {
+			keyboard = append(keyboard, kept)
+		}
+	}
+	return tgbotapi.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+}
+
+// button кнопка для личного чата, где chatID совпадает с Telegram ID пользователя
+func (h *Handler) button(chatID int64, text string, action string, value string) tgbotapi.InlineKeyboardButton {
+	return newCallbackButton(h.callbacks, chatID, text, action, value)
+}
@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const testCallbackUser int64 = 123456789
+
+func TestCallbackCodecRoundTrip(t *testing.T) {
+	codec := NewCallbackCodec("secret")
+
+	tests := []struct {
+		action string
+		value  string
+	}{
+		{"go_home", ""},
+		{"date", "2024-03-31"},
+		{"time", "09:30"},
+		{"page", "12"},
+		{"appointment", "0b5a3a7e-6c1f-4a4e-9d8e-3f2b1c0d9e8f"},
+		{"cal_retry", "ffffffff-ffff-ffff-ffff-ffffffffffff"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			data, err := codec.Encode(testCallbackUser, tt.action, tt.value)
+			if err != nil {
+				t.Fatalf("Encode error: %v", err)
+			}
+			if len(data) > callbackMaxLen {
+				t.Fatalf("encoded data is %d bytes, limit is %d", len(data), callbackMaxLen)
+			}
+
+			action, value, err := codec.Decode(testCallbackUser, data)
+			if err != nil {
+				t.Fatalf("Decode(%q) error: %v", data, err)
+			}
+			if action != tt.action || value != tt.value {
+				t.Errorf("Decode(%q) = %q, %q, want %q, %q", data, action, value, tt.action, tt.value)
+			}
+		})
+	}
+}
+
+func TestCallbackCodecRejectsTampering(t *testing.T) {
+	codec := NewCallbackCodec("secret")
+	data, err := codec.Encode(testCallbackUser, "date", "2024-03-31")
+	if err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want error
+	}{
+		{"changed value", strings.Replace(data, "2024-03-31", "2024-04-01", 1), ErrCallbackSignature},
+		{"changed action", strings.Replace(data, "|d|", "|t|", 1), ErrCallbackSignature},
+		{"changed signature", data[:len(data)-1] + flipChar(data[len(data)-1]), ErrCallbackSignature},
+		{"unknown version", "2" + data[1:], ErrCallbackVersion},
+		{"missing part", data[:strings.LastIndex(data, callbackSeparator)], ErrCallbackMalformed},
+		{"legacy format", "date_2024-03-31", ErrCallbackMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := codec.Decode(testCallbackUser, tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("Decode(%q) error = %v, want %v", tt.data, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallbackCodecRejectsForeignUser(t *testing.T) {
+	codec := NewCallbackCodec("secret")
+	data, err := codec.Encode(testCallbackUser, "cancel", "0b5a3a7e-6c1f-4a4e-9d8e-3f2b1c0d9e8f")
+	if err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+
+	if _, _, err := codec.Decode(testCallbackUser+1, data); !errors.Is(err, ErrCallbackSignature) {
+		t.Errorf("Decode for another user error = %v, want ErrCallbackSignature", err)
+	}
+	if _, _, err := NewCallbackCodec("other").Decode(testCallbackUser, data); !errors.Is(err, ErrCallbackSignature) {
+		t.Errorf("Decode with another secret error = %v, want ErrCallbackSignature", err)
+	}
+}
+
+func TestCallbackCodecEncodeErrors(t *testing.T) {
+	codec := NewCallbackCodec("secret")
+
+	tests := []struct {
+		name   string
+		action string
+		value  string
+		want   error
+	}{
+		{"unknown action", "no_such_action", "", ErrCallbackUnknownAction},
+		{"bad uuid", "appointment", "not-a-uuid", ErrCallbackMalformed},
+		{"separator in value", "date", "2024|03", ErrCallbackMalformed},
+		{"too long", "date", strings.Repeat("x", callbackMaxLen), ErrCallbackTooLong},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := codec.Encode(testCallbackUser, tt.action, tt.value); !errors.Is(err, tt.want) {
+				t.Errorf("Encode(%q, %q) error = %v, want %v", tt.action, tt.value, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallbackActionsFitLimit(t *testing.T) {
+	for name, action := range callbackActions {
+		if size := action.maxEncodedLen(); size > callbackMaxLen {
+			t.Errorf("action %s takes %d bytes, limit is %d", name, size, callbackMaxLen)
+		}
+	}
+
+	oversized := callbackAction{Code: strings.Repeat("x", callbackMaxLen)}
+	if size := oversized.maxEncodedLen(); size <= callbackMaxLen {
+		t.Errorf("maxEncodedLen() = %d for a %d-byte code, want more than %d", size, len(oversized.Code), callbackMaxLen)
+	}
+}
+
+func TestInlineKeyboardDropsBrokenButtons(t *testing.T) {
+	codec := NewCallbackCodec("secret")
+	home := newCallbackButton(codec, testCallbackUser, "home", "go_home", "")
+	broken := newCallbackButton(codec, testCallbackUser, "broken", "no_such_action", "")
+	link := tgbotapi.NewInlineKeyboardButtonURL("map", "https://example.com")
+
+	markup := inlineKeyboard(
+		[]tgbotapi.InlineKeyboardButton{home, broken},
+		[]tgbotapi.InlineKeyboardButton{broken},
+		[]tgbotapi.InlineKeyboardButton{link},
+	)
+
+	if len(markup.InlineKeyboard) != 2 {
+		t.Fatalf("got %d rows, want 2", len(markup.InlineKeyboard))
+	}
+	if row := markup.InlineKeyboard[0]; len(row) != 1 || row[0].Text != "home" {
+		t.Errorf("first row = %+v, want only the home button", row)
+	}
+	if row := markup.InlineKeyboard[1]; len(row) != 1 || row[0].Text != "map" {
+		t.Errorf("second row = %+v, want the URL button", row)
+	}
+}
+
+func flipChar(c byte) string {
+	if c == 'A' {
+		return "B"
+	}
+	return "A"
+}
@@ -0,0 +1,314 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/RudinMaxim/BarberBot.git/config"
+	"github.com/RudinMaxim/BarberBot.git/database"
+	"github.com/RudinMaxim/BarberBot.git/helper"
+)
+
+const (
+	conversationBooking = "booking"
+	conversationAdmin   = "admin"
+)
+
+var ErrConversationNotFound = errors.New("conversation state not found")
+
+// ConversationStore хранит незавершённые диалоги пользователей. Каждое
+// сохранение продлевает TTL, брошенные диалоги удаляются сами.
+type ConversationStore interface {
+	Load(ctx context.Context, userID int64, kind string, dest interface{}) error
+	Save(ctx context.Context, userID int64, kind string, value interface{}) error
+	Delete(ctx context.Context, userID int64, kind string) error
+}
+
+func conversationKey(userID int64, kind string) string {
+	return fmt.Sprintf("conversation:%d:%s", userID, kind)
+}
+
+// ================Redis==================
+
+// RedisConversationStore хранит диалоги в Redis. cache должен возвращать ошибки
+// записи (database.BreakerCache, а не FailOpenCache), иначе диалог не попадёт
+// в запасное хранилище.
+type RedisConversationStore struct {
+	cache database.Cache
+	ttl   time.Duration
+}
+
+func NewRedisConversationStore(cache database.Cache, ttl time.Duration) *RedisConversationStore {
+	return &RedisConversationStore{cache: cache, ttl: ttl}
+}
+
+func (s *RedisConversationStore) Load(ctx context.Context, userID int64, kind string, dest interface{}) error {
+	err := s.cache.Get(ctx, conversationKey(userID, kind), dest)
+	if errors.Is(err, database.ErrCacheMiss) {
+		return ErrConversationNotFound
+	}
+	return err
+}
+
+func (s *RedisConversationStore) Save(ctx context.Context, userID int64, kind string, value interface{}) error {
+	return s.cache.Set(ctx, conversationKey(userID, kind), value, s.ttl)
+}
+
+func (s *RedisConversationStore) Delete(ctx context.Context, userID int64, kind string) error {
+	return s.cache.Delete(ctx, conversationKey(userID, kind))
+}
+
+// ================Memory==================
+
+// memorySweepInterval как часто Save вычищает истёкшие диалоги
+const memorySweepInterval = time.Minute
+
+// MemoryConversationStore хранит диалоги в памяти процесса. Значения
+// сериализуются в JSON, как и в Redis, чтобы поведение не отличалось.
+// Брошенные диалоги удаляются при записи, не чаще раза в memorySweepInterval.
+type MemoryConversationStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	items     map[string]memoryConversation
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+type memoryConversation struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+func NewMemoryConversationStore(ttl time.Duration) *MemoryConversationStore {
+	return &MemoryConversationStore{
+		ttl:   ttl,
+		items: make(map[string]memoryConversation),
+		now:   time.Now,
+	}
+}
+
+func (s *MemoryConversationStore) Load(ctx context.Context, userID int64, kind string, dest interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := conversationKey(userID, kind)
+	item, ok := s.items[key]
+	if !ok {
+		return ErrConversationNotFound
+	}
+	if !s.now().Before(item.expiresAt) {
+		delete(s.items, key)
+		return ErrConversationNotFound
+	}
+	return json.Unmarshal(item.data, dest)
+}
+
+func (s *MemoryConversationStore) Save(ctx context.Context, userID int64, kind string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal conversation: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+	s.items[conversationKey(userID, kind)] = memoryConversation{
+		data:      data,
+		expiresAt: now.Add(s.ttl),
+	}
+	return nil
+}
+
+// sweep удаляет истёкшие диалоги. Вызывается под s.mu.
+func (s *MemoryConversationStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < memorySweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, item := range s.items {
+		if !now.Before(item.expiresAt) {
+			delete(s.items, key)
+		}
+	}
+}
+
+func (s *MemoryConversationStore) Delete(ctx context.Context, userID int64, kind string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.items, conversationKey(userID, kind))
+	return nil
+}
+
+// ================Fallback==================
+
+// FallbackConversationStore пишет в основное хранилище, а пока оно недоступно,
+// в запасное, чтобы начатые диалоги не обрывались при сбое Redis. Копия в основном
+// хранилище, которую не удалось обновить или удалить, помечается устаревшей: пока
+// метка стоит, верна запасная копия (или её отсутствие), и при первом чтении после
+// восстановления она переносится в основное хранилище.
+type FallbackConversationStore struct {
+	primary  ConversationStore
+	fallback ConversationStore
+
+	mu    sync.Mutex
+	stale map[string]bool
+}
+
+func NewFallbackConversationStore(primary ConversationStore, fallback ConversationStore) *FallbackConversationStore {
+	return &FallbackConversationStore{
+		primary:  primary,
+		fallback: fallback,
+		stale:    make(map[string]bool),
+	}
+}
+
+func (s *FallbackConversationStore) Load(ctx context.Context, userID int64, kind string, dest interface{}) error {
+	if s.isStale(userID, kind) {
+		return s.loadStale(ctx, userID, kind, dest)
+	}
+
+	err := s.primary.Load(ctx, userID, kind, dest)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ErrConversationNotFound) {
+		config.LoggerFrom(ctx).Warn("conversation store unavailable, reading fallback", "user_id", userID, "error", err)
+	}
+	return s.fallback.Load(ctx, userID, kind, dest)
+}
+
+// loadStale читает запасную копию и переносит её или её удаление в основное хранилище.
+// Пока основное недоступно, метка остаётся.
+func (s *FallbackConversationStore) loadStale(ctx context.Context, userID int64, kind string, dest interface{}) error {
+	var data json.RawMessage
+	err := s.fallback.Load(ctx, userID, kind, &data)
+	if errors.Is(err, ErrConversationNotFound) {
+		if err := s.primary.Delete(ctx, userID, kind); err == nil {
+			s.setStale(userID, kind, false)
+		}
+		return ErrConversationNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.primary.Save(ctx, userID, kind, data); err == nil {
+		s.setStale(userID, kind, false)
+		if err := s.fallback.Delete(ctx, userID, kind); err != nil {
+			config.LoggerFrom(ctx).Warn("failed to delete fallback conversation", "user_id", userID, "error", err)
+		}
+	}
+	return json.Unmarshal(data, dest)
+}
+
+func (s *FallbackConversationStore) Save(ctx context.Context, userID int64, kind string, value interface{}) error {
+	if err := s.primary.Save(ctx, userID, kind, value); err != nil {
+		config.LoggerFrom(ctx).Warn("conversation store unavailable, saving to fallback", "user_id", userID, "error", err)
+		if err := s.fallback.Save(ctx, userID, kind, value); err != nil {
+			return err
+		}
+		s.setStale(userID, kind, true)
+		return nil
+	}
+	s.setStale(userID, kind, false)
+	// Копия, сохранённая во время сбоя, больше не нужна
+	return s.fallback.Delete(ctx, userID, kind)
+}
+
+func (s *FallbackConversationStore) Delete(ctx context.Context, userID int64, kind string) error {
+	if err := s.fallback.Delete(ctx, userID, kind); err != nil {
+		return err
+	}
+	if err := s.primary.Delete(ctx, userID, kind); err != nil {
+		// Иначе после восстановления Redis вернул бы завершённый диалог
+		config.LoggerFrom(ctx).Warn("conversation store unavailable, delete is postponed", "user_id", userID, "error", err)
+		s.setStale(userID, kind, true)
+		return nil
+	}
+	s.setStale(userID, kind, false)
+	return nil
+}
+
+func (s *FallbackConversationStore) isStale(userID int64, kind string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stale[conversationKey(userID, kind)]
+}
+
+func (s *FallbackConversationStore) setStale(userID int64, kind string, stale bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stale {
+		s.stale[conversationKey(userID, kind)] = true
+	} else {
+		delete(s.stale, conversationKey(userID, kind))
+	}
+}
+
+// ================Handler helpers==================
+
+func (h *Handler) loadBookingState(userID int64) *BookingState {
+	var state BookingState
+	if err := h.conversations.Load(h.requestContext(), userID, conversationBooking, &state); err != nil {
+		if !errors.Is(err, ErrConversationNotFound) {
+			h.log.Error("error loading booking state", "error", err)
+		}
+		return nil
+	}
+	return &state
+}
+
+func (h *Handler) saveBookingState(userID int64, state *BookingState) {
+	if err := h.conversations.Save(h.requestContext(), userID, conversationBooking, state); err != nil {
+		h.log.Error("error saving booking state", "error", err)
+	}
+}
+
+func (h *Handler) clearBookingState(userID int64) {
+	if err := h.conversations.Delete(h.requestContext(), userID, conversationBooking); err != nil {
+		h.log.Error("error deleting booking state", "error", err)
+	}
+}
+
+// bookingStateOrRestart возвращает состояние записи. Если оно истекло или
+// потерялось при перезапуске, сообщает об этом и начинает запись заново.
+func (h *Handler) bookingStateOrRestart(chatID int64, userID int64) (*BookingState, bool) {
+	if state := h.loadBookingState(userID); state != nil {
+		return state, true
+	}
+
+	h.sendMessage(chatID, helper.GetText("session_expired"))
+	h.startBooking(chatID, userID)
+	return nil, false
+}
+
+func (h *Handler) loadAdminState(userID int64) *AdminState {
+	var state AdminState
+	if err := h.conversations.Load(h.requestContext(), userID, conversationAdmin, &state); err != nil {
+		if !errors.Is(err, ErrConversationNotFound) {
+			h.log.Error("error loading admin state", "error", err)
+		}
+		return nil
+	}
+	return &state
+}
+
+func (h *Handler) saveAdminState(userID int64, state *AdminState) {
+	if err := h.conversations.Save(h.requestContext(), userID, conversationAdmin, state); err != nil {
+		h.log.Error("error saving admin state", "error", err)
+	}
+}
+
+func (h *Handler) clearAdminState(userID int64) {
+	if err := h.conversations.Delete(h.requestContext(), userID, conversationAdmin); err != nil {
+		h.log.Error("error deleting admin state", "error", err)
+	}
+}
@@ -0,0 +1,202 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type testConversation struct {
+	Step int
+}
+
+// testClock часы MemoryConversationStore, которые двигает тест
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func newTestMemoryStore(ttl time.Duration) (*MemoryConversationStore, *testClock) {
+	clock := &testClock{now: time.Date(2024, 3, 31, 10, 0, 0, 0, time.UTC)}
+	store := NewMemoryConversationStore(ttl)
+	store.now = clock.Now
+	return store, clock
+}
+
+func TestMemoryConversationStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	store, clock := newTestMemoryStore(10 * time.Minute)
+
+	if err := store.Save(ctx, 1, conversationBooking, testConversation{Step: 2}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		wantErr error
+	}{
+		{"fresh", 0, nil},
+		{"just before ttl", 10*time.Minute - time.Second, nil},
+		{"at ttl", time.Second, ErrConversationNotFound},
+		{"after ttl", time.Hour, ErrConversationNotFound},
+	}
+	for _, tt := range tests {
+		clock.now = clock.now.Add(tt.advance)
+		var got testConversation
+		err := store.Load(ctx, 1, conversationBooking, &got)
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%s: Load error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil && got.Step != 2 {
+			t.Errorf("%s: Load = %+v, want step 2", tt.name, got)
+		}
+	}
+}
+
+func TestMemoryConversationStoreSaveExtendsTTL(t *testing.T) {
+	ctx := context.Background()
+	store, clock := newTestMemoryStore(10 * time.Minute)
+
+	store.Save(ctx, 1, conversationBooking, testConversation{Step: 1})
+	clock.now = clock.now.Add(8 * time.Minute)
+	store.Save(ctx, 1, conversationBooking, testConversation{Step: 2})
+	clock.now = clock.now.Add(8 * time.Minute)
+
+	var got testConversation
+	if err := store.Load(ctx, 1, conversationBooking, &got); err != nil || got.Step != 2 {
+		t.Errorf("Load = %+v, %v, want step 2 kept alive by the second Save", got, err)
+	}
+}
+
+func TestMemoryConversationStoreSweep(t *testing.T) {
+	ctx := context.Background()
+	store, clock := newTestMemoryStore(10 * time.Second)
+
+	// Первый Save вычищает сразу, следующая чистка не раньше чем через memorySweepInterval
+	store.Save(ctx, 1, conversationBooking, testConversation{})
+	store.Save(ctx, 2, conversationAdmin, testConversation{})
+
+	clock.now = clock.now.Add(2 * time.Minute)
+	store.Save(ctx, 3, conversationBooking, testConversation{})
+	if len(store.items) != 1 {
+		t.Fatalf("after sweep %d conversations left, want only the new one", len(store.items))
+	}
+
+	clock.now = clock.now.Add(memorySweepInterval + time.Minute)
+	store.Save(ctx, 4, conversationBooking, testConversation{})
+	clock.now = clock.now.Add(memorySweepInterval / 2)
+	store.Save(ctx, 5, conversationBooking, testConversation{})
+	// Диалог 4 уже истёк, но чистка ещё не положена
+	if len(store.items) != 2 {
+		t.Errorf("sweep ran too early: %d conversations left, want 2", len(store.items))
+	}
+}
+
+func TestMemoryConversationStoreDelete(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestMemoryStore(time.Minute)
+
+	if err := store.Delete(ctx, 1, conversationBooking); err != nil {
+		t.Errorf("Delete of a missing conversation: %v", err)
+	}
+
+	store.Save(ctx, 1, conversationBooking, testConversation{Step: 1})
+	store.Save(ctx, 1, conversationAdmin, testConversation{Step: 2})
+	if err := store.Delete(ctx, 1, conversationBooking); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	var got testConversation
+	if err := store.Load(ctx, 1, conversationBooking, &got); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("Load after Delete = %v, want ErrConversationNotFound", err)
+	}
+	if err := store.Load(ctx, 1, conversationAdmin, &got); err != nil || got.Step != 2 {
+		t.Errorf("Delete removed another kind: %+v, %v", got, err)
+	}
+}
+
+// flakyConversationStore хранилище, которое тест может «уронить», как Redis
+type flakyConversationStore struct {
+	*MemoryConversationStore
+	down bool
+}
+
+var errStoreDown = errors.New("connection refused")
+
+func (s *flakyConversationStore) Load(ctx context.Context, userID int64, kind string, dest interface{}) error {
+	if s.down {
+		return errStoreDown
+	}
+	return s.MemoryConversationStore.Load(ctx, userID, kind, dest)
+}
+
+func (s *flakyConversationStore) Save(ctx context.Context, userID int64, kind string, value interface{}) error {
+	if s.down {
+		return errStoreDown
+	}
+	return s.MemoryConversationStore.Save(ctx, userID, kind, value)
+}
+
+func (s *flakyConversationStore) Delete(ctx context.Context, userID int64, kind string) error {
+	if s.down {
+		return errStoreDown
+	}
+	return s.MemoryConversationStore.Delete(ctx, userID, kind)
+}
+
+func TestFallbackConversationStoreDeleteDuringOutage(t *testing.T) {
+	ctx := context.Background()
+	primary := &flakyConversationStore{MemoryConversationStore: NewMemoryConversationStore(time.Hour)}
+	store := NewFallbackConversationStore(primary, NewMemoryConversationStore(time.Hour))
+
+	if err := store.Save(ctx, 1, conversationBooking, testConversation{Step: 3}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	primary.down = true
+	if err := store.Delete(ctx, 1, conversationBooking); err != nil {
+		t.Fatalf("Delete during outage: %v", err)
+	}
+	var got testConversation
+	if err := store.Load(ctx, 1, conversationBooking, &got); !errors.Is(err, ErrConversationNotFound) {
+		t.Fatalf("Load during outage = %+v, %v, want ErrConversationNotFound", got, err)
+	}
+
+	// Redis вернулся со старой копией диалога
+	primary.down = false
+	if err := store.Load(ctx, 1, conversationBooking, &got); !errors.Is(err, ErrConversationNotFound) {
+		t.Fatalf("Load after recovery = %+v, %v, want the finished dialogue to stay deleted", got, err)
+	}
+	if err := primary.Load(ctx, 1, conversationBooking, &got); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("primary still has %+v after recovery, want the delete replayed", got)
+	}
+}
+
+func TestFallbackConversationStoreSaveDuringOutage(t *testing.T) {
+	ctx := context.Background()
+	primary := &flakyConversationStore{MemoryConversationStore: NewMemoryConversationStore(time.Hour)}
+	fallback := NewMemoryConversationStore(time.Hour)
+	store := NewFallbackConversationStore(primary, fallback)
+
+	store.Save(ctx, 1, conversationBooking, testConversation{Step: 1})
+
+	primary.down = true
+	if err := store.Save(ctx, 1, conversationBooking, testConversation{Step: 2}); err != nil {
+		t.Fatalf("Save during outage: %v", err)
+	}
+
+	primary.down = false
+	var got testConversation
+	if err := store.Load(ctx, 1, conversationBooking, &got); err != nil || got.Step != 2 {
+		t.Fatalf("Load after recovery = %+v, %v, want step 2 saved during the outage", got, err)
+	}
+	if err := primary.Load(ctx, 1, conversationBooking, &got); err != nil || got.Step != 2 {
+		t.Errorf("primary has %+v, %v after recovery, want step 2 replayed", got, err)
+	}
+	if err := fallback.Load(ctx, 1, conversationBooking, &got); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("fallback copy left after replay: %v", err)
+	}
+}
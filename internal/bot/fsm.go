@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrUnexpectedAction = errors.New("action is not allowed in the current step")
+
+// StateMachine описывает диалог декларативно: для каждого шага перечислены
+// допустимые callback-действия и шаг, в который они переводят.
+type StateMachine struct {
+	transitions map[int]map[string]int
+	actions     map[string]bool
+}
+
+func NewStateMachine(transitions map[int]map[string]int) *StateMachine {
+	actions := make(map[string]bool)
+	for _, byAction := range transitions {
+		for action := range byAction {
+			actions[action] = true
+		}
+	}
+	return &StateMachine{transitions: transitions, actions: actions}
+}
+
+// Handles сообщает, управляет ли машина этим действием. Остальные действия
+// (админские, просмотр записей) шагов диалога не касаются.
+func (m *StateMachine) Handles(action string) bool {
+	return m.actions[action]
+}
+
+// Next возвращает шаг, в который переводит действие, или ErrUnexpectedAction,
+// если кнопка пришла из устаревшего сообщения.
+func (m *StateMachine) Next(step int, action string) (int, error) {
+	next, ok := m.transitions[step][action]
+	if !ok {
+		return step, fmt.Errorf("%w: %q at step %d", ErrUnexpectedAction, action, step)
+	}
+	return next, nil
+}
+
+// slotCallbackLayout значение кнопки времени. Дата в нём отличает кнопки клавиатуры
+// выбранной даты от кнопок, оставшихся в чате от прежней.
+const slotCallbackLayout = "2006-01-02T15:04"
+
+// nextBookingStep возвращает шаг, в который действие переводит диалог, и значение
+// кнопки. Кнопка времени принимается, только если она с клавиатуры выбранной даты,
+// и её значение заменяется временем слота 15:04.
+func nextBookingStep(state *BookingState, action, value string, location *time.Location) (int, string, error) {
+	next, err := bookingMachine.Next(state.Step, action)
+	if err != nil {
+		return state.Step, value, err
+	}
+	if action != "time" && action != "reschedule_time" {
+		return next, value, nil
+	}
+
+	date := state.Date.In(location).Format("2006-01-02")
+	slot, err := time.Parse(slotCallbackLayout, value)
+	if err != nil || slot.Format("2006-01-02") != date {
+		return state.Step, value, fmt.Errorf("%w: %q is not a slot of %s", ErrUnexpectedAction, value, date)
+	}
+	return next, slot.Format("15:04"), nil
+}
+
+// bookingMachine диалог записи и переноса записи. Дату можно перевыбрать и на
+// шаге выбора времени: сообщение с датами остаётся в чате выше.
+var bookingMachine = NewStateMachine(map[int]map[string]int{
+	stepSelectService: {
+		"service":       stepSelectService,
+		"services_done": stepSelectDate,
+	},
+	stepSelectDate: {
+		"date":             stepSelectTime,
+		"back_to_services": stepSelectService,
+	},
+	stepSelectTime: {
+		"time":          stepConfirmBooking,
+		"date":          stepSelectTime,
+		"back_to_dates": stepSelectDate,
+	},
+	stepConfirmBooking: {
+		"confirm_booking": stepCompleted,
+	},
+	stepRescheduleDate: {
+		"reschedule_date": stepRescheduleTime,
+	},
+	stepRescheduleTime: {
+		"reschedule_time":          stepCompleted,
+		"reschedule_date":          stepRescheduleTime,
+		"back_to_reschedule_dates": stepRescheduleDate,
+	},
+})
@@ -0,0 +1,150 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBookingMachineTransitions(t *testing.T) {
+	tests := []struct {
+		name   string
+		step   int
+		action string
+		want   int
+	}{
+		{"service toggled", stepSelectService, "service", stepSelectService},
+		{"services chosen", stepSelectService, "services_done", stepSelectDate},
+		{"date chosen", stepSelectDate, "date", stepSelectTime},
+		{"back to services", stepSelectDate, "back_to_services", stepSelectService},
+		{"time chosen", stepSelectTime, "time", stepConfirmBooking},
+		{"date reselected", stepSelectTime, "date", stepSelectTime},
+		{"back to dates", stepSelectTime, "back_to_dates", stepSelectDate},
+		{"booking confirmed", stepConfirmBooking, "confirm_booking", stepCompleted},
+		{"reschedule date chosen", stepRescheduleDate, "reschedule_date", stepRescheduleTime},
+		{"reschedule time chosen", stepRescheduleTime, "reschedule_time", stepCompleted},
+		{"reschedule date reselected", stepRescheduleTime, "reschedule_date", stepRescheduleTime},
+		{"back to reschedule dates", stepRescheduleTime, "back_to_reschedule_dates", stepRescheduleDate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bookingMachine.Next(tt.step, tt.action)
+			if err != nil {
+				t.Fatalf("Next(%d, %q) error: %v", tt.step, tt.action, err)
+			}
+			if got != tt.want {
+				t.Errorf("Next(%d, %q) = %d, want %d", tt.step, tt.action, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBookingMachineRejectsStaleActions(t *testing.T) {
+	tests := []struct {
+		name   string
+		step   int
+		action string
+	}{
+		{"time before date", stepSelectDate, "time"},
+		{"confirm before time", stepSelectTime, "confirm_booking"},
+		{"service after date", stepSelectTime, "service"},
+		{"time after confirmation shown", stepConfirmBooking, "time"},
+		{"reschedule time before date", stepRescheduleDate, "reschedule_time"},
+		{"booking date while rescheduling", stepRescheduleTime, "date"},
+		{"anything after completion", stepCompleted, "confirm_booking"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bookingMachine.Next(tt.step, tt.action)
+			if !errors.Is(err, ErrUnexpectedAction) {
+				t.Fatalf("Next(%d, %q) error = %v, want ErrUnexpectedAction", tt.step, tt.action, err)
+			}
+			if got != tt.step {
+				t.Errorf("Next(%d, %q) = %d, want unchanged step %d", tt.step, tt.action, got, tt.step)
+			}
+		})
+	}
+}
+
+func TestBookingMachineHandles(t *testing.T) {
+	for _, action := range []string{"date", "reschedule_date", "back_to_reschedule_dates"} {
+		if !bookingMachine.Handles(action) {
+			t.Errorf("Handles(%q) = false, want true", action)
+		}
+	}
+	for _, action := range []string{"go_home", "admin_schedule", "cal_retry"} {
+		if bookingMachine.Handles(action) {
+			t.Errorf("Handles(%q) = true, want false", action)
+		}
+	}
+}
+
+func TestNextBookingStepRejectsTimeFromEarlierDate(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		start      int
+		dateAction string
+		timeAction string
+		done       int
+	}{
+		{"booking", stepSelectDate, "date", "time", stepConfirmBooking},
+		{"reschedule", stepRescheduleDate, "reschedule_date", "reschedule_time", stepCompleted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &BookingState{Step: tt.start}
+			// apply повторяет обработчик: дата запоминается, состояние уходит в хранилище как JSON
+			apply := func(action, value string) (string, error) {
+				next, value, err := nextBookingStep(state, action, value, location)
+				if err != nil {
+					return "", err
+				}
+				if action == tt.dateAction {
+					state.Date, _ = time.ParseInLocation("2006-01-02", value, location)
+				}
+				state.Step = next
+
+				data, _ := json.Marshal(state)
+				state = &BookingState{}
+				if err := json.Unmarshal(data, state); err != nil {
+					t.Fatal(err)
+				}
+				return value, nil
+			}
+
+			if _, err := apply(tt.dateAction, "2024-10-26"); err != nil {
+				t.Fatalf("first date: %v", err)
+			}
+			// Клиент перевыбрал дату, клавиатура первой даты осталась в чате выше
+			if _, err := apply(tt.dateAction, "2024-10-27"); err != nil {
+				t.Fatalf("second date: %v", err)
+			}
+
+			for _, stale := range []string{"2024-10-26T10:00", "10:00", "2024-10-27", "garbage"} {
+				if _, err := apply(tt.timeAction, stale); !errors.Is(err, ErrUnexpectedAction) {
+					t.Errorf("time %q accepted for 2024-10-27, err = %v", stale, err)
+				}
+				if state.Step != stepSelectTime && state.Step != stepRescheduleTime {
+					t.Fatalf("stale time moved the dialogue to step %d", state.Step)
+				}
+			}
+
+			clock, err := apply(tt.timeAction, "2024-10-27T10:00")
+			if err != nil {
+				t.Fatalf("time of the selected date: %v", err)
+			}
+			if clock != "10:00" || state.Step != tt.done {
+				t.Errorf("time of the selected date = %q at step %d, want 10:00 at step %d", clock, state.Step, tt.done)
+			}
+		})
+	}
+}
@@ -1,14 +1,15 @@
 package bot
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"strconv"
-	"strings"
-	"sync"
 	"time"
 
 	"github.com/RudinMaxim/BarberBot.git/common"
+	"github.com/RudinMaxim/BarberBot.git/config"
 	"github.com/RudinMaxim/BarberBot.git/helper"
 	"github.com/RudinMaxim/BarberBot.git/internal/calendar"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -20,6 +21,9 @@ const (
 	stepSelectDate
 	stepSelectTime
 	stepConfirmBooking
+	stepRescheduleDate
+	stepRescheduleTime
+	stepCompleted
 	BUFFER_MINUTES   = 5
 	POSSIBLE_RECORDS = 60
 )
@@ -38,53 +42,108 @@ var commands = []tgbotapi.BotCommand{
 
 type BookingState struct {
 	Step          int
-	ServiceID     string
+	ServiceIDs    []string
 	Date          time.Time
 	Time          string
 	AppointmentID string
 }
 
+// appointmentTemplateData подставляется в шаблоны texts.yaml, описывающие запись
+type appointmentTemplateData struct {
+	Date    string
+	Time    string
+	Service string
+	Price   string
+}
+
+func newAppointmentTemplateData(appointment *common.Appointment) appointmentTemplateData {
+	return appointmentTemplateData{
+		Date:    appointment.StartTime.Format("02.01.2006"),
+		Time:    appointment.StartTime.Format("15:04"),
+		Service: appointment.Name,
+		Price:   fmt.Sprintf("%.2f", appointment.TotalPrice),
+	}
+}
+
+// calendarTimeout ограничивает запросы к календарю, чтобы его сбой не задерживал ответ
+const calendarTimeout = 10 * time.Second
+
 type Handler struct {
-	service         *Service
-	bot             *tgbotapi.BotAPI
-	bookingStates   map[int64]*BookingState
-	calendarService *calendar.GoogleCalendarService
-	notifications   map[string]chan struct{}
-	mu              sync.RWMutex
+	service       *Service
+	bot           *tgbotapi.BotAPI
+	conversations ConversationStore
+	callbacks     *CallbackCodec
+	calendar      calendar.CalendarProvider
+	reminders     []config.Reminder
+	admins        map[int64]bool
+	// location часовой пояс салона, в нём разбираются выбранные даты
+	location *time.Location
+	// log журнал с полями текущего обновления, см. forUpdate
+	log *slog.Logger
 }
 
-func NewHandler(service *Service, bot *tgbotapi.BotAPI) *Handler {
-	calendarService, err := calendar.NewGoogleCalendarService()
-	if err != nil {
-		log.Printf("Error initializing Google Calendar service: %v", err)
+// NewHandler создаёт обработчик. calendarProvider может быть nil, тогда записи
+// не заносятся в календарь мастера.
+func NewHandler(service *Service, bot *tgbotapi.BotAPI, cfg *config.Config, conversations ConversationStore, callbacks *CallbackCodec, calendarProvider calendar.CalendarProvider) *Handler {
+	admins := make(map[int64]bool)
+	for _, id := range cfg.Admins {
+		admins[id] = true
 	}
 
 	return &Handler{
-		service:         service,
-		bot:             bot,
-		bookingStates:   make(map[int64]*BookingState),
-		calendarService: calendarService,
-		notifications:   make(map[string]chan struct{}),
+		service:       service,
+		bot:           bot,
+		conversations: conversations,
+		callbacks:     callbacks,
+		calendar:      calendarProvider,
+		reminders:     cfg.ReminderSchedule(),
+		admins:        admins,
+		location:      cfg.Location(),
+		log:           slog.Default(),
 	}
 }
 
 // ================Common==================
 
-func (h *Handler) HandleUpdate(update tgbotapi.Update) {
-	if update.Message == nil && update.CallbackQuery == nil {
-		return
+// PingCalendar проверяет подключение к календарю для /readyz
+func (h *Handler) PingCalendar(ctx context.Context) error {
+	if h.calendar == nil {
+		return errors.New("calendar is not configured")
 	}
+	return h.calendar.Ping(ctx)
+}
 
+// RegisterCommands публикует меню команд: общее для клиентов и расширенное для мастеров.
+func (h *Handler) RegisterCommands() {
 	resp, err := h.bot.Request(tgbotapi.NewSetMyCommands(commands...))
 	if err != nil {
-		log.Printf("Error setting commands: %v", err)
+		h.log.Error("error setting commands", "error", err)
 		return
 	}
 
 	if resp.Ok {
-		log.Println("Commands were set successfully.")
+		h.log.Info("commands were set successfully")
 	} else {
-		log.Printf("Failed to set commands: %s", resp.Description)
+		h.log.Error("failed to set commands", "description", resp.Description)
+	}
+
+	masterCommands := append(append([]tgbotapi.BotCommand{}, commands...), adminCommands...)
+	for adminID := range h.admins {
+		scope := tgbotapi.NewBotCommandScopeChat(adminID)
+		if _, err := h.bot.Request(tgbotapi.NewSetMyCommandsWithScope(scope, masterCommands...)); err != nil {
+			h.log.Error("error setting admin commands", "admin_id", adminID, "error", err)
+		}
+	}
+}
+
+func (h *Handler) HandleUpdate(update tgbotapi.Update) {
+	if update.Message == nil && update.CallbackQuery == nil {
+		return
+	}
+
+	h = h.forUpdate(update)
+	if update.Message != nil {
+		h.log.Debug("message received", config.LogKeyText, update.Message.Text)
 	}
 
 	if update.Message != nil {
@@ -92,6 +151,13 @@ func (h *Handler) HandleUpdate(update tgbotapi.Update) {
 			h.handleContact(update)
 			return
 		}
+		if update.Message.IsCommand() {
+			// Любая команда прерывает незавершённый диалог мастера
+			h.clearAdminState(update.Message.From.ID)
+		} else if h.hasAdminInput(update.Message.From.ID) {
+			h.handleAdminInput(update.Message)
+			return
+		}
 	} else if update.CallbackQuery != nil {
 		h.handleCallbackQuery(update.CallbackQuery)
 	}
@@ -101,6 +167,27 @@ func (h *Handler) HandleUpdate(update tgbotapi.Update) {
 	}
 }
 
+// forUpdate возвращает копию обработчика, журнал которой помечен идентификатором
+// корреляции и пользователем обновления. Тот же журнал получают сервис и репозиторий.
+// Состояние диалогов хранится вне Handler, поэтому копия безопасна.
+func (h *Handler) forUpdate(update tgbotapi.Update) *Handler {
+	correlationID := uuid.NewString()
+
+	scoped := *h
+	scoped.log = h.log.With(
+		config.LogKeyCorrelationID, correlationID,
+		"update_id", update.UpdateID,
+		"user_id", updateUserID(update),
+	)
+	scoped.service = h.service.forRequest(correlationID, scoped.log)
+	return &scoped
+}
+
+// requestContext контекст с журналом обновления для хранилищ и календаря
+func (h *Handler) requestContext() context.Context {
+	return config.WithLogger(context.Background(), h.log)
+}
+
 func (h *Handler) handleCommand(update tgbotapi.Update) {
 	switch update.Message.Command() {
 	case "start":
@@ -125,24 +212,8 @@ func (h *Handler) handleCommand(update tgbotapi.Update) {
 		h.handleCancel(update)
 	case "reschedule":
 		h.handleReschedule(update)
-	case "test_notify":
-		testID := uuid.New().String()
-		h.ScheduleNotification(
-			testID,
-			update.Message.Chat.ID,
-			"🔔 Тестовое уведомление через 10 секунд!",
-			time.Now().Add(10*time.Second),
-		)
-		h.sendMessage(update.Message.Chat.ID, "Уведомление запланировано! ID: "+testID)
-
-	case "cancel_notify":
-		args := strings.Split(update.Message.Text, " ")
-		if len(args) < 2 {
-			h.sendMessage(update.Message.Chat.ID, "Укажите ID уведомления")
-			return
-		}
-		h.CancelNotification(args[1])
-		h.sendMessage(update.Message.Chat.ID, "Уведомление отменено!")
+	case "today", "tomorrow", "catalog", "hours", "exceptions", "breaks", "blocks", "calendar", "calendar_queue", "test_notify", "cancel_notify":
+		h.handleAdminCommand(update)
 	default:
 		h.handleUnknownCommand(update)
 	}
@@ -151,74 +222,60 @@ func (h *Handler) handleCommand(update tgbotapi.Update) {
 func (h *Handler) handleCallbackQuery(callbackQuery *tgbotapi.CallbackQuery) {
 	chatID := callbackQuery.Message.Chat.ID
 	userID := callbackQuery.From.ID
-	data := callbackQuery.Data
 
-	// Обработка простых действий без параметров
-	switch data {
-	case "confirm_booking":
-		h.handleBookingConfirmation(chatID, userID)
+	action, value, err := h.callbacks.Decode(userID, callbackQuery.Data)
+	if err != nil {
+		h.log.Warn("rejected callback data", "error", err)
+		h.sendMessage(chatID, helper.GetText("stale_action"))
 		return
+	}
+	h.log.Debug("callback query received", "action", action)
+
+	// Шаги записи проверяются машиной состояний, устаревшие кнопки отклоняются
+	if bookingMachine.Handles(action) {
+		h.handleBookingAction(chatID, userID, action, value, callbackQuery.Message.MessageID)
+		return
+	}
+
+	switch action {
 	case "go_home":
 		h.handleHome(tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}, From: &tgbotapi.User{ID: userID}}})
 		return
-	case "back_to_services":
-		h.bookingStates[userID].Step = stepSelectService
-		h.sendServiceSelection(chatID)
-		return
-	case "back_to_dates":
-		h.bookingStates[userID].Step = stepSelectDate
-		h.sendDateSelection(chatID)
-		return
 	case "back_to_appointments":
 		h.handleMyAppointments(tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}, From: &tgbotapi.User{ID: userID}}})
 		return
 	case "new_appointment":
 		h.handleBook(tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}, From: &tgbotapi.User{ID: userID}}})
-		return
-	}
-
-	// Обработка действий с параметрами
-	parts := strings.SplitN(data, ":", 2)
-	if len(parts) != 2 {
-		log.Printf("Invalid callback data format: %s", data)
-		h.sendMessage(chatID, "Произошла ошибка при обработке команды")
-		return
-	}
-
-	action := parts[0]
-	value := parts[1]
-
-	switch action {
-	case "service":
-		h.handleServiceSelection(chatID, userID, value)
-	case "date":
-		h.handleDateSelection(chatID, userID, value)
-	case "time":
-		h.handleTimeSelection(chatID, userID, value)
 	case "appointment":
-		h.handleAppointmentSelection(chatID, value)
+		h.handleAppointmentSelection(chatID, userID, value)
 	case "reschedule":
 		h.handleAppointmentReschedule(chatID, userID, value)
-	case "reschedule_date":
-		h.handleRescheduleDate(chatID, userID, value)
-	case "reschedule_time":
-		h.handleRescheduleTime(chatID, userID, value)
 	case "cancel":
 		h.handleAppointmentCancellation(chatID, userID, value)
+	case "confirm_visit":
+		h.handleVisitConfirmation(chatID, userID, value)
+	case "admin_schedule", "admin_appointment", "admin_complete", "admin_no_show", "admin_cancel":
+		h.handleAdminCallback(chatID, userID, action, value)
+	case "svc", "svc_list", "svc_add", "svc_name", "svc_duration", "svc_price", "svc_toggle", "svc_up", "svc_down":
+		h.handleServiceAdminCallback(chatID, userID, action, value)
+	case "wh_day", "exc_add", "exc_del", "brk_add", "brk_day", "brk_del", "blk_add", "blk_del":
+		h.handleScheduleAdminCallback(chatID, userID, action, value)
+	case "cal_retry":
+		h.handleCalendarRetry(chatID, userID, value)
 	case "page":
 		page, err := strconv.Atoi(value)
 		if err != nil {
-			log.Printf("Error parsing page number: %v", err)
+			h.log.Error("error parsing page number", "error", err)
 			return
 		}
 		appointments, err := h.service.GetClientAppointments(int64(userID))
 		if err != nil {
-			log.Printf("Error getting appointments: %v", err)
+			h.log.Error("error getting appointments", "error", err)
 			return
 		}
 		h.sendAppointmentsPage(chatID, appointments, page, callbackQuery.Message.MessageID)
 	default:
-		log.Printf("Unknown callback action: %s", action)
+		h.log.Warn("unknown callback action", "action", action)
 		h.handleUnknownCommand(tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}, From: &tgbotapi.User{ID: userID}}})
 	}
 }
@@ -227,35 +284,59 @@ func (h *Handler) sendMessage(chatID int64, text string) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	_, err := h.bot.Send(msg)
 	if err != nil {
-		log.Printf("Error sending message: %v", err)
+		h.log.Error("error sending message", "error", err)
 	}
 }
 
 func (h *Handler) ScheduleNotification(appointmentID string, chatID int64, message string, notifyAt time.Time) {
-	h.mu.Lock()
-	stopCh := make(chan struct{})
-	h.notifications[appointmentID] = stopCh
-	h.mu.Unlock()
-
-	go func() {
-		delay := time.Until(notifyAt)
-		select {
-		case <-time.After(delay):
-			h.sendMessage(chatID, message)
-		case <-stopCh:
-			log.Printf("Notification cancelled for appointment %s", appointmentID)
-			return
-		}
-	}()
+	id, err := uuid.Parse(appointmentID)
+	if err != nil {
+		h.log.Error("invalid appointment ID for notification", "appointment_id", appointmentID, "error", err)
+		return
+	}
+
+	if err := h.service.ScheduleReminder(id, chatID, message, notifyAt); err != nil {
+		h.log.Error("error scheduling notification", "appointment_id", appointmentID, "error", err)
+	}
 }
 
 func (h *Handler) CancelNotification(appointmentID string) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+	id, err := uuid.Parse(appointmentID)
+	if err != nil {
+		h.log.Error("invalid appointment ID for notification", "appointment_id", appointmentID, "error", err)
+		return
+	}
 
-	if stopCh, exists := h.notifications[appointmentID]; exists {
-		close(stopCh)
-		delete(h.notifications, appointmentID)
+	if err := h.service.CancelReminders(id); err != nil {
+		h.log.Error("error cancelling notification", "appointment_id", appointmentID, "error", err)
+		return
+	}
+	h.log.Info("notification cancelled", "appointment_id", appointmentID)
+}
+
+// scheduleAppointmentReminders ставит по напоминанию на каждое смещение из config.yaml.
+// Все они привязаны к записи и отменяются вместе через CancelNotification.
+func (h *Handler) scheduleAppointmentReminders(chatID int64, appointment *common.Appointment) {
+	data := newAppointmentTemplateData(appointment)
+
+	for _, reminder := range h.reminders {
+		notificationTime := appointment.StartTime.Add(-reminder.Offset)
+		if notificationTime.Before(time.Now()) {
+			continue
+		}
+
+		textKey := reminder.TextKey
+		if helper.GetText(textKey) == "" {
+			textKey = "reminder_default"
+		}
+
+		h.ScheduleNotification(
+			appointment.UUID.String(),
+			chatID,
+			helper.FormatText(textKey, data),
+			notificationTime,
+		)
+		h.log.Info("scheduled notification", "appointment_id", appointment.UUID, "notify_at", notificationTime)
 	}
 } // ================Static==================
 
@@ -290,7 +371,7 @@ func (h *Handler) handleLocation(update tgbotapi.Update) {
 
 	dgisButton := tgbotapi.NewInlineKeyboardButtonURL("Открыть в 2GIS", "https://go.2gis.com/ofrhv")
 
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+	keyboard := inlineKeyboard(
 		tgbotapi.NewInlineKeyboardRow(yandexButton, dgisButton),
 	)
 
@@ -298,7 +379,7 @@ func (h *Handler) handleLocation(update tgbotapi.Update) {
 
 	_, err := h.bot.Send(msg)
 	if err != nil {
-		log.Printf("Error sending location message: %v", err)
+		h.log.Error("error sending location message", "error", err)
 	}
 }
 
@@ -311,7 +392,7 @@ func (h *Handler) handleStart(update tgbotapi.Update) {
 
 	_, err := h.service.GetClientBy("telegram_id", userID)
 	if err != nil {
-		log.Printf("Error getting client: %v", err)
+		h.log.Error("error getting client", "error", err)
 		h.requestContact(chatID)
 		return
 	}
@@ -342,10 +423,11 @@ func (h *Handler) handleContact(update tgbotapi.Update) {
 	})
 
 	if client == nil && err != nil {
-		log.Printf("Error creating new client: %v", err)
+		h.log.Error("error creating new client", "error", err)
 		h.sendMessage(message.Chat.ID, helper.GetText("invalid_create_user"))
 		return
 	}
+	h.log.Info("client registered", config.LogKeyPhone, phone)
 
 	msg := tgbotapi.NewMessage(message.Chat.ID, helper.GetFormattedMessage("registration_complete", message.From.FirstName))
 	msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
@@ -371,13 +453,64 @@ func (h *Handler) requestContact(chatID int64) {
 
 // ==================================
 
+// handleBookingAction проверяет, что действие допустимо на текущем шаге,
+// и передаёт его обработчику вместе с шагом, в который оно переводит.
+func (h *Handler) handleBookingAction(chatID int64, userID int64, action string, value string, messageID int) {
+	var state *BookingState
+	var ok bool
+	if action == "reschedule_date" || action == "reschedule_time" || action == "back_to_reschedule_dates" {
+		state, ok = h.rescheduleStateOrRestart(chatID, userID)
+	} else {
+		state, ok = h.bookingStateOrRestart(chatID, userID)
+	}
+	if !ok {
+		return
+	}
+
+	next, value, err := nextBookingStep(state, action, value, h.location)
+	if err != nil {
+		h.log.Warn("rejected callback", "action", action, "error", err)
+		h.sendMessage(chatID, helper.GetText("stale_action"))
+		return
+	}
+
+	switch action {
+	case "service":
+		h.handleServiceSelection(chatID, userID, state, value, messageID)
+	case "services_done":
+		h.handleServicesDone(chatID, userID, state, next)
+	case "back_to_services":
+		state.Step = next
+		h.saveBookingState(userID, state)
+		h.sendServiceSelection(chatID, state, 0)
+	case "date":
+		h.handleDateSelection(chatID, userID, state, value, next)
+	case "back_to_dates":
+		state.Step = next
+		h.saveBookingState(userID, state)
+		h.sendDateSelection(chatID)
+	case "time":
+		h.handleTimeSelection(chatID, userID, state, value, next)
+	case "confirm_booking":
+		h.handleBookingConfirmation(chatID, userID, state)
+	case "reschedule_date":
+		h.handleRescheduleDate(chatID, userID, state, value, next)
+	case "reschedule_time":
+		h.handleRescheduleTime(chatID, userID, state, value)
+	case "back_to_reschedule_dates":
+		state.Step = next
+		h.saveBookingState(userID, state)
+		h.sendRescheduleDateSelection(chatID)
+	}
+}
+
 func (h *Handler) handleBook(update tgbotapi.Update) {
 	chatID := update.Message.Chat.ID
 	userID := update.Message.From.ID
 
 	client, err := h.service.GetClientBy("telegram_id", userID)
 	if err != nil {
-		log.Printf("Error getting client: %v", err)
+		h.log.Error("error getting client", "error", err)
 		h.sendMessage(chatID, helper.GetText("invalid_get_user"))
 	}
 
@@ -386,105 +519,146 @@ func (h *Handler) handleBook(update tgbotapi.Update) {
 		return
 	}
 
-	h.bookingStates[userID] = &BookingState{Step: stepSelectService}
-	h.sendServiceSelection(chatID)
+	h.startBooking(chatID, userID)
+}
+
+func (h *Handler) startBooking(chatID int64, userID int64) {
+	state := &BookingState{Step: stepSelectService}
+	h.saveBookingState(userID, state)
+	h.sendServiceSelection(chatID, state, 0)
 }
 
-func (h *Handler) sendServiceSelection(chatID int64) {
+// sendServiceSelection показывает услуги с отметками выбранных. Если передан
+// messageID, клавиатура обновляется в том же сообщении, а не отправляется заново.
+func (h *Handler) sendServiceSelection(chatID int64, state *BookingState, messageID int) {
 	services, err := h.service.GetActiveServices()
 	if err != nil {
-		log.Printf("Error getting services: %v", err)
+		h.log.Error("error getting services", "error", err)
 		h.sendMessage(chatID, helper.GetText("invalid_get_services"))
 		return
 	}
 
+	selected := make(map[string]bool)
+	for _, id := range state.ServiceIDs {
+		selected[id] = true
+	}
+
+	var chosen []common.Service
 	var keyboard [][]tgbotapi.InlineKeyboardButton
 	for _, service := range services {
-		button := tgbotapi.NewInlineKeyboardButtonData(
-			fmt.Sprintf("%s (%d мин, %.2f руб)", service.Name, service.Duration, service.Price),
-			fmt.Sprintf("service:%s", service.UUID),
+		mark := "▫️"
+		if selected[service.UUID.String()] {
+			mark = "✅"
+			chosen = append(chosen, service)
+		}
+		button := h.button(
+			chatID,
+			fmt.Sprintf("%s %s (%d мин, %.2f руб)", mark, service.Name, service.Duration, service.Price),
+			"service",
+			service.UUID.String(),
 		)
 		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{button})
 	}
 	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData(helper.GetText("cancel_button"), "go_home"),
+		h.button(chatID, helper.GetText("services_done_button"), "services_done", ""),
+		h.button(chatID, helper.GetText("cancel_button"), "go_home", ""),
 	})
 
-	msg := tgbotapi.NewMessage(chatID, helper.GetText("select_service"))
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+	text := helper.GetText("select_service")
+	if len(chosen) > 0 {
+		name, duration, price := summarizeServices(chosen)
+		text += fmt.Sprintf("\n🛒 %s\n⏱ %d мин, 💰 %.2f руб.", name, duration, price)
+	}
+	markup := inlineKeyboard(keyboard...)
+
+	if messageID != 0 {
+		h.bot.Send(tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, text, markup))
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = markup
 	h.bot.Send(msg)
 }
 
 func (h *Handler) sendDateSelection(chatID int64) {
 	availableDates, err := h.service.GetWorkingHoursAvailableDates()
 	if err != nil {
-		log.Printf("Error getting available dates: %v", err)
+		h.log.Error("error getting available dates", "error", err)
 		h.sendMessage(chatID, helper.GetText("invalid_get_dates"))
 		return
 	}
 
 	var keyboard [][]tgbotapi.InlineKeyboardButton
 	for _, date := range availableDates {
-		button := tgbotapi.NewInlineKeyboardButtonData(
+		button := h.button(
+			chatID,
 			date.Format("02.01.2006"),
-			fmt.Sprintf("date:%s", date.Format("2006-01-02")),
+			"date",
+			date.Format("2006-01-02"),
 		)
 		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{button})
 	}
 	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData(helper.GetText("back_button"), "back_to_services"),
-		tgbotapi.NewInlineKeyboardButtonData(helper.GetText("cancel_button"), "go_home"),
+		h.button(chatID, helper.GetText("back_button"), "back_to_services", ""),
+		h.button(chatID, helper.GetText("cancel_button"), "go_home", ""),
 	})
 
 	msg := tgbotapi.NewMessage(chatID, helper.GetText("select_date"))
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+	msg.ReplyMarkup = inlineKeyboard(keyboard...)
 	h.bot.Send(msg)
 }
 
-func (h *Handler) sendTimeSelection(chatID int64, userID int64) {
-	state := h.bookingStates[userID]
-	serviceIDs := []uuid.UUID{uuid.MustParse(state.ServiceID)}
+func (h *Handler) sendTimeSelection(chatID int64, state *BookingState) {
+	serviceIDs, err := parseServiceIDs(state.ServiceIDs)
+	if err != nil {
+		h.log.Error("error parsing service IDs", "error", err)
+		h.sendMessage(chatID, helper.GetText("invalid_id_service"))
+		return
+	}
 
 	availableSlots, err := h.service.GetWorkingHoursAvailableSlots(serviceIDs, state.Date)
 	if err != nil {
-		log.Printf("Error getting available time slots: %v", err)
+		h.log.Error("error getting available time slots", "error", err)
 		h.sendMessage(chatID, helper.GetText("invalid_get_slots"))
 		return
 	}
 
 	var keyboard [][]tgbotapi.InlineKeyboardButton
 	for _, slot := range availableSlots {
-		button := tgbotapi.NewInlineKeyboardButtonData(
+		button := h.button(
+			chatID,
 			slot.Format("15:04"),
-			fmt.Sprintf("time:%s", slot.Format("15:04")),
+			"time",
+			slot.In(h.location).Format(slotCallbackLayout),
 		)
 		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{button})
 	}
 	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData(helper.GetText("back_button"), "back_to_dates"),
-		tgbotapi.NewInlineKeyboardButtonData(helper.GetText("cancel_button"), "go_home"),
+		h.button(chatID, helper.GetText("back_button"), "back_to_dates", ""),
+		h.button(chatID, helper.GetText("cancel_button"), "go_home", ""),
 	})
 
 	msg := tgbotapi.NewMessage(chatID, helper.GetText("select_time"))
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+	msg.ReplyMarkup = inlineKeyboard(keyboard...)
 	h.bot.Send(msg)
 }
 
-func (h *Handler) sendBookingConfirmation(chatID int64, userID int64) {
-	state := h.bookingStates[userID]
-	serviceID, err := uuid.Parse(state.ServiceID)
+func (h *Handler) sendBookingConfirmation(chatID int64, state *BookingState) {
+	serviceIDs, err := parseServiceIDs(state.ServiceIDs)
 	if err != nil {
-		log.Printf("Error parsing serviceID: %v", err)
+		h.log.Error("error parsing service IDs", "error", err)
 		h.sendMessage(chatID, helper.GetText("invalid_id_service"))
 		return
 	}
 
-	service, err := h.service.GetServiceByID(serviceID)
+	services, err := h.service.GetServicesByIDs(serviceIDs)
 	if err != nil {
-		log.Printf("Error getting service: %v", err)
+		h.log.Error("error getting services", "error", err)
 		h.sendMessage(chatID, helper.GetText("invalid_id_service"))
 		return
 	}
+	name, duration, price := summarizeServices(services)
 
 	confirmationText := fmt.Sprintf(
 		"Пожалуйста, подтвердите ваше бронирование:\n\n"+
@@ -493,16 +667,16 @@ func (h *Handler) sendBookingConfirmation(chatID int64, userID int64) {
 			"🕒 Время: %s (сеанса: %d минут)\n\n"+
 			"💰 Стоимость: %.2f руб.\n\n",
 		state.Date.Format("02.01.2006"),
-		service.Name,
+		name,
 		state.Time,
-		service.Duration,
-		service.Price,
+		duration,
+		price,
 	)
 
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+	keyboard := inlineKeyboard(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(helper.GetText("confirm_button"), "confirm_booking"),
-			tgbotapi.NewInlineKeyboardButtonData(helper.GetText("cancel_button"), "go_home"),
+			h.button(chatID, helper.GetText("confirm_button"), "confirm_booking", ""),
+			h.button(chatID, helper.GetText("cancel_button"), "go_home", ""),
 		),
 	)
 
@@ -511,50 +685,79 @@ func (h *Handler) sendBookingConfirmation(chatID int64, userID int64) {
 	h.bot.Send(msg)
 }
 
-func (h *Handler) handleServiceSelection(chatID int64, userID int64, serviceID string) {
-	err := h.service.SaveSelectedService(userID, serviceID)
-	if err != nil {
-		log.Printf("Error saving selected service: %v", err)
-		h.sendMessage(chatID, helper.GetText("invalid_get_services"))
+// handleServiceSelection добавляет услугу в корзину или убирает её, если она уже выбрана
+func (h *Handler) handleServiceSelection(chatID int64, userID int64, state *BookingState, serviceID string, messageID int) {
+	if _, err := uuid.Parse(serviceID); err != nil {
+		h.sendMessage(chatID, helper.GetText("invalid_id_service"))
 		return
 	}
 
-	h.bookingStates[userID].ServiceID = serviceID
-	h.bookingStates[userID].Step = stepSelectDate
-	h.sendDateSelection(chatID)
+	state.ServiceIDs = toggleServiceID(state.ServiceIDs, serviceID)
+	h.saveBookingState(userID, state)
+	h.sendServiceSelection(chatID, state, messageID)
 }
 
-func (h *Handler) handleDateSelection(chatID int64, userID int64, dateStr string) {
-	date, err := time.Parse("2006-01-02", dateStr)
-	if err != nil {
-		log.Printf("Error parsing date: %v", err)
-		h.sendMessage(chatID, "Произошла ошибка при обработке выбранной даты.")
+func (h *Handler) handleServicesDone(chatID int64, userID int64, state *BookingState, next int) {
+	if len(state.ServiceIDs) == 0 {
+		h.sendMessage(chatID, helper.GetText("select_services_empty"))
 		return
 	}
 
-	err = h.service.SaveSelectedDate(userID, date)
+	state.Step = next
+	h.saveBookingState(userID, state)
+	h.sendDateSelection(chatID)
+}
+
+func toggleServiceID(serviceIDs []string, serviceID string) []string {
+	for i, id := range serviceIDs {
+		if id == serviceID {
+			return append(serviceIDs[:i:i], serviceIDs[i+1:]...)
+		}
+	}
+	return append(serviceIDs, serviceID)
+}
+
+func parseServiceIDs(serviceIDs []string) ([]uuid.UUID, error) {
+	if len(serviceIDs) == 0 {
+		return nil, fmt.Errorf("no services selected")
+	}
+
+	ids := make([]uuid.UUID, 0, len(serviceIDs))
+	for _, id := range serviceIDs {
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid service ID %q: %w", id, err)
+		}
+		ids = append(ids, parsed)
+	}
+	return ids, nil
+}
+
+func (h *Handler) handleDateSelection(chatID int64, userID int64, state *BookingState, dateStr string, next int) {
+	date, err := time.ParseInLocation("2006-01-02", dateStr, h.location)
 	if err != nil {
-		log.Printf("Error saving selected date: %v", err)
-		h.sendMessage(chatID, "Произошла ошибка при выборе даты.")
+		h.log.Error("error parsing date", "error", err)
+		h.sendMessage(chatID, "Произошла ошибка при обработке выбранной даты.")
 		return
 	}
 
-	h.bookingStates[userID].Date = date
-	h.bookingStates[userID].Step = stepSelectTime
-	h.sendTimeSelection(chatID, userID)
+	state.Date = date
+	state.Step = next
+	h.saveBookingState(userID, state)
+	h.sendTimeSelection(chatID, state)
 }
 
-func (h *Handler) handleTimeSelection(chatID int64, userID int64, timeStr string) {
-	h.bookingStates[userID].Time = timeStr
-	h.bookingStates[userID].Step = stepConfirmBooking
-
-	h.sendBookingConfirmation(chatID, userID)
+func (h *Handler) handleTimeSelection(chatID int64, userID int64, state *BookingState, timeStr string, next int) {
+	state.Time = timeStr
+	state.Step = next
+	h.saveBookingState(userID, state)
+	h.sendBookingConfirmation(chatID, state)
 }
 
-func (h *Handler) handleBookingConfirmation(chatID int64, userID int64) {
+func (h *Handler) handleBookingConfirmation(chatID int64, userID int64, state *BookingState) {
 	client, err := h.service.GetClientBy("telegram_id", userID)
 	if err != nil {
-		log.Printf("Error getting client: %v", err)
+		h.log.Error("error getting client", "error", err)
 		h.sendMessage(chatID, helper.GetText("invalid_get_user"))
 		return
 	}
@@ -564,49 +767,30 @@ func (h *Handler) handleBookingConfirmation(chatID int64, userID int64) {
 		return
 	}
 
-	stateTime := h.bookingStates[userID].Time
-	appointment, err := h.service.CreateAppointment(userID, stateTime)
-
+	serviceIDs, err := parseServiceIDs(state.ServiceIDs)
 	if err != nil {
-		log.Printf("Error creating appointment: %v", err)
-		h.sendMessage(chatID, helper.GetText("invalid_create_appointment"))
+		h.log.Error("error parsing service IDs", "error", err)
+		h.sendMessage(chatID, helper.GetText("invalid_id_service"))
 		return
 	}
 
-	if h.calendarService != nil {
-		eventID, calErr := h.calendarService.AddAppointment(appointment, client)
-		if calErr != nil {
-			log.Printf("Error adding event to Google Calendar: %v", calErr)
-		} else {
-			err = h.service.SaveCalendarEventID(appointment.UUID, eventID)
-			if err != nil {
-				log.Printf("Error saving calendar event ID: %v", err)
-			}
-		}
+	appointment, err := h.service.CreateAppointment(userID, serviceIDs, state.Date, state.Time)
+
+	if errors.Is(err, common.ErrSlotTaken) {
+		h.sendMessage(chatID, helper.GetText("slot_taken"))
+		state.Step = stepSelectTime
+		h.saveBookingState(userID, state)
+		h.sendTimeSelection(chatID, state)
+		return
+	}
+	if err != nil {
+		h.log.Error("error creating appointment", "error", err)
+		h.sendMessage(chatID, helper.GetText("invalid_create_appointment"))
+		return
 	}
 
 	if appointment != nil {
-		notificationTime := appointment.StartTime.Add(-2 * time.Hour)
-		notificationMessage := fmt.Sprintf(
-			"🔔 Напоминание!\n\n"+
-				"Через 2 часа у вас запись:\n"+
-				"🗓 %s\n"+
-				"🕒 %s\n"+
-				"💇 %s\n"+
-				"💰 %.2f руб.\n"+
-				"🚩 улица Куйбышева, 79",
-			appointment.StartTime.Format("02.01.2006"),
-			appointment.StartTime.Format("15:04"),
-			appointment.Name,
-			appointment.TotalPrice,
-		)
-		h.ScheduleNotification(
-			appointment.UUID.String(),
-			chatID,
-			notificationMessage,
-			notificationTime,
-		)
-		log.Printf("Scheduled notification for appointment %s at %v", appointment.UUID, notificationTime)
+		h.scheduleAppointmentReminders(chatID, appointment)
 	}
 
 	successMessage := fmt.Sprintf(
@@ -616,14 +800,14 @@ func (h *Handler) handleBookingConfirmation(chatID int64, userID int64) {
 			"🕒 Время: %s\n\n"+
 			"💰 Стоимость: %.2f руб.\n\n"+
 			"🚩 Адрес: улица Куйбышева, 79.",
-		appointment.Name,
 		appointment.StartTime.Format("02.01.2006"),
+		appointment.Name,
 		appointment.StartTime.Format("15:04"),
 		appointment.TotalPrice,
 	)
 	h.sendMessage(chatID, successMessage)
 
-	delete(h.bookingStates, userID)
+	h.clearBookingState(userID)
 }
 
 // ==================================
@@ -634,7 +818,7 @@ func (h *Handler) handleMyAppointments(update tgbotapi.Update) {
 
 	appointments, err := h.service.GetClientAppointments(userID)
 	if err != nil {
-		log.Printf("Error getting appointments: %v", err)
+		h.log.Error("error getting appointments", "error", err)
 		h.sendMessage(chatID, helper.GetText("invalid_get_appointments"))
 		return
 	}
@@ -655,8 +839,7 @@ func (h *Handler) sendAppointmentsList(chatID int64, appointments []common.Appoi
 
 	for _, appointment := range appointments[:min(appointmentsPerPage, len(appointments))] {
 		buttonText := fmt.Sprintf("%s - %s", appointment.StartTime.Format("02.01 15:04"), appointment.Name)
-		callbackData := fmt.Sprintf("appointment:%s", appointment.UUID)
-		button := tgbotapi.NewInlineKeyboardButtonData(buttonText, callbackData)
+		button := h.button(chatID, buttonText, "appointment", appointment.UUID.String())
 		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{button})
 	}
 
@@ -664,7 +847,7 @@ func (h *Handler) sendAppointmentsList(chatID int64, appointments []common.Appoi
 	var navigationRow []tgbotapi.InlineKeyboardButton
 	if totalPages > 1 {
 		if len(appointments) > appointmentsPerPage {
-			navigationRow = append(navigationRow, tgbotapi.NewInlineKeyboardButtonData("➡️", fmt.Sprintf("page:1")))
+			navigationRow = append(navigationRow, h.button(chatID, "➡️", "page", "1"))
 		}
 	}
 
@@ -673,7 +856,7 @@ func (h *Handler) sendAppointmentsList(chatID int64, appointments []common.Appoi
 	}
 
 	msg := tgbotapi.NewMessage(chatID, helper.GetText("select_appointment"))
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+	msg.ReplyMarkup = inlineKeyboard(keyboard...)
 
 	h.bot.Send(msg)
 }
@@ -689,18 +872,17 @@ func (h *Handler) sendAppointmentsPage(chatID int64, appointments []common.Appoi
 
 	for _, appointment := range appointments[startIndex:endIndex] {
 		buttonText := fmt.Sprintf("%s - %s", appointment.StartTime.Format("02.01 15:04"), appointment.Name)
-		callbackData := fmt.Sprintf("appointment:%s", appointment.UUID)
-		button := tgbotapi.NewInlineKeyboardButtonData(buttonText, callbackData)
+		button := h.button(chatID, buttonText, "appointment", appointment.UUID.String())
 		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{button})
 	}
 
 	// Add navigation buttons
 	var navigationRow []tgbotapi.InlineKeyboardButton
 	if page > 1 {
-		navigationRow = append(navigationRow, tgbotapi.NewInlineKeyboardButtonData("⬅️", fmt.Sprintf("page:%d", page-1)))
+		navigationRow = append(navigationRow, h.button(chatID, "⬅️", "page", strconv.Itoa(page-1)))
 	}
 	if page < totalPages {
-		navigationRow = append(navigationRow, tgbotapi.NewInlineKeyboardButtonData("➡️", fmt.Sprintf("page:%d", page+1)))
+		navigationRow = append(navigationRow, h.button(chatID, "➡️", "page", strconv.Itoa(page+1)))
 	}
 
 	if len(navigationRow) > 0 {
@@ -708,11 +890,12 @@ func (h *Handler) sendAppointmentsPage(chatID int64, appointments []common.Appoi
 	}
 
 	msg := tgbotapi.NewEditMessageText(chatID, messageID, helper.GetText("select_appointment"))
-	msg.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+	markup := inlineKeyboard(keyboard...)
+	msg.ReplyMarkup = &markup
 
 	_, err := h.bot.Send(msg)
 	if err != nil {
-		log.Printf("Error sending edited message: %v", err)
+		h.log.Error("error sending edited message", "error", err)
 	}
 }
 
@@ -723,10 +906,16 @@ func min(a, b int) int {
 	return b
 }
 
-func (h *Handler) handleAppointmentSelection(chatID int64, appointmentID string) {
-	appointment, err := h.service.GetAppointmentByID(uuid.MustParse(appointmentID))
+func (h *Handler) handleAppointmentSelection(chatID int64, userID int64, appointmentID string) {
+	id, err := uuid.Parse(appointmentID)
+	if err != nil {
+		h.sendMessage(chatID, "Неверный идентификатор записи")
+		return
+	}
+
+	appointment, err := h.service.GetClientAppointment(userID, id)
 	if err != nil {
-		log.Printf("Error getting appointment: %v", err)
+		h.log.Error("error getting appointment", "error", err)
 		h.sendMessage(chatID, helper.GetText("invalid_get_appointment"))
 		return
 	}
@@ -746,9 +935,13 @@ func (h *Handler) handleAppointmentSelection(chatID int64, appointmentID string)
 		getStatusEmoji(appointment.Status),
 	)
 
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+	if !appointment.ConfirmedAt.IsZero() {
+		messageText += "\n\n🙋 Визит подтверждён"
+	}
+
+	keyboard := inlineKeyboard(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(helper.GetText("back_button"), "back_to_appointments"),
+			h.button(chatID, helper.GetText("back_button"), "back_to_appointments", ""),
 		),
 	)
 
@@ -765,6 +958,8 @@ func getStatusEmoji(status string) string {
 		return "✔️ Завершено"
 	case "cancelled":
 		return "❌ Отменено"
+	case "no_show":
+		return "🚫 Неявка"
 	default:
 		return "❓ Неизвестно"
 	}
@@ -778,7 +973,7 @@ func (h *Handler) handleCancel(update tgbotapi.Update) {
 
 	appointments, err := h.service.GetClientScheduledAppointmentsByID(userID)
 	if err != nil {
-		log.Printf("Error getting appointments: %v", err)
+		h.log.Error("error getting appointments", "error", err)
 		h.sendMessage(chatID, helper.GetText("invalid_get_appointments"))
 		return
 	}
@@ -791,17 +986,16 @@ func (h *Handler) handleCancel(update tgbotapi.Update) {
 	var keyboard [][]tgbotapi.InlineKeyboardButton
 	for _, appointment := range appointments {
 		buttonText := fmt.Sprintf("%s - %s", appointment.StartTime.Format("02.01 15:04"), appointment.Name)
-		callbackData := fmt.Sprintf("cancel:%s", appointment.UUID)
-		button := tgbotapi.NewInlineKeyboardButtonData(buttonText, callbackData)
+		button := h.button(chatID, buttonText, "cancel", appointment.UUID.String())
 		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{button})
 	}
 
 	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData(helper.GetText("cancel_button"), "go_home"),
+		h.button(chatID, helper.GetText("cancel_button"), "go_home", ""),
 	})
 
 	msg := tgbotapi.NewMessage(chatID, helper.GetText("select_cancel_appointment"))
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+	msg.ReplyMarkup = inlineKeyboard(keyboard...)
 	h.bot.Send(msg)
 }
 
@@ -812,34 +1006,38 @@ func (h *Handler) handleAppointmentCancellation(chatID int64, userID int64, appo
 		return
 	}
 
-	// Cancel notification before cancelling appointment
-	h.CancelNotification(appointmentID)
-
-	// Rest of your existing cancellation code...
-	eventID, err := h.service.GetCalendarEventID(uuid)
-	if err != nil {
-		log.Printf("Error getting calendar event ID: %v", err)
-	}
-
-	if h.calendarService != nil && eventID != "" {
-		if calErr := h.calendarService.RemoveAppointment(eventID); calErr != nil {
-			log.Printf("Error removing event from Google Calendar: %v", calErr)
-		}
-	}
-
+	// Сначала отменяем запись: сервис проверяет, что она принадлежит пользователю
 	err = h.service.CancelAppointment(userID, uuid)
 	if err != nil {
-		log.Printf("Error cancelling appointment: %v", err)
+		h.log.Error("error cancelling appointment", "error", err)
 		h.sendMessage(chatID, "Произошла ошибка при отмене записи")
 		return
 	}
 
+	h.CancelNotification(appointmentID)
+
 	h.handleBookingCancellation(chatID, userID)
 	h.sendMessage(chatID, helper.GetText("go_home"))
 }
 
+func (h *Handler) handleVisitConfirmation(chatID int64, userID int64, appointmentID string) {
+	uuid, err := uuid.Parse(appointmentID)
+	if err != nil {
+		h.sendMessage(chatID, "Неверный идентификатор записи.")
+		return
+	}
+
+	if _, err := h.service.ConfirmAppointmentVisit(userID, uuid); err != nil {
+		h.log.Error("error confirming visit", "error", err)
+		h.sendMessage(chatID, helper.GetText("invalid_confirm_visit"))
+		return
+	}
+
+	h.sendMessage(chatID, helper.GetText("visit_confirmed"))
+}
+
 func (h *Handler) handleBookingCancellation(chatID int64, userID int64) {
-	delete(h.bookingStates, userID)
+	h.clearBookingState(userID)
 	h.sendMessage(chatID, helper.GetText("appointment_cancel"))
 }
 
@@ -851,7 +1049,7 @@ func (h *Handler) handleReschedule(update tgbotapi.Update) {
 
 	appointments, err := h.service.GetClientScheduledAppointmentsByID(userID)
 	if err != nil {
-		log.Printf("Error getting appointments: %v", err)
+		h.log.Error("error getting appointments", "error", err)
 		h.sendMessage(chatID, helper.GetText("invalid_get_appointments"))
 		return
 	}
@@ -864,16 +1062,27 @@ func (h *Handler) handleReschedule(update tgbotapi.Update) {
 	var keyboard [][]tgbotapi.InlineKeyboardButton
 	for _, appointment := range appointments {
 		buttonText := fmt.Sprintf("%s - %s", appointment.StartTime.Format("02.01 15:04"), appointment.Name)
-		callbackData := fmt.Sprintf("reschedule:%s", appointment.UUID)
-		button := tgbotapi.NewInlineKeyboardButtonData(buttonText, callbackData)
+		button := h.button(chatID, buttonText, "reschedule", appointment.UUID.String())
 		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{button})
 	}
 
 	msg := tgbotapi.NewMessage(chatID, "Выберите запись для переноса:")
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+	msg.ReplyMarkup = inlineKeyboard(keyboard...)
 	h.bot.Send(msg)
 }
 
+// rescheduleStateOrRestart как bookingStateOrRestart, но при потере состояния
+// заново предлагает выбрать запись для переноса.
+func (h *Handler) rescheduleStateOrRestart(chatID int64, userID int64) (*BookingState, bool) {
+	if state := h.loadBookingState(userID); state != nil && state.AppointmentID != "" {
+		return state, true
+	}
+
+	h.sendMessage(chatID, helper.GetText("session_expired"))
+	h.handleReschedule(tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}, From: &tgbotapi.User{ID: userID}}})
+	return nil, false
+}
+
 func (h *Handler) handleAppointmentReschedule(chatID int64, userID int64, appointmentID string) {
 	uuid, err := uuid.Parse(appointmentID)
 	if err != nil {
@@ -881,9 +1090,9 @@ func (h *Handler) handleAppointmentReschedule(chatID int64, userID int64, appoin
 		return
 	}
 
-	appointment, err := h.service.GetAppointmentByID(uuid)
+	appointment, err := h.service.GetClientAppointment(userID, uuid)
 	if err != nil {
-		log.Printf("Error getting appointment: %v", err)
+		h.log.Error("error getting appointment", "error", err)
 		h.sendMessage(chatID, "Не удалось найти запись")
 		return
 	}
@@ -893,168 +1102,113 @@ func (h *Handler) handleAppointmentReschedule(chatID int64, userID int64, appoin
 		serviceIDs = append(serviceIDs, service.UUID.String())
 	}
 
-	h.bookingStates[userID] = &BookingState{
-		Step:          stepSelectDate,
-		ServiceID:     strings.Join(serviceIDs, ","), // Store multiple service IDs
+	h.saveBookingState(userID, &BookingState{
+		Step:          stepRescheduleDate,
+		ServiceIDs:    serviceIDs,
 		AppointmentID: appointmentID,
-	}
+	})
 
-	// Get available dates for rescheduling
+	h.sendRescheduleDateSelection(chatID)
+}
+
+func (h *Handler) sendRescheduleDateSelection(chatID int64) {
 	availableDates, err := h.service.GetWorkingHoursAvailableDates()
 	if err != nil {
-		log.Printf("Error getting available dates: %v", err)
+		h.log.Error("error getting available dates", "error", err)
 		h.sendMessage(chatID, "Не удалось получить доступные даты")
 		return
 	}
 
 	var keyboard [][]tgbotapi.InlineKeyboardButton
 	for _, date := range availableDates {
-		button := tgbotapi.NewInlineKeyboardButtonData(
+		button := h.button(
+			chatID,
 			date.Format("02.01.2006"),
-			fmt.Sprintf("reschedule_date:%s", date.Format("2006-01-02")),
+			"reschedule_date",
+			date.Format("2006-01-02"),
 		)
 		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{button})
 	}
 	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData(helper.GetText("cancel_button"), "go_home"),
+		h.button(chatID, helper.GetText("cancel_button"), "go_home", ""),
 	})
 
 	msg := tgbotapi.NewMessage(chatID, "Выберите новую дату:")
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+	msg.ReplyMarkup = inlineKeyboard(keyboard...)
 	h.bot.Send(msg)
 }
 
-func (h *Handler) handleRescheduleDate(chatID int64, userID int64, dateStr string) {
-	date, err := time.Parse("2006-01-02", dateStr)
+func (h *Handler) handleRescheduleDate(chatID int64, userID int64, state *BookingState, dateStr string, next int) {
+	date, err := time.ParseInLocation("2006-01-02", dateStr, h.location)
 	if err != nil {
-		log.Printf("Error parsing date: %v", err)
+		h.log.Error("error parsing date", "error", err)
 		h.sendMessage(chatID, "Ошибка при обработке даты")
 		return
 	}
 
-	state := h.bookingStates[userID]
 	state.Date = date
+	state.Step = next
+	h.saveBookingState(userID, state)
+
+	serviceIDs, err := parseServiceIDs(state.ServiceIDs)
+	if err != nil {
+		h.log.Error("error parsing service IDs", "error", err)
+		h.sendMessage(chatID, helper.GetText("invalid_id_service"))
+		return
+	}
 
-	serviceIDs := []uuid.UUID{uuid.MustParse(state.ServiceID)}
 	availableSlots, err := h.service.GetWorkingHoursAvailableSlots(serviceIDs, date)
 	if err != nil {
-		log.Printf("Error getting available slots: %v", err)
+		h.log.Error("error getting available slots", "error", err)
 		h.sendMessage(chatID, "Не удалось получить доступное время")
 		return
 	}
 
 	var keyboard [][]tgbotapi.InlineKeyboardButton
 	for _, slot := range availableSlots {
-		button := tgbotapi.NewInlineKeyboardButtonData(
+		button := h.button(
+			chatID,
 			slot.Format("15:04"),
-			fmt.Sprintf("reschedule_time:%s", slot.Format("15:04")),
+			"reschedule_time",
+			slot.In(h.location).Format(slotCallbackLayout),
 		)
 		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{button})
 	}
 	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData(helper.GetText("cancel_button"), "go_home"),
+		h.button(chatID, helper.GetText("back_button"), "back_to_reschedule_dates", ""),
+		h.button(chatID, helper.GetText("cancel_button"), "go_home", ""),
 	})
 
 	msg := tgbotapi.NewMessage(chatID, "Выберите новое время:")
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+	msg.ReplyMarkup = inlineKeyboard(keyboard...)
 	h.bot.Send(msg)
 }
 
-func (h *Handler) handleRescheduleTime(chatID int64, userID int64, timeStr string) {
-	state := h.bookingStates[userID]
+func (h *Handler) handleRescheduleTime(chatID int64, userID int64, state *BookingState, timeStr string) {
 
 	appointmentUUID, err := uuid.Parse(state.AppointmentID)
 	if err != nil {
-		log.Printf("Error parsing appointment UUID: %v", err)
+		h.log.Error("error parsing appointment UUID", "error", err)
 		h.sendMessage(chatID, "Ошибка при обработке записи")
 		return
 	}
 
-	// Get original appointment to preserve services
-	originalAppointment, err := h.service.GetAppointmentByID(appointmentUUID)
-	if err != nil {
-		log.Printf("Error getting original appointment: %v", err)
-		h.sendMessage(chatID, "Ошибка при получении данных записи")
-		return
-	}
-
-	// Calculate total duration from services
-	totalDuration := 0
-	for _, service := range originalAppointment.Services {
-		totalDuration += service.Duration
-	}
-
-	// Parse and set new time
-	timeLayout := "15:04"
-	newTime, err := time.Parse(timeLayout, timeStr)
-	if err != nil {
-		log.Printf("Error parsing time: %v", err)
-		h.sendMessage(chatID, "Ошибка при обработке времени")
+	appointment, err := h.service.RescheduleAppointment(userID, appointmentUUID, state.Date, timeStr)
+	if errors.Is(err, common.ErrSlotTaken) {
+		h.sendMessage(chatID, helper.GetText("slot_taken"))
+		h.handleRescheduleDate(chatID, userID, state, state.Date.Format("2006-01-02"), stepRescheduleTime)
 		return
 	}
-
-	// Create new start and end times
-	newStartTime := time.Date(
-		state.Date.Year(), state.Date.Month(), state.Date.Day(),
-		newTime.Hour(), newTime.Minute(), 0, 0,
-		state.Date.Location(),
-	)
-	newEndTime := newStartTime.Add(time.Duration(totalDuration) * time.Minute)
-
-	// Get old calendar event ID
-	oldEventID, err := h.service.GetCalendarEventID(appointmentUUID)
-	if err != nil {
-		log.Printf("Error getting old calendar event ID: %v", err)
-	}
-
-	// Update appointment with new times
-	err = h.service.UpdateAppointmentDateTime(appointmentUUID, newStartTime, newEndTime)
 	if err != nil {
-		log.Printf("Error updating appointment: %v", err)
+		h.log.Error("error rescheduling appointment", "error", err)
 		h.sendMessage(chatID, "Не удалось обновить запись")
+		return
 	}
 
-	// Handle Google Calendar update
-	if h.calendarService != nil {
-		if oldEventID != "" {
-			h.calendarService.RemoveAppointment(oldEventID)
-		}
-
-		updatedAppointment, _ := h.service.GetAppointmentByID(appointmentUUID)
-		client, _ := h.service.GetClientBy("telegram_id", userID)
-
-		if updatedAppointment != nil && client != nil {
-			eventID, err := h.calendarService.AddAppointment(updatedAppointment, client)
-			if err == nil {
-				h.service.SaveCalendarEventID(appointmentUUID, eventID)
-			}
-		}
-
-		if updatedAppointment != nil {
-			notificationTime := newStartTime.Add(-2 * time.Hour)
-			notificationMessage := fmt.Sprintf(
-				"🔔 Напоминание о перенесенной записи!\n\n"+
-					"Через 2 часа у вас запись:\n"+
-					"🗓 %s\n"+
-					"🕒 %s\n"+
-					"💇 %s\n"+
-					"💰 %.2f руб.\n"+
-					"🚩 улица Куйбышева, 79",
-				newStartTime.Format("02.01.2006"),
-				newStartTime.Format("15:04"),
-				updatedAppointment.Name,
-				updatedAppointment.TotalPrice,
-			)
-			h.ScheduleNotification(
-				appointmentUUID.String(),
-				chatID,
-				notificationMessage,
-				notificationTime,
-			)
-		}
-
-	}
+	// Старые напоминания указывают на прежнее время, заменяем их
+	h.CancelNotification(appointmentUUID.String())
+	h.scheduleAppointmentReminders(chatID, appointment)
 
-	h.sendMessage(chatID, fmt.Sprintf("✅ Запись успешно перенесена на %s", newStartTime.Format("02.01.2006 15:04")))
-	delete(h.bookingStates, userID)
+	h.sendMessage(chatID, fmt.Sprintf("✅ Запись успешно перенесена на %s", appointment.StartTime.Format("02.01.2006 15:04")))
+	h.clearBookingState(userID)
 }
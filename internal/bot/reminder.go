@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/RudinMaxim/BarberBot.git/common"
+	"github.com/RudinMaxim/BarberBot.git/config"
+	"github.com/RudinMaxim/BarberBot.git/helper"
+	"github.com/RudinMaxim/BarberBot.git/internal/metrics"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	reminderStatusPending   = "pending"
+	reminderStatusSent      = "sent"
+	reminderStatusCancelled = "cancelled"
+	reminderStatusFailed    = "failed"
+
+	reminderPollInterval = 15 * time.Second
+	reminderLease        = time.Minute
+	reminderBatchSize    = 50
+	reminderMaxAttempts  = 5
+)
+
+// ReminderDispatcher периодически забирает из базы наступившие напоминания и отправляет их.
+// Напоминания хранятся в Postgres, поэтому переживают перезапуск контейнера.
+type ReminderDispatcher struct {
+	service   *Service
+	bot       *tgbotapi.BotAPI
+	callbacks *CallbackCodec
+	log       *slog.Logger
+}
+
+func NewReminderDispatcher(service *Service, bot *tgbotapi.BotAPI, callbacks *CallbackCodec) *ReminderDispatcher {
+	return &ReminderDispatcher{
+		service:   service,
+		bot:       bot,
+		callbacks: callbacks,
+		log:       slog.Default().With("component", "reminders"),
+	}
+}
+
+func (d *ReminderDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(reminderPollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.dispatchDue()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *ReminderDispatcher) dispatchDue() {
+	reminders, err := d.service.ClaimDueReminders(reminderLease, reminderBatchSize)
+	if err != nil {
+		d.log.Error("error claiming due reminders", "error", err)
+		return
+	}
+
+	for _, reminder := range reminders {
+		d.dispatch(reminder)
+	}
+
+	pending, err := d.service.CountPendingReminders()
+	if err != nil {
+		d.log.Error("error counting pending reminders", "error", err)
+		return
+	}
+	metrics.RemindersPending.Set(float64(pending))
+}
+
+func (d *ReminderDispatcher) dispatch(reminder common.Reminder) {
+	logger := d.log.With(
+		config.LogKeyCorrelationID, reminder.CorrelationID,
+		"reminder_id", reminder.UUID,
+		"appointment_id", reminder.AppointmentID,
+		"user_id", reminder.ChatID,
+	)
+	service := d.service.forRequest(reminder.CorrelationID, logger)
+
+	// Напоминание могло пережить отмену записи, если его не удалось снять сразу
+	appointment, err := service.GetAppointmentByID(reminder.AppointmentID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		// Аренда истечёт сама, и напоминание будет проверено повторно
+		logger.Error("error getting appointment for reminder", "error", err)
+		return
+	}
+	if err != nil || appointment.Status != "scheduled" {
+		status := "deleted"
+		if appointment != nil {
+			status = appointment.Status
+		}
+		if err := service.MarkReminderCancelled(reminder.UUID); err != nil {
+			logger.Error("error cancelling reminder", "error", err)
+			return
+		}
+		logger.Info("reminder cancelled, appointment is no longer scheduled", "status", status)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(reminder.ChatID, reminder.Message)
+	msg.ReplyMarkup = d.reminderKeyboard(reminder.ChatID, appointment.UUID.String())
+
+	if _, err := d.bot.Send(msg); err != nil {
+		logger.Error("error sending reminder", "attempt", reminder.Attempts+1, "error", err)
+
+		// Аренда истечёт сама, и напоминание будет отправлено повторно
+		if reminder.Attempts+1 >= reminderMaxAttempts {
+			if err := service.MarkReminderFailed(reminder.UUID); err != nil {
+				logger.Error("error marking reminder as failed", "error", err)
+			}
+		}
+		return
+	}
+
+	if err := service.MarkReminderSent(reminder.UUID); err != nil {
+		logger.Error("error marking reminder as sent", "error", err)
+		return
+	}
+	logger.Info("reminder sent")
+}
+
+func (d *ReminderDispatcher) reminderKeyboard(chatID int64, appointmentID string) tgbotapi.InlineKeyboardMarkup {
+	return inlineKeyboard(
+		tgbotapi.NewInlineKeyboardRow(
+			newCallbackButton(d.callbacks, chatID, helper.GetText("confirm_visit_button"), "confirm_visit", appointmentID),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			newCallbackButton(d.callbacks, chatID, helper.GetText("reschedule_button"), "reschedule", appointmentID),
+			newCallbackButton(d.callbacks, chatID, helper.GetText("cancel_appointment_button"), "cancel", appointmentID),
+		),
+	)
+}
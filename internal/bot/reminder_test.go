@@ -0,0 +1,116 @@
+package bot
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RudinMaxim/BarberBot.git/common"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/google/uuid"
+)
+
+// fakeTelegram сервер Bot API, который запоминает вызванные методы
+type fakeTelegram struct {
+	mu      sync.Mutex
+	methods []string
+}
+
+func (f *fakeTelegram) bot(t *testing.T) *tgbotapi.BotAPI {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		if method == "getMe" {
+			fmt.Fprint(w, `{"ok":true,"result":{"id":1,"is_bot":true,"username":"test_bot"}}`)
+			return
+		}
+		f.mu.Lock()
+		f.methods = append(f.methods, method)
+		f.mu.Unlock()
+		fmt.Fprint(w, `{"ok":true,"result":{"message_id":1,"chat":{"id":1}}}`)
+	}))
+	t.Cleanup(server.Close)
+
+	bot, err := tgbotapi.NewBotAPIWithClient("token", server.URL+"/bot%s/%s", server.Client())
+	if err != nil {
+		t.Fatalf("create bot: %v", err)
+	}
+	return bot
+}
+
+func (f *fakeTelegram) calls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count := 0
+	for _, m := range f.methods {
+		if m == method {
+			count++
+		}
+	}
+	return count
+}
+
+func TestReminderDispatchSkipsInactiveAppointments(t *testing.T) {
+	appointmentID := uuid.New()
+	appointmentRow := func(status string) func(string) ([]string, [][]driver.Value, error) {
+		return func(query string) ([]string, [][]driver.Value, error) {
+			if strings.Contains(query, `FROM "appointments"`) {
+				return []string{"uuid", "status"}, [][]driver.Value{{appointmentID.String(), status}}, nil
+			}
+			return []string{"uuid"}, nil, nil
+		}
+	}
+
+	tests := []struct {
+		name       string
+		respond    func(string) ([]string, [][]driver.Value, error)
+		wantSent   bool
+		wantStatus string
+	}{
+		{"scheduled", appointmentRow("scheduled"), true, reminderStatusSent},
+		{"cancelled", appointmentRow("cancelled"), false, reminderStatusCancelled},
+		{"completed", appointmentRow("completed"), false, reminderStatusCancelled},
+		{"no show", appointmentRow("no_show"), false, reminderStatusCancelled},
+		{"deleted", func(string) ([]string, [][]driver.Value, error) { return []string{"uuid"}, nil, nil }, false, reminderStatusCancelled},
+		// Ошибка базы не повод снимать напоминание: оно вернётся после аренды
+		{"database error", func(string) ([]string, [][]driver.Value, error) { return nil, nil, errors.New("connection reset") }, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &recordingDB{respond: tt.respond, rowsAffected: 1}
+			telegram := &fakeTelegram{}
+			dispatcher := NewReminderDispatcher(NewService(db.repository(t), time.UTC, false), telegram.bot(t), NewCallbackCodec("secret"))
+
+			dispatcher.dispatch(common.Reminder{
+				UUID:          uuid.New(),
+				AppointmentID: appointmentID,
+				ChatID:        42,
+				Message:       "Напоминаем о записи",
+			})
+
+			if sent := telegram.calls("sendMessage") > 0; sent != tt.wantSent {
+				t.Errorf("reminder sent = %v, want %v", sent, tt.wantSent)
+			}
+			updates := db.execs(`UPDATE "reminders"`)
+			if tt.wantStatus == "" {
+				if len(updates) != 0 {
+					t.Errorf("reminder updated %v, want it left for the next attempt", updates[0].set())
+				}
+				return
+			}
+			if len(updates) != 1 {
+				t.Fatalf("got %d reminder updates, want 1", len(updates))
+			}
+			if status := updates[0].set()["status"]; status != tt.wantStatus {
+				t.Errorf("reminder status = %v, want %s", status, tt.wantStatus)
+			}
+		})
+	}
+}
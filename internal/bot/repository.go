@@ -2,27 +2,73 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/RudinMaxim/BarberBot.git/common"
+	"github.com/RudinMaxim/BarberBot.git/config"
 	"github.com/RudinMaxim/BarberBot.git/database"
+	"github.com/RudinMaxim/BarberBot.git/internal/metrics"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Repository struct {
 	db    *gorm.DB
-	cache *database.RedisCache
+	cache database.Cache
+	log   *slog.Logger
 }
 
-func NewRepository(db *gorm.DB, cache *database.RedisCache) *Repository {
+func NewRepository(db *gorm.DB, cache database.Cache) *Repository {
 	return &Repository{
 		db:    db,
 		cache: cache,
+		log:   slog.Default(),
 	}
 }
 
+// withLogger возвращает копию репозитория с журналом запроса. Контекст с тем же
+// журналом получает кэш.
+func (r *Repository) withLogger(logger *slog.Logger) *Repository {
+	scoped := *r
+	scoped.log = logger
+	return &scoped
+}
+
+func (r *Repository) context() context.Context {
+	return config.WithLogger(context.Background(), r.log)
+}
+
+// readThrough читает key из кэша, а при промахе или недоступности кэша
+// загружает значение через load и сохраняет его на ttl. Ошибки записи в кэш
+// не возвращаются: без Redis репозиторий работает медленнее, но работает.
+func readThrough[T any](r *Repository, name string, key string, ttl time.Duration, load func(*T) error) (T, error) {
+	ctx := r.context()
+
+	var value T
+	err := r.cache.Get(ctx, key, &value)
+	metrics.ObserveCache(name, err)
+	if err == nil {
+		return value, nil
+	}
+
+	// Get мог частично заполнить value до ошибки декодирования
+	var zero T
+	value = zero
+	if err := load(&value); err != nil {
+		return zero, err
+	}
+
+	if err := r.cache.Set(ctx, key, value, ttl); err != nil {
+		r.log.Warn("failed to cache data", "key", key, "error", err)
+	}
+	return value, nil
+}
+
 // ===============Client===================
 
 func (r *Repository) CreateClient(client *common.Client) (*common.Client, error) {
@@ -31,36 +77,80 @@ func (r *Repository) CreateClient(client *common.Client) (*common.Client, error)
 }
 
 func (r *Repository) GetClientBy(field string, value interface{}) (*common.Client, error) {
-	ctx := context.Background()
 	cacheKey := fmt.Sprintf("client:%s:%v", field, value)
 
-	var client common.Client
-
-	// Попытка получить данные из кэша
-	err := r.cache.Get(ctx, cacheKey, &client)
-	if err == nil {
-		return &client, nil
-	}
-
-	// Запрос к базе данных, если данных нет в кэше
-	err = r.db.Where(fmt.Sprintf("%s = ?", field), value).First(&client).Error
+	client, err := readThrough(r, "client", cacheKey, time.Hour, func(client *common.Client) error {
+		return r.db.Where(fmt.Sprintf("%s = ?", field), value).First(client).Error
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	// Сохраняем результат в кэш на 1 час
-	cacheDuration := time.Hour
-	if err = r.cache.Set(ctx, cacheKey, client, cacheDuration); err != nil {
-		return nil, fmt.Errorf("failed to cache data: %w", err)
-	}
-
 	return &client, nil
 }
 
 // ===============Appointment===================
 
-func (r *Repository) CreateAppointment(appointment *common.Appointment) error {
-	return r.db.Create(appointment).Error
+// exclusionViolation код ошибки Postgres при нарушении ограничения appointments_no_overlap
+const exclusionViolation = "23P01"
+
+// CreateAppointment сохраняет запись в транзакции, повторно проверяя, что время свободно.
+// Гонку между параллельными транзакциями отсекает ограничение в базе.
+// ops попадают в очередь календаря в той же транзакции.
+func (r *Repository) CreateAppointment(appointment *common.Appointment, ops ...common.CalendarOperation) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkAppointmentOverlap(tx, appointment.UUID, appointment.StartTime, appointment.EndTime); err != nil {
+			return err
+		}
+		if err := tx.Create(appointment).Error; err != nil {
+			return err
+		}
+		return enqueueCalendarOperations(tx, ops)
+	})
+	return translateAppointmentError(err)
+}
+
+// RescheduleAppointment переносит запись на новое время с той же проверкой пересечений
+func (r *Repository) RescheduleAppointment(appointment *common.Appointment, ops ...common.CalendarOperation) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkAppointmentOverlap(tx, appointment.UUID, appointment.StartTime, appointment.EndTime); err != nil {
+			return err
+		}
+		err := tx.Model(&common.Appointment{}).
+			Where("uuid = ?", appointment.UUID).
+			Updates(map[string]interface{}{
+				"start_time": appointment.StartTime,
+				"end_time":   appointment.EndTime,
+				"updated_at": time.Now(),
+			}).Error
+		if err != nil {
+			return err
+		}
+		return enqueueCalendarOperations(tx, ops)
+	})
+	return translateAppointmentError(err)
+}
+
+func checkAppointmentOverlap(tx *gorm.DB, appointmentID uuid.UUID, start, end time.Time) error {
+	var count int64
+	err := tx.Model(&common.Appointment{}).
+		Where("status <> ? AND start_time < ? AND end_time > ?", "cancelled", end, start).
+		Where("uuid <> ?", appointmentID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.ErrSlotTaken
+	}
+	return nil
+}
+
+func translateAppointmentError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == exclusionViolation {
+		return common.ErrSlotTaken
+	}
+	return err
 }
 
 func (r *Repository) GetAppointmentByID(appointmentID uuid.UUID) (*common.Appointment, error) {
@@ -78,14 +168,48 @@ func (r *Repository) GetAppointmentsByClientID(clientID uuid.UUID) ([]common.App
 	return appointments, err
 }
 
-func (r *Repository) UpdateAppointment(appointment *common.Appointment) error {
-	return r.db.Save(appointment).Error
+func (r *Repository) UpdateAppointment(appointment *common.Appointment, ops ...common.CalendarOperation) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(appointment).Error; err != nil {
+			return err
+		}
+		return enqueueCalendarOperations(tx, ops)
+	})
 }
 
-func (r *Repository) GetAppointmentsForDate(date time.Time) ([]common.Appointment, error) {
+// GetAppointmentsForDate возвращает записи, начинающиеся в [dayStart, dayEnd).
+// Границы дня считает вызывающий: в дни перевода часов сутки не 24 часа.
+func (r *Repository) GetAppointmentsForDate(dayStart, dayEnd time.Time) ([]common.Appointment, error) {
 	var appointments []common.Appointment
 	err := r.db.Preload("Services").
-		Where("start_time BETWEEN ? AND ?", date, date.Add(24*time.Hour)).
+		Where("start_time >= ? AND start_time < ?", dayStart, dayEnd).
+		Order("start_time").
+		Find(&appointments).Error
+	return appointments, err
+}
+
+// GetAppointmentsByCalendarEvents находит записи, которым принадлежат события календаря:
+// по сохранённому ID события или по ID записи, из которого выведен ID события
+func (r *Repository) GetAppointmentsByCalendarEvents(eventIDs []string, appointmentIDs []uuid.UUID) ([]common.Appointment, error) {
+	var appointments []common.Appointment
+	if len(eventIDs) == 0 {
+		return appointments, nil
+	}
+	query := r.db.Preload("Services").Where("calendar_event_id IN ?", eventIDs)
+	if len(appointmentIDs) > 0 {
+		query = query.Or("uuid IN ?", appointmentIDs)
+	}
+	err := query.Find(&appointments).Error
+	return appointments, err
+}
+
+// GetScheduledAppointmentsWithEvents возвращает действующие записи с событием в календаре,
+// начинающиеся в интервале [from, to)
+func (r *Repository) GetScheduledAppointmentsWithEvents(from, to time.Time) ([]common.Appointment, error) {
+	var appointments []common.Appointment
+	err := r.db.Preload("Services").
+		Where("status = ? AND calendar_event_id <> '' AND start_time >= ? AND start_time < ?", "scheduled", from, to).
+		Order("start_time").
 		Find(&appointments).Error
 	return appointments, err
 }
@@ -99,84 +223,108 @@ func (r *Repository) GetScheduledAppointmentsByClientID(clientID uuid.UUID) ([]c
 // ===============Service===================
 
 func (r *Repository) GetServiceByID(serviceID uuid.UUID) (common.Service, error) {
-	ctx := context.Background()
 	cacheKey := fmt.Sprintf("service:%s", serviceID)
 
-	var service common.Service
+	return readThrough(r, "service", cacheKey, time.Hour, func(service *common.Service) error {
+		return r.db.First(service, serviceID).Error
+	})
+}
 
-	// Попытка получить данные из кэша
-	err := r.cache.Get(ctx, cacheKey, &service)
-	if err == nil {
-		return service, nil
-	}
+func (r *Repository) GetActiveServices() ([]common.Service, error) {
+	return readThrough(r, "active_services", "active_services", time.Hour, func(services *[]common.Service) error {
+		return r.db.Where("is_active = ?", true).Order("sort_order, name").Find(services).Error
+	})
+}
 
-	// Запрос к базе данных, если данных нет в кэше
-	err = r.db.First(&service, serviceID).Error
-	if err != nil {
-		return common.Service{}, err
-	}
+func (r *Repository) GetServicesByIDs(serviceIDs []uuid.UUID) ([]common.Service, error) {
+	cacheKey := fmt.Sprintf("services:ids:%v", serviceIDs)
 
-	// Сохраняем результат в кэш на 1 час
-	cacheDuration := time.Hour
-	if err = r.cache.Set(ctx, cacheKey, service, cacheDuration); err != nil {
-		return common.Service{}, fmt.Errorf("failed to cache data: %w", err)
-	}
+	return readThrough(r, "services_by_ids", cacheKey, time.Hour, func(services *[]common.Service) error {
+		return r.db.Where("uuid IN ?", serviceIDs).Find(services).Error
+	})
+}
 
-	return service, nil
+func (r *Repository) GetAllServices() ([]common.Service, error) {
+	var services []common.Service
+	err := r.db.Order("sort_order, name").Find(&services).Error
+	return services, err
 }
 
-func (r *Repository) GetActiveServices() ([]common.Service, error) {
-	ctx := context.Background()
-	cacheKey := "active_services"
+func (r *Repository) GetMaxServiceSortOrder() (int, error) {
+	var maxOrder int
+	err := r.db.Model(&common.Service{}).Select("COALESCE(MAX(sort_order), 0)").Scan(&maxOrder).Error
+	return maxOrder, err
+}
 
-	var services []common.Service
+func (r *Repository) CreateService(service *common.Service) error {
+	if err := r.db.Create(service).Error; err != nil {
+		return err
+	}
+	r.invalidateServiceCache(service.UUID)
+	return nil
+}
 
-	// Попытка получить данные из кэша
-	err := r.cache.Get(ctx, cacheKey, &services)
-	if err == nil {
-		return services, nil
+func (r *Repository) UpdateService(service *common.Service) error {
+	if err := r.db.Save(service).Error; err != nil {
+		return err
 	}
+	r.invalidateServiceCache(service.UUID)
+	return nil
+}
 
-	// Запрос к базе данных, если данных нет в кэше
-	err = r.db.Where("is_active = ?", true).Find(&services).Error
+// UpdateServicesOrder сохраняет позиции услуг в каталоге одной транзакцией.
+func (r *Repository) UpdateServicesOrder(services []common.Service) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		for _, service := range services {
+			if err := tx.Model(&common.Service{}).
+				Where("uuid = ?", service.UUID).
+				Update("sort_order", service.SortOrder).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Сохраняем результат в кэш на 1 час
-	cacheDuration := time.Hour
-	if err = r.cache.Set(ctx, cacheKey, services, cacheDuration); err != nil {
-		return nil, fmt.Errorf("failed to cache data: %w", err)
+	ids := make([]uuid.UUID, 0, len(services))
+	for _, service := range services {
+		ids = append(ids, service.UUID)
 	}
+	r.invalidateServiceCache(ids...)
+	return nil
+}
 
-	return services, nil
+// FlushCache сбрасывает кэши услуг и расписания. Вызывается, когда Redis снова
+// доступен: инвалидации, пропущенные за время сбоя, могли оставить в нём
+// устаревшие данные.
+func (r *Repository) FlushCache() {
+	r.invalidateServiceCache()
+	if err := r.cache.DeleteByPattern(r.context(), "service:*"); err != nil {
+		r.log.Error("error invalidating cache keys service:*", "error", err)
+	}
+	r.invalidateWorkingHoursCache()
+	r.log.Info("cache flushed after outage")
 }
 
-func (r *Repository) GetServicesByIDs(serviceIDs []uuid.UUID) ([]common.Service, error) {
-	ctx := context.Background()
-	cacheKey := fmt.Sprintf("services:ids:%v", serviceIDs)
+// invalidateServiceCache сбрасывает все кэши, в которые могла попасть услуга.
+func (r *Repository) invalidateServiceCache(serviceIDs ...uuid.UUID) {
+	ctx := r.context()
 
-	var services []common.Service
-
-	// Попытка получить данные из кэша
-	err := r.cache.Get(ctx, cacheKey, &services)
-	if err == nil {
-		return services, nil
+	keys := []string{"active_services"}
+	for _, id := range serviceIDs {
+		keys = append(keys, fmt.Sprintf("service:%s", id))
 	}
 
-	// Запрос к базе данных, если данных нет в кэше
-	err = r.db.Where("uuid IN ?", serviceIDs).Find(&services).Error
-	if err != nil {
-		return nil, err
+	for _, key := range keys {
+		if err := r.cache.Delete(ctx, key); err != nil {
+			r.log.Error("error invalidating cache key", "key", key, "error", err)
+		}
 	}
-
-	// Сохраняем результат в кэш на 1 час
-	cacheDuration := time.Hour
-	if err = r.cache.Set(ctx, cacheKey, services, cacheDuration); err != nil {
-		return nil, fmt.Errorf("failed to cache data: %w", err)
+	if err := r.cache.DeleteByPattern(ctx, "services:ids:*"); err != nil {
+		r.log.Error("error invalidating cache keys services:ids:*", "error", err)
 	}
-
-	return services, nil
 }
 
 // ===============WorkingHours===================
@@ -188,51 +336,129 @@ func (r *Repository) GetWorkingHoursByDayOfWeek(dayOfWeek int) (*common.WorkingH
 }
 
 func (r *Repository) GetWorkingHoursAvailableDates() ([]common.WorkingHours, error) {
-	ctx := context.Background()
-	cacheKey := "working_hours:available"
+	return readThrough(r, "working_hours", "working_hours:available", 24*time.Hour, func(workingHours *[]common.WorkingHours) error {
+		return r.db.Where("is_active = ?", true).Find(workingHours).Error
+	})
+}
 
-	var workingHours []common.WorkingHours
+func (r *Repository) GetWorkingHours() ([]common.WorkingHours, error) {
+	return readThrough(r, "working_hours", "working_hours:all", 24*time.Hour, func(workingHours *[]common.WorkingHours) error {
+		return r.db.Order("day_of_week").Find(workingHours).Error
+	})
+}
 
-	err := r.cache.Get(ctx, cacheKey, &workingHours)
-	if err == nil {
-		return workingHours, nil
+// SaveWorkingHours создаёт или обновляет часы работы на день недели.
+func (r *Repository) SaveWorkingHours(dayOfWeek int, startTime, endTime time.Time, isActive bool) error {
+	var workingHours common.WorkingHours
+	err := r.db.Where("day_of_week = ?", dayOfWeek).
+		Assign(map[string]interface{}{
+			"start_time": startTime,
+			"end_time":   endTime,
+			"is_active":  isActive,
+		}).
+		FirstOrCreate(&workingHours, common.WorkingHours{DayOfWeek: dayOfWeek}).Error
+	if err != nil {
+		return err
 	}
 
-	err = r.db.Where("is_active = ?", true).Find(&workingHours).Error
+	r.invalidateWorkingHoursCache()
+	return nil
+}
+
+func (r *Repository) GetScheduleExceptions(from, to time.Time) ([]common.ScheduleException, error) {
+	cacheKey := fmt.Sprintf("working_hours:exceptions:%s:%s", from.Format("2006-01-02"), to.Format("2006-01-02"))
+
+	return readThrough(r, "schedule_exceptions", cacheKey, 24*time.Hour, func(exceptions *[]common.ScheduleException) error {
+		return r.db.Where("date BETWEEN ? AND ?", from.Format("2006-01-02"), to.Format("2006-01-02")).
+			Order("date").
+			Find(exceptions).Error
+	})
+}
+
+// SaveScheduleException заменяет исключение на ту же дату, если оно уже было.
+func (r *Repository) SaveScheduleException(exception *common.ScheduleException) error {
+	err := r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"is_closed", "start_time", "end_time", "reason", "updated_at"}),
+	}).Create(exception).Error
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	cacheDuration := 24 * time.Hour
-	if err = r.cache.Set(ctx, cacheKey, workingHours, cacheDuration); err != nil {
-		return nil, fmt.Errorf("failed to cache working hours: %w", err)
+	r.invalidateWorkingHoursCache()
+	return nil
+}
+
+func (r *Repository) DeleteScheduleException(exceptionID uuid.UUID) error {
+	if err := r.db.Delete(&common.ScheduleException{}, "uuid = ?", exceptionID).Error; err != nil {
+		return err
 	}
 
-	return workingHours, nil
+	r.invalidateWorkingHoursCache()
+	return nil
 }
 
-func (r *Repository) GetWorkingHours() ([]common.WorkingHours, error) {
-	ctx := context.Background()
-	cacheKey := "working_hours:all"
-
-	var workingHours common.WorkingHours
+func (r *Repository) GetBreaks() ([]common.Break, error) {
+	return readThrough(r, "breaks", "working_hours:breaks", 24*time.Hour, func(breaks *[]common.Break) error {
+		return r.db.Order("day_of_week, start_time").Find(breaks).Error
+	})
+}
 
-	err := r.cache.Get(ctx, cacheKey, &workingHours)
-	if err == nil {
-		return []common.WorkingHours{workingHours}, nil
+func (r *Repository) CreateBreak(b *common.Break) error {
+	if err := r.db.Create(b).Error; err != nil {
+		return err
 	}
 
-	err = r.db.Find(&workingHours).Error
-	if err != nil {
-		return nil, err
-	}
+	r.invalidateWorkingHoursCache()
+	return nil
+}
 
-	cacheDuration := 24 * time.Hour
-	if err = r.cache.Set(ctx, cacheKey, workingHours, cacheDuration); err != nil {
-		return nil, fmt.Errorf("failed to cache working hours: %w", err)
+func (r *Repository) DeleteBreak(breakID uuid.UUID) error {
+	if err := r.db.Delete(&common.Break{}, "uuid = ?", breakID).Error; err != nil {
+		return err
 	}
 
-	return []common.WorkingHours{workingHours}, nil
+	r.invalidateWorkingHoursCache()
+	return nil
+}
+
+// GetBlockedRanges возвращает блокировки, пересекающиеся с интервалом [from, to)
+func (r *Repository) GetBlockedRanges(from, to time.Time) ([]common.BlockedRange, error) {
+	var blocked []common.BlockedRange
+	err := r.db.Where("start_time < ? AND end_time > ?", to, from).
+		Order("start_time").
+		Find(&blocked).Error
+	return blocked, err
+}
+
+func (r *Repository) CreateBlockedRange(blocked *common.BlockedRange) error {
+	return r.db.Create(blocked).Error
+}
+
+func (r *Repository) DeleteBlockedRange(blockedID uuid.UUID) error {
+	return r.db.Delete(&common.BlockedRange{}, "uuid = ?", blockedID).Error
+}
+
+// ReplaceCalendarBlocks заменяет блокировки из календаря, пересекающие [from, to),
+// новым набором. Блокировки мастера не трогает.
+func (r *Repository) ReplaceCalendarBlocks(from, to time.Time, blocks []common.BlockedRange) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("source = ? AND start_time < ? AND end_time > ?", common.BlockSourceCalendar, to, from).
+			Delete(&common.BlockedRange{}).Error
+		if err != nil {
+			return err
+		}
+		if len(blocks) == 0 {
+			return nil
+		}
+		return tx.Create(&blocks).Error
+	})
+}
+
+func (r *Repository) invalidateWorkingHoursCache() {
+	if err := r.cache.DeleteByPattern(r.context(), "working_hours:*"); err != nil {
+		r.log.Error("error invalidating cache keys working_hours:*", "error", err)
+	}
 }
 
 func (r *Repository) SaveCalendarEventID(appointmentID uuid.UUID, eventID string) error {
@@ -241,15 +467,162 @@ func (r *Repository) SaveCalendarEventID(appointmentID uuid.UUID, eventID string
 		Update("calendar_event_id", eventID).Error
 }
 
-func (r *Repository) GetCalendarEventID(appointmentID uuid.UUID) (string, error) {
-	var appointment common.Appointment
-	result := r.db.Select("calendar_event_id").
-		Where("uuid = ?", appointmentID).
-		First(&appointment)
+// ===============Calendar outbox===================
 
+func enqueueCalendarOperations(tx *gorm.DB, ops []common.CalendarOperation) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	return tx.Create(&ops).Error
+}
+
+// ClaimDueCalendarOperations выбирает операции, время попытки которых наступило, и берёт
+// их в аренду на lease. Порядок по времени создания сохраняет очерёдность операций записи.
+func (r *Repository) ClaimDueCalendarOperations(now time.Time, lease time.Duration, limit int) ([]common.CalendarOperation, error) {
+	var ops []common.CalendarOperation
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", calendarOpStatusPending, now).
+			Where("locked_until IS NULL OR locked_until < ?", now).
+			Order("created_at").
+			Limit(limit).
+			Find(&ops).Error
+		if err != nil || len(ops) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, 0, len(ops))
+		for _, op := range ops {
+			ids = append(ids, op.UUID)
+		}
+
+		return tx.Model(&common.CalendarOperation{}).
+			Where("uuid IN ?", ids).
+			Updates(map[string]interface{}{
+				"locked_until": now.Add(lease),
+				"attempts":     gorm.Expr("attempts + 1"),
+			}).Error
+	})
+
+	return ops, err
+}
+
+// UpdateCalendarOperation снимает аренду и записывает итог попытки
+func (r *Repository) UpdateCalendarOperation(opID uuid.UUID, status string, nextAttemptAt time.Time, lastError string) error {
+	return r.db.Model(&common.CalendarOperation{}).
+		Where("uuid = ?", opID).
+		Updates(map[string]interface{}{
+			"status":          status,
+			"next_attempt_at": nextAttemptAt,
+			"last_error":      lastError,
+			"locked_until":    nil,
+			"updated_at":      time.Now(),
+		}).Error
+}
+
+// RequeueCalendarOperation возвращает операцию из списка неудавшихся в очередь с нуля попыток
+func (r *Repository) RequeueCalendarOperation(opID uuid.UUID, now time.Time) error {
+	result := r.db.Model(&common.CalendarOperation{}).
+		Where("uuid = ? AND status = ?", opID, calendarOpStatusDead).
+		Updates(map[string]interface{}{
+			"status":          calendarOpStatusPending,
+			"attempts":        0,
+			"next_attempt_at": now,
+			"updated_at":      now,
+		})
 	if result.Error != nil {
-		return "", result.Error
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
 	}
+	return nil
+}
+
+func (r *Repository) GetDeadCalendarOperations(limit int) ([]common.CalendarOperation, error) {
+	var ops []common.CalendarOperation
+	err := r.db.Where("status = ?", calendarOpStatusDead).
+		Order("updated_at DESC").
+		Limit(limit).
+		Find(&ops).Error
+	return ops, err
+}
+
+// DeleteCalendarOperations удаляет операции в статусе status, обновлённые раньше before
+func (r *Repository) DeleteCalendarOperations(status string, before time.Time) (int64, error) {
+	result := r.db.Where("status = ? AND updated_at < ?", status, before).
+		Delete(&common.CalendarOperation{})
+	return result.RowsAffected, result.Error
+}
+
+func (r *Repository) CountCalendarOperations(status string) (int64, error) {
+	var count int64
+	err := r.db.Model(&common.CalendarOperation{}).
+		Where("status = ?", status).
+		Count(&count).Error
+	return count, err
+}
+
+// ===============Reminder===================
+
+func (r *Repository) CreateReminder(reminder *common.Reminder) error {
+	return r.db.Create(reminder).Error
+}
+
+// ClaimDueReminders выбирает наступившие напоминания и берёт их в аренду на lease,
+// чтобы другой экземпляр бота не отправил их повторно.
+func (r *Repository) ClaimDueReminders(now time.Time, lease time.Duration, limit int) ([]common.Reminder, error) {
+	var reminders []common.Reminder
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND notify_at <= ?", reminderStatusPending, now).
+			Where("locked_until IS NULL OR locked_until < ?", now).
+			Order("notify_at").
+			Limit(limit).
+			Find(&reminders).Error
+		if err != nil || len(reminders) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, 0, len(reminders))
+		for _, reminder := range reminders {
+			ids = append(ids, reminder.UUID)
+		}
+
+		lockedUntil := now.Add(lease)
+		return tx.Model(&common.Reminder{}).
+			Where("uuid IN ?", ids).
+			Updates(map[string]interface{}{
+				"locked_until": lockedUntil,
+				"attempts":     gorm.Expr("attempts + 1"),
+			}).Error
+	})
+
+	return reminders, err
+}
+
+func (r *Repository) UpdateReminderStatus(reminderID uuid.UUID, status string, sentAt *time.Time) error {
+	return r.db.Model(&common.Reminder{}).
+		Where("uuid = ?", reminderID).
+		Updates(map[string]interface{}{
+			"status":       status,
+			"sent_at":      sentAt,
+			"locked_until": nil,
+		}).Error
+}
+
+func (r *Repository) CountPendingReminders() (int64, error) {
+	var count int64
+	err := r.db.Model(&common.Reminder{}).
+		Where("status = ?", reminderStatusPending).
+		Count(&count).Error
+	return count, err
+}
 
-	return appointment.CalendarEventID, nil
+func (r *Repository) CancelReminders(appointmentID uuid.UUID) error {
+	return r.db.Model(&common.Reminder{}).
+		Where("appointment_id = ? AND status = ?", appointmentID, reminderStatusPending).
+		Update("status", reminderStatusCancelled).Error
 }
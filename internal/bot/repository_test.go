@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RudinMaxim/BarberBot.git/database"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// recordingDB база, которая записывает запросы и отвечает заданными строками.
+// respond, если задан, выбирает ответ по тексту запроса.
+type recordingDB struct {
+	columns      []string
+	rows         [][]driver.Value
+	respond      func(query string) ([]string, [][]driver.Value, error)
+	rowsAffected int64
+	statements   []recordedStatement
+	committed    bool
+}
+
+type recordedStatement struct {
+	query string
+	args  []driver.Value
+}
+
+var setColumn = regexp.MustCompile(`"(\w+)"=\$(\d+)`)
+
+// set возвращает значения, которые запрос присваивает колонкам в SET
+func (s recordedStatement) set() map[string]driver.Value {
+	values := make(map[string]driver.Value)
+	for _, m := range setColumn.FindAllStringSubmatch(s.query, -1) {
+		n, _ := strconv.Atoi(m[2])
+		values[m[1]] = s.args[n-1]
+	}
+	return values
+}
+
+func (db *recordingDB) repository(t *testing.T) *Repository {
+	t.Helper()
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(db)}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open gorm: %v", err)
+	}
+	return NewRepository(gormDB, missCache{})
+}
+
+func (db *recordingDB) execs(prefix string) []recordedStatement {
+	var found []recordedStatement
+	for _, s := range db.statements {
+		if strings.HasPrefix(s.query, prefix) {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+func (db *recordingDB) Connect(context.Context) (driver.Conn, error) { return recordingConn{db}, nil }
+func (db *recordingDB) Driver() driver.Driver                        { return nil }
+
+type recordingConn struct {
+	db *recordingDB
+}
+
+func (c recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("unexpected prepare: %s", query)
+}
+func (c recordingConn) Close() error              { return nil }
+func (c recordingConn) Begin() (driver.Tx, error) { return recordingTx{c.db}, nil }
+
+func (c recordingConn) record(query string, args []driver.NamedValue) {
+	values := make([]driver.Value, 0, len(args))
+	for _, arg := range args {
+		values = append(values, arg.Value)
+	}
+	c.db.statements = append(c.db.statements, recordedStatement{query: query, args: values})
+}
+
+func (c recordingConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.record(query, args)
+	return driver.RowsAffected(c.db.rowsAffected), nil
+}
+
+func (c recordingConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.record(query, args)
+	if c.db.respond != nil {
+		columns, rows, err := c.db.respond(query)
+		if err != nil {
+			return nil, err
+		}
+		return &recordingRows{columns: columns, rows: rows}, nil
+	}
+	return &recordingRows{columns: c.db.columns, rows: c.db.rows}, nil
+}
+
+type recordingTx struct {
+	db *recordingDB
+}
+
+func (tx recordingTx) Commit() error   { tx.db.committed = true; return nil }
+func (tx recordingTx) Rollback() error { return nil }
+
+type recordingRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *recordingRows) Columns() []string { return r.columns }
+func (r *recordingRows) Close() error      { return nil }
+func (r *recordingRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// missCache кэш, в котором ничего нет: все чтения идут в базу
+type missCache struct{}
+
+func (missCache) Get(context.Context, string, interface{}) error { return database.ErrCacheMiss }
+func (missCache) Set(context.Context, string, interface{}, time.Duration) error {
+	return nil
+}
+func (missCache) Delete(context.Context, string) error          { return nil }
+func (missCache) DeleteByPattern(context.Context, string) error { return nil }
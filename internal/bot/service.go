@@ -3,32 +3,75 @@ package bot
 import (
 	"errors"
 	"fmt"
-	"log"
-	"sync"
+	"log/slog"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/RudinMaxim/BarberBot.git/common"
+	"github.com/RudinMaxim/BarberBot.git/internal/calendar"
+	"github.com/RudinMaxim/BarberBot.git/internal/metrics"
 	"github.com/google/uuid"
 )
 
 type Service struct {
-	repo        *Repository
-	bookingData map[int64]*BookingData
-	mutex       sync.RWMutex
+	repo *Repository
+	// location часовой пояс салона: в нём считаются даты, рабочие часы и слоты
+	location *time.Location
+	// calendarEnabled изменения записей ставят операции в очередь календаря
+	calendarEnabled bool
+	// correlationID и log относятся к текущему обновлению, см. forRequest
+	correlationID string
+	log           *slog.Logger
 }
 
-type BookingData struct {
-	ServiceID uuid.UUID
-	Date      time.Time
-}
-
-func NewService(repo *Repository) *Service {
+func NewService(repo *Repository, location *time.Location, calendarEnabled bool) *Service {
 	return &Service{
-		repo:        repo,
-		bookingData: make(map[int64]*BookingData),
+		repo:            repo,
+		location:        location,
+		calendarEnabled: calendarEnabled,
+		log:             slog.Default(),
 	}
 }
 
+// forRequest возвращает копию сервиса, которая пишет в журнал запроса и помечает
+// создаваемые напоминания и операции календаря его идентификатором корреляции.
+func (s *Service) forRequest(correlationID string, logger *slog.Logger) *Service {
+	scoped := *s
+	scoped.repo = s.repo.withLogger(logger)
+	scoped.correlationID = correlationID
+	scoped.log = logger
+	return &scoped
+}
+
+// slotStep шаг сетки слотов, которые предлагаются клиенту
+const slotStep = 30 * time.Minute
+
+// dayStart возвращает полночь календарного дня салона, на который приходится t
+func (s *Service) dayStart(t time.Time) time.Time {
+	t = t.In(s.location)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.location)
+}
+
+// dayBounds возвращает начало календарного дня салона, на который приходится t, и начало следующего.
+// В дни перехода на летнее и зимнее время между ними 23 и 25 часов.
+func (s *Service) dayBounds(t time.Time) (time.Time, time.Time) {
+	start := s.dayStart(t)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// slotStart разбирает выбранное клиентом время слота (15:04) в календарном дне салона, на который приходится date
+func (s *Service) slotStart(date time.Time, clock string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02 15:04", date.In(s.location).Format("2006-01-02")+" "+clock, s.location)
+}
+
+// atClock возвращает момент, когда на часах салона в день day показывает clock.
+// Сдвиг от полуночи не годится: в день перехода на летнее время сутки не 24 часа.
+func (s *Service) atClock(day, clock time.Time) time.Time {
+	day = day.In(s.location)
+	return time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, s.location)
+}
+
 // ================Client==================
 
 func (s *Service) CreateClient(client *common.Client) (*common.Client, error) {
@@ -66,16 +109,171 @@ func (s *Service) GetServiceByID(serviceID uuid.UUID) (common.Service, error) {
 	return s.repo.GetServiceByID(serviceID)
 }
 
+// GetServicesByIDs возвращает услуги в порядке переданных идентификаторов
+func (s *Service) GetServicesByIDs(serviceIDs []uuid.UUID) ([]common.Service, error) {
+	services, err := s.repo.GetServicesByIDs(serviceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uuid.UUID]common.Service, len(services))
+	for _, service := range services {
+		byID[service.UUID] = service
+	}
+
+	ordered := make([]common.Service, 0, len(serviceIDs))
+	for _, id := range serviceIDs {
+		service, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("service %s not found", id)
+		}
+		ordered = append(ordered, service)
+	}
+	return ordered, nil
+}
+
+// summarizeServices собирает общее название, длительность и стоимость набора услуг
+func summarizeServices(services []common.Service) (string, int, float64) {
+	names := make([]string, 0, len(services))
+	duration := 0
+	price := 0.0
+	for _, service := range services {
+		names = append(names, service.Name)
+		duration += service.Duration
+		price += service.Price
+	}
+	return strings.Join(names, " + "), duration, price
+}
+
 func (s *Service) GetActiveServices() ([]common.Service, error) {
 	return s.repo.GetActiveServices()
 }
 
+func (s *Service) GetAllServices() ([]common.Service, error) {
+	return s.repo.GetAllServices()
+}
+
+func (s *Service) CreateService(name string, duration int, price float64) (*common.Service, error) {
+	if err := validateService(name, duration, price); err != nil {
+		return nil, err
+	}
+
+	maxOrder, err := s.repo.GetMaxServiceSortOrder()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sort order: %w", err)
+	}
+
+	service := &common.Service{
+		Name:      name,
+		Duration:  duration,
+		Price:     price,
+		SortOrder: maxOrder + 1,
+		IsActive:  true,
+	}
+
+	if err := s.repo.CreateService(service); err != nil {
+		return nil, fmt.Errorf("failed to create service: %w", err)
+	}
+
+	return service, nil
+}
+
+func (s *Service) UpdateService(service *common.Service) error {
+	if err := validateService(service.Name, service.Duration, service.Price); err != nil {
+		return err
+	}
+
+	service.UpdatedAt = time.Now()
+	return s.repo.UpdateService(service)
+}
+
+func (s *Service) ToggleService(serviceID uuid.UUID) (*common.Service, error) {
+	service, err := s.repo.GetServiceByID(serviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service: %w", err)
+	}
+
+	service.IsActive = !service.IsActive
+	service.UpdatedAt = time.Now()
+
+	if err := s.repo.UpdateService(&service); err != nil {
+		return nil, fmt.Errorf("failed to update service: %w", err)
+	}
+
+	return &service, nil
+}
+
+// MoveService сдвигает услугу на одну позицию вверх (step = -1) или вниз (step = 1).
+func (s *Service) MoveService(serviceID uuid.UUID, step int) error {
+	services, err := s.repo.GetAllServices()
+	if err != nil {
+		return fmt.Errorf("failed to get services: %w", err)
+	}
+
+	index := -1
+	for i := range services {
+		if services[i].UUID == serviceID {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return errors.New("service not found")
+	}
+
+	target := index + step
+	if target < 0 || target >= len(services) {
+		return nil
+	}
+
+	services[index], services[target] = services[target], services[index]
+
+	// Перенумеровываем весь каталог: у старых услуг порядок мог быть не задан
+	for i := range services {
+		services[i].SortOrder = i + 1
+	}
+
+	return s.repo.UpdateServicesOrder(services)
+}
+
+func validateService(name string, duration int, price float64) error {
+	if name == "" {
+		return errors.New("service name is required")
+	}
+	if duration <= 0 {
+		return errors.New("service duration must be positive")
+	}
+	if price < 0 {
+		return errors.New("service price must not be negative")
+	}
+	return nil
+}
+
 // ===============Appointment==================
 
 func (s *Service) GetAppointmentByID(appointmentID uuid.UUID) (*common.Appointment, error) {
 	return s.repo.GetAppointmentByID(appointmentID)
 }
 
+// GetClientAppointment возвращает запись, только если она принадлежит клиенту с telegramID
+func (s *Service) GetClientAppointment(telegramID int64, appointmentID uuid.UUID) (*common.Appointment, error) {
+	client, err := s.GetClientBy("telegram_id", telegramID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client: %w", err)
+	}
+
+	appointment, err := s.repo.GetAppointmentByID(appointmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get appointment: %w", err)
+	}
+
+	if client == nil || appointment.ClientID != client.UUID {
+		return nil, errors.New("appointment does not belong to this client")
+	}
+
+	return appointment, nil
+}
+
 func (s *Service) CancelAppointment(telegramID int64, appointmentID uuid.UUID) error {
 	client, err := s.GetClientBy("telegram_id", telegramID)
 	if err != nil {
@@ -87,9 +285,8 @@ func (s *Service) CancelAppointment(telegramID int64, appointmentID uuid.UUID) e
 		return fmt.Errorf("failed to get appointment: %w", err)
 	}
 
-	// Add debug logging
-	log.Printf("Cancelling appointment: ID=%v, Status=%v, ClientID=%v",
-		appointment.UUID, appointment.Status, appointment.ClientID)
+	s.log.Debug("cancelling appointment",
+		"appointment_id", appointment.UUID, "status", appointment.Status, "client_id", appointment.ClientID)
 
 	if appointment.ClientID != client.UUID {
 		return errors.New("appointment does not belong to this client")
@@ -107,125 +304,267 @@ func (s *Service) CancelAppointment(telegramID int64, appointmentID uuid.UUID) e
 	appointment.CancelledAt = now
 	appointment.UpdatedAt = now
 
-	if err := s.repo.UpdateAppointment(appointment); err != nil {
+	if err := s.repo.UpdateAppointment(appointment, s.calendarOperation(common.CalendarOpDelete, appointment)...); err != nil {
 		return fmt.Errorf("failed to update appointment: %w", err)
 	}
+	metrics.Bookings.WithLabelValues(metrics.BookingCancelled).Inc()
 
 	return nil
 }
 
-func (s *Service) CreateAppointment(userID int64, timeStr string) (*common.Appointment, error) {
-	serviceID, err := s.getSelectedServiceForUser(userID)
+// ConfirmAppointmentVisit отмечает, что клиент подтвердил, что придёт на запись.
+func (s *Service) ConfirmAppointmentVisit(telegramID int64, appointmentID uuid.UUID) (*common.Appointment, error) {
+	client, err := s.GetClientBy("telegram_id", telegramID)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to get client: %w", err)
 	}
-	date, err := s.getSelectedDateForUser(userID)
+
+	appointment, err := s.repo.GetAppointmentByID(appointmentID)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to get appointment: %w", err)
+	}
+
+	if appointment.ClientID != client.UUID {
+		return nil, errors.New("appointment does not belong to this client")
+	}
+
+	if appointment.Status != "scheduled" {
+		return nil, errors.New("only scheduled appointments can be confirmed")
+	}
+
+	now := time.Now()
+	if appointment.StartTime.Before(now) {
+		return nil, errors.New("cannot confirm past appointments")
+	}
+
+	appointment.ConfirmedAt = now
+	appointment.UpdatedAt = now
+
+	// Подтверждённый визит выделяется в календаре мастера
+	if err := s.repo.UpdateAppointment(appointment, s.calendarOperation(common.CalendarOpUpsert, appointment)...); err != nil {
+		return nil, fmt.Errorf("failed to update appointment: %w", err)
+	}
+
+	return appointment, nil
+}
+
+func (s *Service) CreateAppointment(userID int64, serviceIDs []uuid.UUID, date time.Time, timeStr string) (*common.Appointment, error) {
+	if len(serviceIDs) == 0 {
+		return nil, errors.New("no services selected")
 	}
 
-	layout := "2006-01-02 15:04"
-	startTime, err := time.Parse(layout, fmt.Sprintf("%s %s", date.Format("2006-01-02"), timeStr))
+	startTime, err := s.slotStart(date, timeStr)
 	if err != nil {
 		return nil, err
 	}
 
-	service, err := s.GetServiceByID(serviceID)
+	services, err := s.GetServicesByIDs(serviceIDs)
 	if err != nil {
 		return nil, err
 	}
+	name, duration, price := summarizeServices(services)
+
+	endTime := startTime.Add(time.Duration(duration) * time.Minute)
+	if err := s.checkWorkingSlot(startTime, endTime); err != nil {
+		return nil, err
+	}
 
-	endTime := startTime.Add(time.Duration(service.Duration) * time.Minute)
 	client, err := s.GetClientBy("telegram_id", userID)
 	if err != nil {
 		return nil, err
 	}
 
+	// Перерывы и блокировки ограничением в базе не покрыты, проверяем их здесь
+	busy, err := s.busyRanges(startTime, uuid.Nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check slot availability: %w", err)
+	}
+	if !isRangeFree(startTime, endTime, busy) {
+		return nil, common.ErrSlotTaken
+	}
+
 	appointment := &common.Appointment{
+		// ID задаётся заранее: по нему строится ID события в календаре
+		UUID:       uuid.New(),
 		ClientID:   client.UUID,
 		StartTime:  startTime,
 		EndTime:    endTime,
-		Name:       service.Name,
-		TotalPrice: service.Price,
+		Name:       name,
+		TotalPrice: price,
 		Status:     "scheduled",
-		Services:   []common.Service{service},
+		Services:   services,
 	}
 
-	return appointment, s.repo.CreateAppointment(appointment)
+	if err := s.repo.CreateAppointment(appointment, s.calendarOperation(common.CalendarOpUpsert, appointment)...); err != nil {
+		return appointment, err
+	}
+	metrics.Bookings.WithLabelValues(metrics.BookingCreated).Inc()
+
+	return appointment, nil
 }
 
-func (s *Service) RescheduleAppointment(telegramID int64, appointmentID uuid.UUID, newDate time.Time, newTimeStr string) error {
+func (s *Service) RescheduleAppointment(telegramID int64, appointmentID uuid.UUID, newDate time.Time, newTimeStr string) (*common.Appointment, error) {
 	client, err := s.GetClientBy("telegram_id", telegramID)
 	if err != nil {
-		return fmt.Errorf("failed to get client: %w", err)
+		return nil, fmt.Errorf("failed to get client: %w", err)
 	}
 
 	appointment, err := s.repo.GetAppointmentByID(appointmentID)
 	if err != nil {
-		return fmt.Errorf("failed to get appointment: %w", err)
+		return nil, fmt.Errorf("failed to get appointment: %w", err)
 	}
 
 	if appointment.ClientID != client.UUID {
-		return errors.New("appointment does not belong to this client")
+		return nil, errors.New("appointment does not belong to this client")
 	}
 
 	if appointment.Status != "scheduled" {
-		return errors.New("only scheduled appointments can be rescheduled")
+		return nil, errors.New("only scheduled appointments can be rescheduled")
 	}
 
-	layout := "2006-01-02 15:04"
-	newStartTime, err := time.Parse(layout, fmt.Sprintf("%s %s", newDate.Format("2006-01-02"), newTimeStr))
+	newStartTime, err := s.slotStart(newDate, newTimeStr)
 	if err != nil {
-		return fmt.Errorf("invalid time format: %w", err)
-	}
-
-	if newStartTime.Before(time.Now()) {
-		return errors.New("cannot reschedule to a past time")
+		return nil, fmt.Errorf("invalid time format: %w", err)
 	}
 
 	serviceDuration := appointment.EndTime.Sub(appointment.StartTime)
 	newEndTime := newStartTime.Add(serviceDuration)
+	if err := s.checkWorkingSlot(newStartTime, newEndTime); err != nil {
+		return nil, err
+	}
 
-	appointments, err := s.repo.GetAppointmentsForDate(newDate)
+	busy, err := s.busyRanges(newStartTime, appointmentID)
 	if err != nil {
-		return fmt.Errorf("failed to check slot availability: %w", err)
+		return nil, fmt.Errorf("failed to check slot availability: %w", err)
 	}
 
-	filteredAppointments := make([]common.Appointment, 0)
-	for _, apt := range appointments {
-		if apt.UUID != appointmentID {
-			filteredAppointments = append(filteredAppointments, apt)
-		}
+	if !isRangeFree(newStartTime, newEndTime, busy) {
+		return nil, common.ErrSlotTaken
 	}
 
-	if !isSlotAvailable(newStartTime, int(serviceDuration.Minutes()), filteredAppointments) {
-		return errors.New("selected time slot is not available")
+	appointment.StartTime = newStartTime
+	appointment.EndTime = newEndTime
+
+	// Событие обновляется на месте, а не пересоздаётся: при сбое старое не теряется
+	if err := s.repo.RescheduleAppointment(appointment, s.calendarOperation(common.CalendarOpUpsert, appointment)...); err != nil {
+		if errors.Is(err, common.ErrSlotTaken) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to update appointment: %w", err)
 	}
+	metrics.Bookings.WithLabelValues(metrics.BookingRescheduled).Inc()
 
-	workingHours, err := s.repo.GetWorkingHoursByDayOfWeek(int(newStartTime.Weekday()))
+	return appointment, nil
+}
+
+// ===============Admin==================
+
+// GetScheduleForDate возвращает все записи на день вместе с клиентами для расписания мастера.
+func (s *Service) GetScheduleForDate(date time.Time) ([]common.Appointment, map[uuid.UUID]*common.Client, error) {
+	dayStart, dayEnd := s.dayBounds(date)
+	appointments, err := s.repo.GetAppointmentsForDate(dayStart, dayEnd)
 	if err != nil {
-		return fmt.Errorf("failed to get working hours: %w", err)
+		return nil, nil, fmt.Errorf("failed to get appointments: %w", err)
+	}
+
+	clients := make(map[uuid.UUID]*common.Client)
+	for _, appointment := range appointments {
+		if _, ok := clients[appointment.ClientID]; ok {
+			continue
+		}
+		client, err := s.GetClientBy("uuid", appointment.ClientID)
+		if err != nil {
+			s.log.Error("error getting client", "client_id", appointment.ClientID, "error", err)
+			continue
+		}
+		clients[appointment.ClientID] = client
 	}
 
-	workStart := time.Date(newStartTime.Year(), newStartTime.Month(), newStartTime.Day(),
-		workingHours.StartTime.Hour(), workingHours.StartTime.Minute(), 0, 0, newStartTime.Location())
-	workEnd := time.Date(newStartTime.Year(), newStartTime.Month(), newStartTime.Day(),
-		workingHours.EndTime.Hour(), workingHours.EndTime.Minute(), 0, 0, newStartTime.Location())
+	return appointments, clients, nil
+}
 
-	if newStartTime.Before(workStart) || newEndTime.After(workEnd) {
-		return errors.New("selected time is outside working hours")
+// SetAppointmentStatus переводит запланированную запись в итоговый статус (completed, no_show).
+func (s *Service) SetAppointmentStatus(appointmentID uuid.UUID, status string) (*common.Appointment, error) {
+	appointment, err := s.repo.GetAppointmentByID(appointmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get appointment: %w", err)
 	}
 
-	appointment.StartTime = newStartTime
-	appointment.EndTime = newEndTime
+	if appointment.Status != "scheduled" {
+		return nil, errors.New("only scheduled appointments can change status")
+	}
+
+	appointment.Status = status
 	appointment.UpdatedAt = time.Now()
-	appointment.Status = "scheduled"
 
 	if err := s.repo.UpdateAppointment(appointment); err != nil {
-		return fmt.Errorf("failed to update appointment: %w", err)
+		return nil, fmt.Errorf("failed to update appointment: %w", err)
 	}
 
-	return nil
+	return appointment, nil
+}
+
+// CancelAppointmentByMaster отменяет запись от имени мастера, без проверки владельца.
+func (s *Service) CancelAppointmentByMaster(appointmentID uuid.UUID) (*common.Appointment, *common.Client, error) {
+	appointment, err := s.repo.GetAppointmentByID(appointmentID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get appointment: %w", err)
+	}
+
+	if appointment.Status != "scheduled" {
+		return nil, nil, errors.New("only scheduled appointments can be cancelled")
+	}
+
+	client, err := s.GetClientBy("uuid", appointment.ClientID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get client: %w", err)
+	}
+
+	now := time.Now()
+	appointment.Status = "cancelled"
+	appointment.CancelledAt = now
+	appointment.UpdatedAt = now
+
+	if err := s.repo.UpdateAppointment(appointment, s.calendarOperation(common.CalendarOpDelete, appointment)...); err != nil {
+		return nil, nil, fmt.Errorf("failed to update appointment: %w", err)
+	}
+	metrics.Bookings.WithLabelValues(metrics.BookingCancelled).Inc()
+
+	return appointment, client, nil
+}
+
+// MoveAppointmentByMaster переносит запись на время, выбранное мастером в календаре.
+// Рабочие часы не проверяются, пересечение с другими записями проверяется.
+func (s *Service) MoveAppointmentByMaster(appointmentID uuid.UUID, startTime, endTime time.Time) (*common.Appointment, *common.Client, error) {
+	appointment, err := s.repo.GetAppointmentByID(appointmentID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get appointment: %w", err)
+	}
+
+	if appointment.Status != "scheduled" {
+		return nil, nil, errors.New("only scheduled appointments can be rescheduled")
+	}
+	if !startTime.Before(endTime) {
+		return nil, nil, errors.New("start time must be before end time")
+	}
+
+	client, err := s.GetClientBy("uuid", appointment.ClientID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get client: %w", err)
+	}
+
+	appointment.StartTime = startTime.In(s.location)
+	appointment.EndTime = endTime.In(s.location)
+
+	if err := s.repo.RescheduleAppointment(appointment); err != nil {
+		if errors.Is(err, common.ErrSlotTaken) {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("failed to update appointment: %w", err)
+	}
+	metrics.Bookings.WithLabelValues(metrics.BookingRescheduled).Inc()
+
+	return appointment, client, nil
 }
 
 // ===============WorkingHours==================
@@ -236,17 +575,18 @@ func (s *Service) GetWorkingHoursAvailableDates() ([]time.Time, error) {
 		return nil, fmt.Errorf("error getting working hours: %w", err)
 	}
 
-	now := time.Now()
+	today := s.dayStart(time.Now())
+	exceptions, err := s.repo.GetScheduleExceptions(today, today.AddDate(0, 0, POSSIBLE_RECORDS))
+	if err != nil {
+		return nil, fmt.Errorf("error getting schedule exceptions: %w", err)
+	}
+
 	var availableDates []time.Time
 	for i := 0; i < POSSIBLE_RECORDS; i++ {
-		date := now.AddDate(0, 0, i)
-		dayOfWeek := int(date.Weekday())
-
-		for _, wh := range workingHours {
-			if wh.DayOfWeek == dayOfWeek {
-				availableDates = append(availableDates, time.Date(date.Year(), date.Month(), date.Day(), wh.StartTime.Hour(), wh.StartTime.Minute(), 0, 0, date.Location()))
-				break
-			}
+		date := today.AddDate(0, 0, i)
+
+		if start, _, ok := s.dayHours(date, workingHours, exceptions); ok {
+			availableDates = append(availableDates, start)
 		}
 	}
 
@@ -258,14 +598,17 @@ func (s *Service) GetWorkingHours() ([]common.WorkingHours, error) {
 }
 
 func (s *Service) GetWorkingHoursAvailableSlots(serviceIDs []uuid.UUID, date time.Time) ([]time.Time, error) {
-	workingHours, err := s.repo.GetWorkingHoursByDayOfWeek(int(date.Weekday()))
+	workStart, workEnd, ok, err := s.workingHoursForDate(date)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get working hours: %w", err)
 	}
+	if !ok {
+		return nil, nil
+	}
 
-	appointments, err := s.repo.GetAppointmentsForDate(date)
+	busy, err := s.busyRanges(workStart, uuid.Nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get appointments: %w", err)
+		return nil, fmt.Errorf("failed to get busy time: %w", err)
 	}
 
 	services, err := s.repo.GetServicesByIDs(serviceIDs)
@@ -278,114 +621,369 @@ func (s *Service) GetWorkingHoursAvailableSlots(serviceIDs []uuid.UUID, date tim
 		totalDuration += service.Duration
 	}
 
-	var availableSlots []time.Time
-	currentTime := time.Date(date.Year(), date.Month(), date.Day(), workingHours.StartTime.Hour(), workingHours.StartTime.Minute(), 0, 0, date.Location())
-	endTime := time.Date(date.Year(), date.Month(), date.Day(), workingHours.EndTime.Hour(), workingHours.EndTime.Minute(), 0, 0, date.Location())
+	return s.availableSlots(workStart, workEnd, time.Duration(totalDuration)*time.Minute, busy), nil
+}
+
+// checkWorkingSlot проверяет, что запись [start, end) ещё не прошла, начинается с
+// одного из слотов рабочего дня и заканчивается до его конца. Кнопка времени могла
+// остаться от другой даты или от графика, который мастер уже поменял.
+func (s *Service) checkWorkingSlot(start, end time.Time) error {
+	if start.Before(time.Now()) {
+		return errors.New("selected time is in the past")
+	}
+
+	workStart, workEnd, ok, err := s.workingHoursForDate(start)
+	if err != nil {
+		return fmt.Errorf("failed to get working hours: %w", err)
+	}
+	if !ok {
+		return errors.New("selected date is a day off")
+	}
+	if start.Before(workStart) || end.After(workEnd) {
+		return errors.New("selected time is outside working hours")
+	}
+	if start.Sub(workStart)%slotStep != 0 {
+		return errors.New("selected time is not a slot start")
+	}
+	return nil
+}
+
+// availableSlots возвращает начала свободных интервалов длиной duration внутри рабочего дня с шагом slotStep.
+// Клиент выбирает слот по времени на часах салона, а при переводе часов назад одно и то же
+// время бывает дважды: остаётся только тот слот, в который это время разбирает slotStart.
+func (s *Service) availableSlots(workStart, workEnd time.Time, duration time.Duration, busy []TimeRange) []time.Time {
+	var slots []time.Time
+	for current := workStart; !current.Add(duration).After(workEnd); current = current.Add(slotStep) {
+		parsed, err := s.slotStart(current, current.In(s.location).Format("15:04"))
+		if err != nil || !parsed.Equal(current) {
+			continue
+		}
+		if isRangeFree(current, current.Add(duration), busy) {
+			slots = append(slots, current)
+		}
+	}
+	return slots
+}
+
+func (s *Service) workingHoursForDate(date time.Time) (time.Time, time.Time, bool, error) {
+	workingHours, err := s.repo.GetWorkingHoursAvailableDates()
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+
+	day := s.dayStart(date)
+	exceptions, err := s.repo.GetScheduleExceptions(day, day)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+
+	start, end, ok := s.dayHours(day, workingHours, exceptions)
+	return start, end, ok, nil
+}
+
+// dayHours возвращает рабочий интервал на дату. Исключение на эту дату важнее недельного графика.
+func (s *Service) dayHours(date time.Time, workingHours []common.WorkingHours, exceptions []common.ScheduleException) (time.Time, time.Time, bool) {
+	date = s.dayStart(date)
+	at := func(clock time.Time) time.Time {
+		return s.atClock(date, clock)
+	}
+
+	day := date.Format("2006-01-02")
+	for _, exception := range exceptions {
+		if exception.Date.Format("2006-01-02") != day {
+			continue
+		}
+		if exception.IsClosed || exception.StartTime == nil || exception.EndTime == nil {
+			return time.Time{}, time.Time{}, false
+		}
+		return at(*exception.StartTime), at(*exception.EndTime), true
+	}
 
-	for currentTime.Add(time.Duration(totalDuration)*time.Minute).Before(endTime) || currentTime.Add(time.Duration(totalDuration)*time.Minute).Equal(endTime) {
-		if isSlotAvailable(currentTime, totalDuration, appointments) {
-			availableSlots = append(availableSlots, currentTime)
+	for _, wh := range workingHours {
+		if wh.DayOfWeek == int(date.Weekday()) && wh.IsActive {
+			return at(wh.StartTime), at(wh.EndTime), true
 		}
-		currentTime = currentTime.Add(30 * time.Minute)
 	}
 
-	return availableSlots, nil
+	return time.Time{}, time.Time{}, false
 }
 
-func isSlotAvailable(currentTime time.Time, totalDuration int, appointments []common.Appointment) bool {
-	potentialEndTime := currentTime.Add(time.Duration(totalDuration) * time.Minute)
+// SetWorkingHours задаёт часы работы на день недели; isActive = false делает его выходным.
+func (s *Service) SetWorkingHours(dayOfWeek int, startTime, endTime time.Time, isActive bool) error {
+	if isActive && !startTime.Before(endTime) {
+		return errors.New("start time must be before end time")
+	}
+	return s.repo.SaveWorkingHours(dayOfWeek, startTime, endTime, isActive)
+}
 
-	for _, appointment := range appointments {
-		if (currentTime.Before(appointment.EndTime) && potentialEndTime.After(appointment.StartTime)) ||
-			(currentTime.Equal(appointment.StartTime) || potentialEndTime.Equal(appointment.EndTime)) {
+func (s *Service) GetUpcomingScheduleExceptions() ([]common.ScheduleException, error) {
+	today := s.dayStart(time.Now())
+	return s.repo.GetScheduleExceptions(today, today.AddDate(1, 0, 0))
+}
+
+func (s *Service) AddScheduleException(exception *common.ScheduleException) error {
+	if !exception.IsClosed {
+		if exception.StartTime == nil || exception.EndTime == nil || !exception.StartTime.Before(*exception.EndTime) {
+			return errors.New("start time must be before end time")
+		}
+	}
+
+	exception.CreatedAt = time.Now()
+	exception.UpdatedAt = time.Now()
+	return s.repo.SaveScheduleException(exception)
+}
+
+func (s *Service) DeleteScheduleException(exceptionID uuid.UUID) error {
+	return s.repo.DeleteScheduleException(exceptionID)
+}
+
+// TimeRange занятый интервал: запись, перерыв или блокировка
+type TimeRange struct {
+	Start  time.Time
+	End    time.Time
+	Reason string
+}
+
+func isRangeFree(start, end time.Time, busy []TimeRange) bool {
+	for _, r := range busy {
+		if start.Before(r.End) && end.After(r.Start) {
 			return false
 		}
 	}
 	return true
 }
 
-// =================================
-
-func (s *Service) SaveSelectedService(userID int64, serviceID string) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// dayBlocks возвращает перерывы и разовые блокировки, попадающие на дату.
+func (s *Service) dayBlocks(date time.Time) ([]TimeRange, error) {
+	dayStart, dayEnd := s.dayBounds(date)
 
-	uuid, err := uuid.Parse(serviceID)
+	breaks, err := s.repo.GetBreaks()
 	if err != nil {
-		return fmt.Errorf("invalid service ID: %v", err)
+		return nil, fmt.Errorf("failed to get breaks: %w", err)
 	}
 
-	if _, ok := s.bookingData[userID]; !ok {
-		s.bookingData[userID] = &BookingData{}
+	var blocks []TimeRange
+	for _, b := range breaks {
+		if b.DayOfWeek != int(dayStart.Weekday()) {
+			continue
+		}
+		blocks = append(blocks, TimeRange{
+			Start:  s.atClock(dayStart, b.StartTime),
+			End:    s.atClock(dayStart, b.EndTime),
+			Reason: b.Reason,
+		})
 	}
-	s.bookingData[userID].ServiceID = uuid
-	return nil
+
+	blocked, err := s.repo.GetBlockedRanges(dayStart, dayEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blocked ranges: %w", err)
+	}
+	for _, b := range blocked {
+		blocks = append(blocks, TimeRange{Start: b.StartTime, End: b.EndTime, Reason: b.Reason})
+	}
+
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Start.Before(blocks[j].Start) })
+	return blocks, nil
 }
 
-func (s *Service) SaveSelectedDate(userID int64, date time.Time) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// busyRanges собирает всё занятое время на дату, кроме записи exclude (она переносится).
+func (s *Service) busyRanges(date time.Time, exclude uuid.UUID) ([]TimeRange, error) {
+	dayStart, dayEnd := s.dayBounds(date)
+	appointments, err := s.repo.GetAppointmentsForDate(dayStart, dayEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get appointments: %w", err)
+	}
 
-	if _, ok := s.bookingData[userID]; !ok {
-		s.bookingData[userID] = &BookingData{}
+	var busy []TimeRange
+	for _, appointment := range appointments {
+		if appointment.UUID == exclude || appointment.Status == "cancelled" {
+			continue
+		}
+		busy = append(busy, TimeRange{Start: appointment.StartTime, End: appointment.EndTime})
 	}
-	s.bookingData[userID].Date = date
-	return nil
+
+	blocks, err := s.dayBlocks(date)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(busy, blocks...), nil
 }
 
-func (s *Service) getSelectedServiceForUser(userID int64) (uuid.UUID, error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+// ===============Breaks==================
 
-	if data, ok := s.bookingData[userID]; ok && data.ServiceID != uuid.Nil {
-		return data.ServiceID, nil
+func (s *Service) GetBreaks() ([]common.Break, error) {
+	return s.repo.GetBreaks()
+}
+
+func (s *Service) AddBreak(dayOfWeek int, startTime, endTime time.Time, reason string) error {
+	if !startTime.Before(endTime) {
+		return errors.New("start time must be before end time")
 	}
-	return uuid.Nil, fmt.Errorf("no service selected for user %d", userID)
+
+	return s.repo.CreateBreak(&common.Break{
+		DayOfWeek: dayOfWeek,
+		StartTime: startTime,
+		EndTime:   endTime,
+		Reason:    reason,
+	})
+}
+
+func (s *Service) DeleteBreak(breakID uuid.UUID) error {
+	return s.repo.DeleteBreak(breakID)
 }
 
-func (s *Service) getSelectedDateForUser(userID int64) (time.Time, error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+func (s *Service) GetUpcomingBlockedRanges() ([]common.BlockedRange, error) {
+	now := time.Now()
+	return s.repo.GetBlockedRanges(now, now.AddDate(1, 0, 0))
+}
+
+func (s *Service) AddBlockedRange(startTime, endTime time.Time, reason string) error {
+	if !startTime.Before(endTime) {
+		return errors.New("start time must be before end time")
+	}
+
+	return s.repo.CreateBlockedRange(&common.BlockedRange{
+		StartTime: startTime,
+		EndTime:   endTime,
+		Reason:    reason,
+		Source:    common.BlockSourceManual,
+	})
+}
 
-	if data, ok := s.bookingData[userID]; ok && !data.Date.IsZero() {
-		return data.Date, nil
+// ReplaceCalendarBlocks сохраняет занятость мастера, прочитанную из календаря за [from, to)
+func (s *Service) ReplaceCalendarBlocks(from, to time.Time, blocks []common.BlockedRange) error {
+	for i := range blocks {
+		blocks[i].Source = common.BlockSourceCalendar
 	}
-	return time.Time{}, fmt.Errorf("no date selected for user %d", userID)
+	return s.repo.ReplaceCalendarBlocks(from, to, blocks)
 }
 
-func (s *Service) ClearBookingData(userID int64) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+func (s *Service) DeleteBlockedRange(blockedID uuid.UUID) error {
+	return s.repo.DeleteBlockedRange(blockedID)
+}
 
-	delete(s.bookingData, userID)
+// GetDayBlocks возвращает перерывы и блокировки на дату для расписания мастера.
+func (s *Service) GetDayBlocks(date time.Time) ([]TimeRange, error) {
+	return s.dayBlocks(date)
 }
 
+// =================================
+
 func (s *Service) SaveCalendarEventID(appointmentID uuid.UUID, eventID string) error {
 	return s.repo.SaveCalendarEventID(appointmentID, eventID)
 }
 
-func (s *Service) GetCalendarEventID(appointmentID uuid.UUID) (string, error) {
-	return s.repo.GetCalendarEventID(appointmentID)
+// GetAppointmentsByCalendarEventIDs находит записи событий. Событие, которое очередь
+// уже создала, но ещё не сохранила в записи, узнаётся по выведенному из записи ID.
+func (s *Service) GetAppointmentsByCalendarEventIDs(eventIDs []string) ([]common.Appointment, error) {
+	var appointmentIDs []uuid.UUID
+	for _, eventID := range eventIDs {
+		if appointmentID, ok := calendar.AppointmentIDFromEventID(eventID); ok {
+			appointmentIDs = append(appointmentIDs, appointmentID)
+		}
+	}
+	return s.repo.GetAppointmentsByCalendarEvents(eventIDs, appointmentIDs)
 }
 
-func (s *Service) UpdateAppointmentDateTime(appointmentID uuid.UUID, newStartTime, newEndTime time.Time) error {
-	// First get the existing appointment to preserve other fields
-	existingAppointment, err := s.GetAppointmentByID(appointmentID)
-	if err != nil {
-		return fmt.Errorf("failed to get existing appointment: %w", err)
+func (s *Service) GetScheduledAppointmentsWithEvents(from, to time.Time) ([]common.Appointment, error) {
+	return s.repo.GetScheduledAppointmentsWithEvents(from, to)
+}
+
+// ===============Calendar outbox==================
+
+// calendarOperation готовит операцию календаря для записи. Без календаря очередь
+// не ведётся, иначе операции копились бы и выполнились после его подключения.
+func (s *Service) calendarOperation(action string, appointment *common.Appointment) []common.CalendarOperation {
+	if !s.calendarEnabled {
+		return nil
 	}
 
-	appointment := &common.Appointment{
-		UUID:       appointmentID,
-		ClientID:   existingAppointment.ClientID,
-		StartTime:  newStartTime,
-		EndTime:    newEndTime,
-		Name:       existingAppointment.Name,
-		TotalPrice: existingAppointment.TotalPrice,
-		Status:     existingAppointment.Status,
-		UpdatedAt:  time.Now(),
-		Services:   existingAppointment.Services,
-	}
-
-	return s.repo.UpdateAppointment(appointment)
+	eventID := appointment.CalendarEventID
+	if eventID == "" {
+		eventID = calendar.AppointmentEventID(appointment.UUID)
+	}
+
+	return []common.CalendarOperation{{
+		AppointmentID: appointment.UUID,
+		Action:        action,
+		EventID:       eventID,
+		Status:        calendarOpStatusPending,
+		NextAttemptAt: time.Now(),
+		CorrelationID: s.correlationID,
+	}}
+}
+
+func (s *Service) ClaimDueCalendarOperations(lease time.Duration, limit int) ([]common.CalendarOperation, error) {
+	return s.repo.ClaimDueCalendarOperations(time.Now(), lease, limit)
+}
+
+func (s *Service) MarkCalendarOperationDone(opID uuid.UUID) error {
+	return s.repo.UpdateCalendarOperation(opID, calendarOpStatusDone, time.Now(), "")
+}
+
+// RetryCalendarOperation откладывает операцию до nextAttemptAt
+func (s *Service) RetryCalendarOperation(opID uuid.UUID, nextAttemptAt time.Time, lastError string) error {
+	return s.repo.UpdateCalendarOperation(opID, calendarOpStatusPending, nextAttemptAt, lastError)
+}
+
+// DeleteDoneCalendarOperations удаляет выполненные операции, которые не менялись дольше retention
+func (s *Service) DeleteDoneCalendarOperations(retention time.Duration) (int64, error) {
+	return s.repo.DeleteCalendarOperations(calendarOpStatusDone, time.Now().Add(-retention))
+}
+
+// MarkCalendarOperationDead прекращает попытки, операция попадает в список для мастера
+func (s *Service) MarkCalendarOperationDead(opID uuid.UUID, lastError string) error {
+	return s.repo.UpdateCalendarOperation(opID, calendarOpStatusDead, time.Now(), lastError)
+}
+
+func (s *Service) GetDeadCalendarOperations(limit int) ([]common.CalendarOperation, error) {
+	return s.repo.GetDeadCalendarOperations(limit)
+}
+
+func (s *Service) RequeueCalendarOperation(opID uuid.UUID) error {
+	return s.repo.RequeueCalendarOperation(opID, time.Now())
+}
+
+func (s *Service) CountCalendarOperations(status string) (int64, error) {
+	return s.repo.CountCalendarOperations(status)
+}
+
+// ===============Reminder==================
+
+func (s *Service) ScheduleReminder(appointmentID uuid.UUID, chatID int64, message string, notifyAt time.Time) error {
+	return s.repo.CreateReminder(&common.Reminder{
+		AppointmentID: appointmentID,
+		ChatID:        chatID,
+		Message:       message,
+		NotifyAt:      notifyAt,
+		Status:        reminderStatusPending,
+		CorrelationID: s.correlationID,
+	})
+}
+
+func (s *Service) CancelReminders(appointmentID uuid.UUID) error {
+	return s.repo.CancelReminders(appointmentID)
+}
+
+func (s *Service) ClaimDueReminders(lease time.Duration, limit int) ([]common.Reminder, error) {
+	return s.repo.ClaimDueReminders(time.Now(), lease, limit)
+}
+
+func (s *Service) CountPendingReminders() (int64, error) {
+	return s.repo.CountPendingReminders()
+}
+
+func (s *Service) MarkReminderSent(reminderID uuid.UUID) error {
+	now := time.Now()
+	return s.repo.UpdateReminderStatus(reminderID, reminderStatusSent, &now)
+}
+
+// MarkReminderCancelled снимает напоминание, которое больше не нужно отправлять
+func (s *Service) MarkReminderCancelled(reminderID uuid.UUID) error {
+	return s.repo.UpdateReminderStatus(reminderID, reminderStatusCancelled, nil)
+}
+
+func (s *Service) MarkReminderFailed(reminderID uuid.UUID) error {
+	return s.repo.UpdateReminderStatus(reminderID, reminderStatusFailed, nil)
 }
@@ -0,0 +1,378 @@
+package bot
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RudinMaxim/BarberBot.git/common"
+	"github.com/google/uuid"
+)
+
+// В 2024 году Европа переводит часы 31 марта и 27 октября, США — 10 марта и 3 ноября; все четыре дня воскресенья.
+
+func testService(t *testing.T, zone string) *Service {
+	t.Helper()
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		t.Fatalf("load %s: %v", zone, err)
+	}
+	return NewService(nil, location, false)
+}
+
+func testLocal(t *testing.T, s *Service, value string) time.Time {
+	t.Helper()
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", value, s.location)
+	if err != nil {
+		t.Fatalf("parse %q: %v", value, err)
+	}
+	return parsed
+}
+
+func testUTC(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatalf("parse %q: %v", value, err)
+	}
+	return parsed
+}
+
+func clockOf(hour, minute int) time.Time {
+	return time.Date(0, 1, 1, hour, minute, 0, 0, time.UTC)
+}
+
+func TestDayBounds(t *testing.T) {
+	tests := []struct {
+		name      string
+		zone      string
+		at        string
+		wantStart string
+		wantHours float64
+	}{
+		{"berlin before spring forward", "Europe/Berlin", "2024-03-30T12:00:00Z", "2024-03-29T23:00:00Z", 24},
+		{"berlin spring forward", "Europe/Berlin", "2024-03-31T21:30:00Z", "2024-03-30T23:00:00Z", 23},
+		{"berlin fall back", "Europe/Berlin", "2024-10-27T22:30:00Z", "2024-10-26T22:00:00Z", 25},
+		{"berlin local midnight in utc evening", "Europe/Berlin", "2024-10-26T22:00:00Z", "2024-10-26T22:00:00Z", 25},
+		{"new york spring forward", "America/New_York", "2024-03-11T03:30:00Z", "2024-03-10T05:00:00Z", 23},
+		{"new york fall back", "America/New_York", "2024-11-03T04:00:00Z", "2024-11-03T04:00:00Z", 25},
+		{"new york after fall back", "America/New_York", "2024-11-04T04:30:00Z", "2024-11-03T04:00:00Z", 25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testService(t, tt.zone)
+			start, end := s.dayBounds(testUTC(t, tt.at))
+
+			if !start.Equal(testUTC(t, tt.wantStart)) {
+				t.Errorf("start = %s, want %s", start.UTC().Format(time.RFC3339), tt.wantStart)
+			}
+			if !start.Equal(s.dayStart(testUTC(t, tt.at))) {
+				t.Errorf("dayBounds start %s differs from dayStart", start)
+			}
+			if got := end.Sub(start).Hours(); got != tt.wantHours {
+				t.Errorf("day length = %vh, want %vh", got, tt.wantHours)
+			}
+			if local := end.In(s.location); local.Hour() != 0 || local.Minute() != 0 {
+				t.Errorf("end = %s, want local midnight", local)
+			}
+		})
+	}
+}
+
+func TestAtClock(t *testing.T) {
+	tests := []struct {
+		name  string
+		zone  string
+		day   string
+		clock time.Time
+		want  string
+	}{
+		{"berlin winter time", "Europe/Berlin", "2024-03-30", clockOf(10, 0), "2024-03-30T09:00:00Z"},
+		{"berlin spring forward", "Europe/Berlin", "2024-03-31", clockOf(10, 0), "2024-03-31T08:00:00Z"},
+		{"berlin summer time", "Europe/Berlin", "2024-10-26", clockOf(10, 0), "2024-10-26T08:00:00Z"},
+		{"berlin fall back", "Europe/Berlin", "2024-10-27", clockOf(10, 0), "2024-10-27T09:00:00Z"},
+		{"berlin fall back evening", "Europe/Berlin", "2024-10-27", clockOf(20, 30), "2024-10-27T19:30:00Z"},
+		{"new york winter time", "America/New_York", "2024-03-09", clockOf(10, 0), "2024-03-09T15:00:00Z"},
+		{"new york spring forward", "America/New_York", "2024-03-10", clockOf(10, 0), "2024-03-10T14:00:00Z"},
+		{"new york summer time", "America/New_York", "2024-11-02", clockOf(10, 0), "2024-11-02T14:00:00Z"},
+		{"new york fall back", "America/New_York", "2024-11-03", clockOf(10, 0), "2024-11-03T15:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testService(t, tt.zone)
+			// День приходит из разных мест: полночь салона, момент из базы в UTC, конец дня.
+			days := []time.Time{
+				testLocal(t, s, tt.day+" 00:00"),
+				testLocal(t, s, tt.day+" 00:00").UTC(),
+				testLocal(t, s, tt.day+" 23:59"),
+			}
+			for _, day := range days {
+				got := s.atClock(day, tt.clock)
+				if !got.Equal(testUTC(t, tt.want)) {
+					t.Errorf("atClock(%s) = %s, want %s", day, got.UTC().Format(time.RFC3339), tt.want)
+				}
+				if got.Location() != s.location {
+					t.Errorf("atClock(%s) location = %s, want %s", day, got.Location(), s.location)
+				}
+			}
+		})
+	}
+}
+
+func TestDayHours(t *testing.T) {
+	sunday := []common.WorkingHours{
+		{DayOfWeek: int(time.Sunday), StartTime: clockOf(1, 0), EndTime: clockOf(5, 0), IsActive: true},
+		{DayOfWeek: int(time.Monday), StartTime: clockOf(10, 0), EndTime: clockOf(18, 0), IsActive: true},
+	}
+	inactive := []common.WorkingHours{
+		{DayOfWeek: int(time.Sunday), StartTime: clockOf(1, 0), EndTime: clockOf(5, 0), IsActive: false},
+	}
+	start, end := clockOf(12, 0), clockOf(16, 0)
+	// Дата исключения приходит из колонки date — полночь UTC.
+	exception := func(day string, closed bool) []common.ScheduleException {
+		date, _ := time.Parse("2006-01-02", day)
+		if closed {
+			return []common.ScheduleException{{Date: date, IsClosed: true}}
+		}
+		return []common.ScheduleException{{Date: date, StartTime: &start, EndTime: &end}}
+	}
+
+	tests := []struct {
+		name         string
+		zone         string
+		day          string
+		workingHours []common.WorkingHours
+		exceptions   []common.ScheduleException
+		wantOK       bool
+		wantStart    string
+		wantEnd      string
+	}{
+		{"berlin spring forward night shift", "Europe/Berlin", "2024-03-31", sunday, nil, true, "2024-03-31T00:00:00Z", "2024-03-31T03:00:00Z"},
+		{"berlin fall back night shift", "Europe/Berlin", "2024-10-27", sunday, nil, true, "2024-10-26T23:00:00Z", "2024-10-27T04:00:00Z"},
+		{"berlin exception wins", "Europe/Berlin", "2024-03-31", sunday, exception("2024-03-31", false), true, "2024-03-31T10:00:00Z", "2024-03-31T14:00:00Z"},
+		{"berlin closed by exception", "Europe/Berlin", "2024-10-27", sunday, exception("2024-10-27", true), false, "", ""},
+		{"berlin exception for other day", "Europe/Berlin", "2024-10-27", sunday, exception("2024-10-26", true), true, "2024-10-26T23:00:00Z", "2024-10-27T04:00:00Z"},
+		{"berlin inactive day", "Europe/Berlin", "2024-03-31", inactive, nil, false, "", ""},
+		{"new york spring forward night shift", "America/New_York", "2024-03-10", sunday, nil, true, "2024-03-10T06:00:00Z", "2024-03-10T09:00:00Z"},
+		{"new york fall back night shift", "America/New_York", "2024-11-03", sunday, nil, true, "2024-11-03T05:00:00Z", "2024-11-03T10:00:00Z"},
+		{"new york exception wins", "America/New_York", "2024-11-03", sunday, exception("2024-11-03", false), true, "2024-11-03T17:00:00Z", "2024-11-03T21:00:00Z"},
+		{"new york monday after fall back", "America/New_York", "2024-11-04", sunday, nil, true, "2024-11-04T15:00:00Z", "2024-11-04T23:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testService(t, tt.zone)
+			// Дата выбирается как полночь салона, но может прийти и как момент в UTC.
+			for _, date := range []time.Time{testLocal(t, s, tt.day+" 00:00"), testLocal(t, s, tt.day+" 23:00").UTC()} {
+				gotStart, gotEnd, ok := s.dayHours(date, tt.workingHours, tt.exceptions)
+				if ok != tt.wantOK {
+					t.Fatalf("dayHours(%s) ok = %v, want %v", date, ok, tt.wantOK)
+				}
+				if !ok {
+					continue
+				}
+				if !gotStart.Equal(testUTC(t, tt.wantStart)) || !gotEnd.Equal(testUTC(t, tt.wantEnd)) {
+					t.Errorf("dayHours(%s) = %s..%s, want %s..%s", date,
+						gotStart.UTC().Format(time.RFC3339), gotEnd.UTC().Format(time.RFC3339), tt.wantStart, tt.wantEnd)
+				}
+			}
+		})
+	}
+}
+
+func TestAvailableSlots(t *testing.T) {
+	tests := []struct {
+		name     string
+		zone     string
+		day      string
+		from, to time.Time
+		duration time.Duration
+		busy     [][2]string
+		want     []string
+	}{
+		{
+			name: "berlin spring forward skips missing hour", zone: "Europe/Berlin", day: "2024-03-31",
+			from: clockOf(1, 0), to: clockOf(5, 0), duration: time.Hour,
+			want: []string{"2024-03-31T00:00:00Z", "2024-03-31T00:30:00Z", "2024-03-31T01:00:00Z", "2024-03-31T01:30:00Z", "2024-03-31T02:00:00Z"},
+		},
+		{
+			// Go разбирает повторившееся время в Берлине как зимнее, поэтому остаются слоты после перевода.
+			name: "berlin fall back keeps parsable repeated hour", zone: "Europe/Berlin", day: "2024-10-27",
+			from: clockOf(1, 0), to: clockOf(5, 0), duration: time.Hour,
+			want: []string{"2024-10-26T23:00:00Z", "2024-10-26T23:30:00Z", "2024-10-27T01:00:00Z", "2024-10-27T01:30:00Z", "2024-10-27T02:00:00Z", "2024-10-27T02:30:00Z", "2024-10-27T03:00:00Z"},
+		},
+		{
+			name: "new york spring forward skips missing hour", zone: "America/New_York", day: "2024-03-10",
+			from: clockOf(1, 0), to: clockOf(5, 0), duration: time.Hour,
+			want: []string{"2024-03-10T06:00:00Z", "2024-03-10T06:30:00Z", "2024-03-10T07:00:00Z", "2024-03-10T07:30:00Z", "2024-03-10T08:00:00Z"},
+		},
+		{
+			// В Нью-Йорке повторившееся время разбирается как летнее, поэтому остаются слоты до перевода.
+			name: "new york fall back keeps parsable repeated hour", zone: "America/New_York", day: "2024-11-03",
+			from: clockOf(0, 30), to: clockOf(4, 0), duration: time.Hour,
+			want: []string{"2024-11-03T04:30:00Z", "2024-11-03T05:00:00Z", "2024-11-03T05:30:00Z", "2024-11-03T07:00:00Z", "2024-11-03T07:30:00Z", "2024-11-03T08:00:00Z"},
+		},
+		{
+			name: "new york fall back day with appointment", zone: "America/New_York", day: "2024-11-03",
+			from: clockOf(10, 0), to: clockOf(13, 0), duration: time.Hour,
+			busy: [][2]string{{"2024-11-03T16:00:00Z", "2024-11-03T17:00:00Z"}},
+			want: []string{"2024-11-03T15:00:00Z", "2024-11-03T17:00:00Z"},
+		},
+		{
+			name: "berlin spring forward day with appointment", zone: "Europe/Berlin", day: "2024-03-31",
+			from: clockOf(10, 0), to: clockOf(12, 0), duration: 30 * time.Minute,
+			busy: [][2]string{{"2024-03-31T08:30:00Z", "2024-03-31T09:00:00Z"}},
+			want: []string{"2024-03-31T08:00:00Z", "2024-03-31T09:00:00Z", "2024-03-31T09:30:00Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testService(t, tt.zone)
+			day := testLocal(t, s, tt.day+" 00:00")
+			var busy []TimeRange
+			for _, r := range tt.busy {
+				busy = append(busy, TimeRange{Start: testUTC(t, r[0]), End: testUTC(t, r[1])})
+			}
+
+			slots := s.availableSlots(s.atClock(day, tt.from), s.atClock(day, tt.to), tt.duration, busy)
+
+			var got []string
+			for _, slot := range slots {
+				got = append(got, slot.UTC().Format(time.RFC3339))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("slots = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("slots = %v, want %v", got, tt.want)
+				}
+			}
+
+			// Кнопка несёт время на часах салона; выбранный слот должен сохраниться тем же моментом.
+			for _, slot := range slots {
+				stored, err := s.slotStart(slot.UTC(), slot.In(s.location).Format("15:04"))
+				if err != nil {
+					t.Fatalf("slotStart(%s): %v", slot, err)
+				}
+				if !stored.Equal(slot) {
+					t.Errorf("slot %s is stored as %s", slot.UTC().Format(time.RFC3339), stored.UTC().Format(time.RFC3339))
+				}
+			}
+		})
+	}
+}
+
+func TestSlotStart(t *testing.T) {
+	tests := []struct {
+		name    string
+		zone    string
+		date    string
+		clock   string
+		want    string
+		wantErr bool
+	}{
+		{"berlin spring forward", "Europe/Berlin", "2024-03-31T00:00:00+01:00", "10:00", "2024-03-31T08:00:00Z", false},
+		{"berlin date as utc instant", "Europe/Berlin", "2024-03-30T23:30:00Z", "10:00", "2024-03-31T08:00:00Z", false},
+		{"berlin day before spring forward", "Europe/Berlin", "2024-03-30T00:00:00+01:00", "10:00", "2024-03-30T09:00:00Z", false},
+		{"berlin fall back", "Europe/Berlin", "2024-10-27T00:00:00+02:00", "18:30", "2024-10-27T17:30:00Z", false},
+		{"berlin fall back date late in utc", "Europe/Berlin", "2024-10-27T22:59:00Z", "09:00", "2024-10-27T08:00:00Z", false},
+		{"new york spring forward", "America/New_York", "2024-03-10T00:00:00-05:00", "10:00", "2024-03-10T14:00:00Z", false},
+		{"new york date as utc instant", "America/New_York", "2024-03-11T03:00:00Z", "10:00", "2024-03-10T14:00:00Z", false},
+		{"new york fall back", "America/New_York", "2024-11-03T00:00:00-04:00", "10:00", "2024-11-03T15:00:00Z", false},
+		{"invalid clock", "America/New_York", "2024-11-03T00:00:00-04:00", "25:00", "", true},
+		{"garbage clock", "Europe/Berlin", "2024-03-31T00:00:00+01:00", "ten", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testService(t, tt.zone)
+			got, err := s.slotStart(testUTC(t, tt.date), tt.clock)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("slotStart(%s, %q) = %s, want error", tt.date, tt.clock, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("slotStart(%s, %q): %v", tt.date, tt.clock, err)
+			}
+			if !got.Equal(testUTC(t, tt.want)) {
+				t.Errorf("slotStart(%s, %q) = %s, want %s", tt.date, tt.clock, got.UTC().Format(time.RFC3339), tt.want)
+			}
+			if clock := got.In(s.location).Format("15:04"); clock != tt.clock {
+				t.Errorf("stored instant shows %s on the salon clock, want %s", clock, tt.clock)
+			}
+		})
+	}
+}
+
+func TestCreateAppointmentChecksWorkingHours(t *testing.T) {
+	s := testService(t, "Europe/Berlin")
+	day := s.dayStart(time.Now().AddDate(0, 0, 7))
+	serviceID, clientID := uuid.New(), uuid.New()
+
+	tests := []struct {
+		name     string
+		date     time.Time
+		clock    string
+		closed   bool
+		wantErr  bool
+		wantSlot bool
+	}{
+		{"first slot", day, "10:00", false, false, false},
+		{"last slot", day, "17:00", false, false, false},
+		{"before opening", day, "09:00", false, true, false},
+		{"ends after closing", day, "17:30", false, true, false},
+		{"between slots", day, "10:15", false, true, false},
+		{"during break", day, "13:00", false, true, true},
+		{"closed by exception", day, "10:00", true, true, false},
+		{"in the past", s.dayStart(time.Now().AddDate(0, 0, -1)), "10:00", false, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &recordingDB{rowsAffected: 1, respond: func(query string) ([]string, [][]driver.Value, error) {
+				switch {
+				case strings.Contains(query, "count("):
+					return []string{"count"}, [][]driver.Value{{int64(0)}}, nil
+				case strings.Contains(query, `FROM "services"`):
+					return []string{"uuid", "name", "duration", "price"}, [][]driver.Value{{serviceID.String(), "Стрижка", int64(60), 1500.0}}, nil
+				case strings.Contains(query, `FROM "clients"`):
+					return []string{"uuid", "telegram_id", "name"}, [][]driver.Value{{clientID.String(), int64(42), "Анна"}}, nil
+				case strings.Contains(query, `FROM "working_hours"`):
+					var rows [][]driver.Value
+					for weekday := 0; weekday < 7; weekday++ {
+						rows = append(rows, []driver.Value{int64(weekday), clockOf(10, 0), clockOf(18, 0), true})
+					}
+					return []string{"day_of_week", "start_time", "end_time", "is_active"}, rows, nil
+				case strings.Contains(query, `FROM "schedule_exceptions"`) && tt.closed:
+					date := time.Date(tt.date.Year(), tt.date.Month(), tt.date.Day(), 0, 0, 0, 0, time.UTC)
+					return []string{"date", "is_closed"}, [][]driver.Value{{date, true}}, nil
+				case strings.Contains(query, `FROM "breaks"`):
+					return []string{"day_of_week", "start_time", "end_time", "reason"},
+						[][]driver.Value{{int64(day.Weekday()), clockOf(13, 0), clockOf(14, 0), "Обед"}}, nil
+				}
+				return []string{"uuid"}, nil, nil
+			}}
+			service := NewService(db.repository(t), s.location, false)
+
+			_, err := service.CreateAppointment(42, []uuid.UUID{serviceID}, tt.date, tt.clock)
+
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("CreateAppointment(%s) error = %v, want error %v", tt.clock, err, tt.wantErr)
+			}
+			if tt.wantSlot != errors.Is(err, common.ErrSlotTaken) {
+				t.Errorf("CreateAppointment(%s) error = %v, want ErrSlotTaken %v", tt.clock, err, tt.wantSlot)
+			}
+			if inserted := len(db.execs(`INSERT INTO "appointments"`)) > 0; inserted == tt.wantErr {
+				t.Errorf("appointment inserted = %v, want %v", inserted, !tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package bot
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/RudinMaxim/BarberBot.git/internal/metrics"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// WorkerPool обрабатывает обновления параллельно. Все обновления одного
+// пользователя попадают в одну очередь, поэтому обрабатываются по порядку.
+type WorkerPool struct {
+	handle func(tgbotapi.Update)
+	queues []chan tgbotapi.Update
+	wg     sync.WaitGroup
+	log    *slog.Logger
+}
+
+func NewWorkerPool(handler *Handler, workers int, queueSize int) *WorkerPool {
+	return newWorkerPool(handler.HandleUpdate, workers, queueSize)
+}
+
+func newWorkerPool(handle func(tgbotapi.Update), workers int, queueSize int) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+
+	pool := &WorkerPool{
+		handle: handle,
+		queues: make([]chan tgbotapi.Update, workers),
+		log:    slog.Default().With("component", "worker_pool"),
+	}
+	for i := range pool.queues {
+		pool.queues[i] = make(chan tgbotapi.Update, queueSize)
+	}
+	return pool
+}
+
+func (p *WorkerPool) Start() {
+	for _, queue := range p.queues {
+		p.wg.Add(1)
+		go func(queue chan tgbotapi.Update) {
+			defer p.wg.Done()
+			for update := range queue {
+				p.process(update)
+			}
+		}(queue)
+	}
+}
+
+// process обрабатывает одно обновление. Паника в обработчике не должна останавливать
+// воркер: за ним закреплены все пользователи его очереди.
+func (p *WorkerPool) process(update tgbotapi.Update) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			p.log.Error("panic while handling update",
+				"update_id", update.UpdateID,
+				"user_id", updateUserID(update),
+				"panic", r,
+				"stack", string(debug.Stack()),
+			)
+		}
+		metrics.ObserveUpdate(updateType(update), start)
+	}()
+
+	p.handle(update)
+}
+
+// Dispatch ставит обновление в очередь воркера пользователя. Если очередь
+// заполнена, ждёт освобождения места или отмены ctx.
+func (p *WorkerPool) Dispatch(ctx context.Context, update tgbotapi.Update) error {
+	queue := p.queues[p.shard(updateUserID(update))]
+
+	select {
+	case queue <- update:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop закрывает очереди и ждёт, пока воркеры обработают уже принятые обновления.
+// Dispatch после Stop вызывать нельзя.
+func (p *WorkerPool) Stop() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
+
+func (p *WorkerPool) shard(userID int64) int {
+	if userID < 0 {
+		userID = -userID
+	}
+	return int(userID % int64(len(p.queues)))
+}
+
+func updateUserID(update tgbotapi.Update) int64 {
+	switch {
+	case update.Message != nil && update.Message.From != nil:
+		return update.Message.From.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.From != nil:
+		return update.CallbackQuery.From.ID
+	}
+	return 0
+}
+
+func updateType(update tgbotapi.Update) string {
+	if update.CallbackQuery != nil {
+		return "callback_query"
+	}
+	return "message"
+}
@@ -0,0 +1,137 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func testMessageUpdate(updateID int, userID int64) tgbotapi.Update {
+	return tgbotapi.Update{
+		UpdateID: updateID,
+		Message:  &tgbotapi.Message{From: &tgbotapi.User{ID: userID}, Chat: &tgbotapi.Chat{ID: userID}},
+	}
+}
+
+func TestWorkerPoolKeepsUserOrder(t *testing.T) {
+	const users, perUser = 10, 50
+
+	var mu sync.Mutex
+	seen := make(map[int64][]int)
+	pool := newWorkerPool(func(update tgbotapi.Update) {
+		// Разная длительность обработки перемешала бы обновления, если бы они шли параллельно
+		time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+		mu.Lock()
+		defer mu.Unlock()
+		userID := updateUserID(update)
+		seen[userID] = append(seen[userID], update.UpdateID)
+	}, 4, 8)
+	pool.Start()
+
+	updateID := 0
+	for i := 0; i < perUser; i++ {
+		for userID := int64(1); userID <= users; userID++ {
+			updateID++
+			if err := pool.Dispatch(context.Background(), testMessageUpdate(updateID, userID)); err != nil {
+				t.Fatalf("Dispatch: %v", err)
+			}
+		}
+	}
+	pool.Stop()
+
+	for userID := int64(1); userID <= users; userID++ {
+		ids := seen[userID]
+		if len(ids) != perUser {
+			t.Fatalf("user %d: processed %d updates, want %d", userID, len(ids), perUser)
+		}
+		for i := 1; i < len(ids); i++ {
+			if ids[i] < ids[i-1] {
+				t.Fatalf("user %d: update %d processed after %d", userID, ids[i], ids[i-1])
+			}
+		}
+	}
+}
+
+func TestWorkerPoolShard(t *testing.T) {
+	pool := newWorkerPool(func(tgbotapi.Update) {}, 4, 1)
+
+	tests := []struct {
+		userID int64
+		want   int
+	}{
+		{0, 0},
+		{1, 1},
+		{5, 1},
+		{-5, 1},
+		{7, 3},
+		{1<<62 + 2, 2},
+	}
+	for _, tt := range tests {
+		if got := pool.shard(tt.userID); got != tt.want {
+			t.Errorf("shard(%d) = %d, want %d", tt.userID, got, tt.want)
+		}
+	}
+
+	// Сообщение и нажатие кнопки одного пользователя попадают в одну очередь
+	callback := tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{From: &tgbotapi.User{ID: 7}}}
+	if pool.shard(updateUserID(callback)) != pool.shard(updateUserID(testMessageUpdate(1, 7))) {
+		t.Error("message and callback of one user are sharded differently")
+	}
+}
+
+func TestWorkerPoolQueueIsBounded(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	pool := newWorkerPool(func(tgbotapi.Update) {
+		started <- struct{}{}
+		<-release
+	}, 1, 1)
+	pool.Start()
+	defer pool.Stop()
+	defer close(release)
+
+	// Первое обновление занимает воркер, второе единственное место в очереди
+	if err := pool.Dispatch(context.Background(), testMessageUpdate(1, 1)); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	<-started
+	if err := pool.Dispatch(context.Background(), testMessageUpdate(2, 1)); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := pool.Dispatch(ctx, testMessageUpdate(3, 1)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Dispatch into a full queue = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestWorkerPoolSurvivesPanic(t *testing.T) {
+	var mu sync.Mutex
+	var handled []int
+	pool := newWorkerPool(func(update tgbotapi.Update) {
+		if update.UpdateID == 1 {
+			panic("broken update")
+		}
+		mu.Lock()
+		handled = append(handled, update.UpdateID)
+		mu.Unlock()
+	}, 1, 4)
+	pool.Start()
+
+	for id := 1; id <= 3; id++ {
+		if err := pool.Dispatch(context.Background(), testMessageUpdate(id, 1)); err != nil {
+			t.Fatalf("Dispatch: %v", err)
+		}
+	}
+	pool.Stop()
+
+	if len(handled) != 2 || handled[0] != 2 || handled[1] != 3 {
+		t.Errorf("handled %v after a panic, want [2 3]", handled)
+	}
+}
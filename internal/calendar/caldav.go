@@ -0,0 +1,364 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/RudinMaxim/BarberBot.git/config"
+	"github.com/emersion/go-ical"
+	"github.com/emersion/go-webdav"
+	"github.com/emersion/go-webdav/caldav"
+	"github.com/google/uuid"
+	"github.com/teambition/rrule-go"
+)
+
+const (
+	caldavTimeout   = 15 * time.Second
+	caldavProductID = "-//BarberBot//RU"
+	// caldavMaxOccurrences ограничивает число вхождений одного повторяющегося события
+	caldavMaxOccurrences = 1000
+	// caldavOccurrenceFormat суффикс ID вхождения, как у экземпляров событий Google
+	caldavOccurrenceFormat = "20060102T150405Z"
+)
+
+// CalDAVProvider календарь на любом CalDAV-сервере. Каждое событие хранится
+// отдельным объектом <UID>.ics в коллекции календаря, UID служит ID события.
+type CalDAVProvider struct {
+	client       *caldav.Client
+	calendarPath string
+	// location пояс, в котором читаются даты без часового пояса
+	location *time.Location
+}
+
+func NewCalDAVProvider(cfg config.CalDAV, location *time.Location) (*CalDAVProvider, error) {
+	calendarURL, err := url.Parse(cfg.URL)
+	if err != nil || calendarURL.Scheme == "" || calendarURL.Host == "" {
+		return nil, fmt.Errorf("некорректный адрес CalDAV-календаря %q", cfg.URL)
+	}
+
+	var httpClient webdav.HTTPClient = &http.Client{Timeout: caldavTimeout}
+	if cfg.Username != "" {
+		httpClient = webdav.HTTPClientWithBasicAuth(httpClient, cfg.Username, cfg.Password)
+	}
+
+	endpoint := calendarURL.Scheme + "://" + calendarURL.Host
+	client, err := caldav.NewClient(httpClient, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось создать CalDAV-клиент: %w", err)
+	}
+
+	calendarPath := calendarURL.Path
+	if !strings.HasSuffix(calendarPath, "/") {
+		calendarPath += "/"
+	}
+
+	return &CalDAVProvider{
+		client:       client,
+		calendarPath: calendarPath,
+		location:     location,
+	}, nil
+}
+
+// AddEvent кладёт событие в <ID>.ics. PUT по тому же пути перезаписывает объект,
+// поэтому повтор с тем же ID не создаёт дубль.
+func (p *CalDAVProvider) AddEvent(ctx context.Context, event Event) (string, error) {
+	if event.ID == "" {
+		event.ID = uuid.NewString()
+	}
+	if _, err := p.client.PutCalendarObject(ctx, p.eventPath(event.ID), newICalendar(event)); err != nil {
+		return "", fmt.Errorf("ошибка при создании события: %w", err)
+	}
+	return event.ID, nil
+}
+
+// UpdateEvent перезаписывает событие. PUT создал бы удалённое мастером событие
+// заново, поэтому сначала проверяется, что оно ещё есть.
+func (p *CalDAVProvider) UpdateEvent(ctx context.Context, event Event) error {
+	if _, err := p.GetEvent(ctx, event.ID); err != nil {
+		return err
+	}
+	if _, err := p.client.PutCalendarObject(ctx, p.eventPath(event.ID), newICalendar(event)); err != nil {
+		return fmt.Errorf("ошибка при обновлении события: %w", err)
+	}
+	return nil
+}
+
+func (p *CalDAVProvider) RemoveEvent(ctx context.Context, eventID string) error {
+	if err := p.client.RemoveAll(ctx, p.eventPath(eventID)); err != nil {
+		// Код ответа клиент не отдаёт, поэтому отсутствие события проверяется отдельно
+		if _, getErr := p.GetEvent(ctx, eventID); errors.Is(getErr, ErrEventNotFound) {
+			return ErrEventNotFound
+		}
+		return fmt.Errorf("ошибка при удалении события: %w", err)
+	}
+	return nil
+}
+
+// GetEvent ищет событие по UID. Объект запрашивается через REPORT, а не GET:
+// так отсутствие события отличается от ошибки сервера.
+func (p *CalDAVProvider) GetEvent(ctx context.Context, eventID string) (Event, error) {
+	objects, err := p.query(ctx, caldav.CompFilter{
+		Name: ical.CompEvent,
+		Props: []caldav.PropFilter{{
+			Name:      ical.PropUID,
+			TextMatch: &caldav.TextMatch{Text: eventID},
+		}},
+	})
+	if err != nil {
+		return Event{}, err
+	}
+
+	for _, object := range objects {
+		for _, item := range object.Data.Events() {
+			// TextMatch ищет подстроку, поэтому UID сверяется точно.
+			// Изменённые вхождения повторяющегося события не нужны.
+			if uid, _ := item.Props.Text(ical.PropUID); uid != eventID || item.Props.Get(ical.PropRecurrenceID) != nil {
+				continue
+			}
+			event, err := parseICalEvent(item, p.location)
+			if err != nil {
+				return Event{}, fmt.Errorf("некорректное событие %s: %w", object.Path, err)
+			}
+			return event, nil
+		}
+	}
+	return Event{}, ErrEventNotFound
+}
+
+// ListEvents возвращает события, пересекающие [from, to). Повторяющиеся события
+// разворачиваются в отдельные вхождения. Событие, которое не удалось разобрать,
+// пропускается, чтобы оно не скрыло остальные.
+func (p *CalDAVProvider) ListEvents(ctx context.Context, from, to time.Time) ([]Event, error) {
+	objects, err := p.query(ctx, caldav.CompFilter{
+		Name:  ical.CompEvent,
+		Start: from.UTC(),
+		End:   to.UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	for _, object := range objects {
+		expanded, err := expandEvents(object.Data, p.location, from, to)
+		if err != nil {
+			config.LoggerFrom(ctx).Warn("skipping unparsable calendar event", "path", object.Path, "error", err)
+			continue
+		}
+		events = append(events, expanded...)
+	}
+	return events, nil
+}
+
+func (p *CalDAVProvider) query(ctx context.Context, eventFilter caldav.CompFilter) ([]caldav.CalendarObject, error) {
+	query := &caldav.CalendarQuery{
+		CompRequest: caldav.CalendarCompRequest{
+			Name:     ical.CompCalendar,
+			AllProps: true,
+			AllComps: true,
+		},
+		CompFilter: caldav.CompFilter{
+			Name:  ical.CompCalendar,
+			Comps: []caldav.CompFilter{eventFilter},
+		},
+	}
+
+	objects, err := p.client.QueryCalendar(ctx, p.calendarPath, query)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при получении событий: %w", err)
+	}
+
+	withData := objects[:0]
+	for _, object := range objects {
+		if object.Data != nil {
+			withData = append(withData, object)
+		}
+	}
+	return withData, nil
+}
+
+// Ping проверяет, что коллекция календаря существует и доступна
+func (p *CalDAVProvider) Ping(ctx context.Context) error {
+	if _, err := p.client.Stat(ctx, p.calendarPath); err != nil {
+		return fmt.Errorf("календарь недоступен: %w", err)
+	}
+	return nil
+}
+
+func (p *CalDAVProvider) eventPath(eventID string) string {
+	return path.Join(p.calendarPath, url.PathEscape(eventID)+".ics")
+}
+
+func newICalendar(event Event) *ical.Calendar {
+	vevent := ical.NewEvent()
+	vevent.Props.SetText(ical.PropUID, event.ID)
+	vevent.Props.SetDateTime(ical.PropDateTimeStamp, time.Now().UTC())
+	vevent.Props.SetDateTime(ical.PropDateTimeStart, event.Start.UTC())
+	vevent.Props.SetDateTime(ical.PropDateTimeEnd, event.End.UTC())
+	vevent.Props.SetText(ical.PropSummary, event.summary())
+	if event.Description != "" {
+		vevent.Props.SetText(ical.PropDescription, event.Description)
+	}
+	if event.Location != "" {
+		vevent.Props.SetText(ical.PropLocation, event.Location)
+	}
+	if event.Confirmed {
+		vevent.SetStatus(ical.EventConfirmed)
+	} else {
+		vevent.SetStatus(ical.EventTentative)
+	}
+
+	cal := ical.NewCalendar()
+	cal.Props.SetText(ical.PropVersion, "2.0")
+	cal.Props.SetText(ical.PropProductID, caldavProductID)
+	cal.Children = append(cal.Children, vevent.Component)
+	return cal
+}
+
+// expandEvents возвращает события объекта календаря, пересекающие [from, to).
+// Повторяющееся событие даёт по событию на вхождение с ID "<UID>_<начало в UTC>".
+// Изменённые вхождения (RECURRENCE-ID) заменяют вычисленные, отменённые пропадают.
+func expandEvents(cal *ical.Calendar, location *time.Location, from, to time.Time) ([]Event, error) {
+	var events []Event
+	overridden := make(map[string]bool)
+
+	for _, item := range cal.Events() {
+		if item.Props.Get(ical.PropRecurrenceID) == nil {
+			continue
+		}
+		recurrenceID, err := item.Props.DateTime(ical.PropRecurrenceID, location)
+		if err != nil {
+			return nil, err
+		}
+		event, err := parseICalEvent(item, location)
+		if err != nil {
+			return nil, err
+		}
+		event.ID = occurrenceID(event.ID, recurrenceID)
+		overridden[event.ID] = true
+
+		if status, _ := item.Status(); status == ical.EventCancelled {
+			continue
+		}
+		if event.Start.Before(to) && event.End.After(from) {
+			events = append(events, event)
+		}
+	}
+
+	for _, item := range cal.Events() {
+		if item.Props.Get(ical.PropRecurrenceID) != nil {
+			continue
+		}
+		event, err := parseICalEvent(item, location)
+		if err != nil {
+			return nil, err
+		}
+
+		set, err := recurrenceSet(item, event.Start, location)
+		if err != nil {
+			return nil, err
+		}
+		if set == nil {
+			if event.Start.Before(to) && event.End.After(from) {
+				events = append(events, event)
+			}
+			continue
+		}
+
+		duration := event.End.Sub(event.Start)
+		starts := set.Between(from.Add(-duration), to, true)
+		if len(starts) > caldavMaxOccurrences {
+			starts = starts[:caldavMaxOccurrences]
+		}
+		for _, start := range starts {
+			occurrence := event
+			occurrence.ID = occurrenceID(event.ID, start)
+			occurrence.Start = start.In(location)
+			occurrence.End = occurrence.Start.Add(duration)
+			// Between включает обе границы, а нужен полуинтервал
+			if overridden[occurrence.ID] || !occurrence.End.After(from) || !occurrence.Start.Before(to) {
+				continue
+			}
+			events = append(events, occurrence)
+		}
+	}
+	return events, nil
+}
+
+// recurrenceSet собирает правило повторения события или возвращает nil для
+// разового события. RDATE и EXDATE могут перечислять несколько дат через запятую.
+func recurrenceSet(vevent ical.Event, start time.Time, location *time.Location) (*rrule.Set, error) {
+	option, err := vevent.Props.RecurrenceRule()
+	if err != nil || option == nil {
+		return nil, err
+	}
+	option.Dtstart = start
+	rule, err := rrule.NewRRule(*option)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &rrule.Set{}
+	set.RRule(rule)
+	for _, name := range []string{ical.PropRecurrenceDates, ical.PropExceptionDates} {
+		for _, prop := range vevent.Props[name] {
+			for _, value := range strings.Split(prop.Value, ",") {
+				single := prop
+				single.Value = value
+				date, err := single.DateTime(location)
+				if err != nil {
+					return nil, err
+				}
+				if name == ical.PropRecurrenceDates {
+					set.RDate(date)
+				} else {
+					set.ExDate(date)
+				}
+			}
+		}
+	}
+	return set, nil
+}
+
+func occurrenceID(uid string, start time.Time) string {
+	return uid + "_" + start.UTC().Format(caldavOccurrenceFormat)
+}
+
+func parseICalEvent(vevent ical.Event, location *time.Location) (Event, error) {
+	id, err := vevent.Props.Text(ical.PropUID)
+	if err != nil {
+		return Event{}, err
+	}
+	// Даты без часового пояса считаются временем салона
+	start, err := vevent.DateTimeStart(location)
+	if err != nil {
+		return Event{}, err
+	}
+	end, err := vevent.DateTimeEnd(location)
+	if err != nil {
+		return Event{}, err
+	}
+
+	summary, _ := vevent.Props.Text(ical.PropSummary)
+	description, _ := vevent.Props.Text(ical.PropDescription)
+	eventLocation, _ := vevent.Props.Text(ical.PropLocation)
+	status, _ := vevent.Status()
+	transparency, _ := vevent.Props.Text(ical.PropTransparency)
+
+	return Event{
+		ID:          id,
+		Summary:     strings.TrimPrefix(summary, confirmedPrefix),
+		Description: description,
+		Location:    eventLocation,
+		Start:       start.In(location),
+		End:         end.In(location),
+		Confirmed:   status == ical.EventConfirmed,
+		Free:        strings.EqualFold(transparency, "TRANSPARENT"),
+	}, nil
+}
@@ -0,0 +1,450 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RudinMaxim/BarberBot.git/config"
+	"github.com/emersion/go-ical"
+	"github.com/emersion/go-webdav"
+	"github.com/emersion/go-webdav/caldav"
+)
+
+const testCalendarPath = "/dav/calendars/master/"
+
+// memoryBackend CalDAV-сервер в памяти. Как и настоящие серверы, он отдаёт
+// объекты, которые сам не смог разобрать, а не роняет весь запрос.
+type memoryBackend struct {
+	mu      sync.Mutex
+	objects map[string]*ical.Calendar
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{objects: make(map[string]*ical.Calendar)}
+}
+
+func (b *memoryBackend) CurrentUserPrincipal(ctx context.Context) (string, error) {
+	return "/dav/", nil
+}
+
+func (b *memoryBackend) CalendarHomeSetPath(ctx context.Context) (string, error) {
+	return "/dav/calendars/", nil
+}
+
+func (b *memoryBackend) ListCalendars(ctx context.Context) ([]caldav.Calendar, error) {
+	return []caldav.Calendar{b.calendar()}, nil
+}
+
+func (b *memoryBackend) GetCalendar(ctx context.Context, path string) (*caldav.Calendar, error) {
+	if path != testCalendarPath {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("calendar %s not found", path))
+	}
+	cal := b.calendar()
+	return &cal, nil
+}
+
+func (b *memoryBackend) calendar() caldav.Calendar {
+	return caldav.Calendar{Path: testCalendarPath, Name: "master", SupportedComponentSet: []string{ical.CompEvent}}
+}
+
+func (b *memoryBackend) GetCalendarObject(ctx context.Context, path string, req *caldav.CalendarCompRequest) (*caldav.CalendarObject, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data, ok := b.objects[path]
+	if !ok {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("object %s not found", path))
+	}
+	return newCalendarObject(path, data), nil
+}
+
+func (b *memoryBackend) ListCalendarObjects(ctx context.Context, path string, req *caldav.CalendarCompRequest) ([]caldav.CalendarObject, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	objects := make([]caldav.CalendarObject, 0, len(b.objects))
+	for objectPath, data := range b.objects {
+		objects = append(objects, *newCalendarObject(objectPath, data))
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Path < objects[j].Path })
+	return objects, nil
+}
+
+func (b *memoryBackend) QueryCalendarObjects(ctx context.Context, query *caldav.CalendarQuery) ([]caldav.CalendarObject, error) {
+	all, err := b.ListCalendarObjects(ctx, testCalendarPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []caldav.CalendarObject
+	for _, object := range all {
+		ok, err := caldav.Match(query.CompFilter, &object)
+		if ok || err != nil {
+			matched = append(matched, object)
+		}
+	}
+	return matched, nil
+}
+
+func (b *memoryBackend) PutCalendarObject(ctx context.Context, path string, data *ical.Calendar, opts *caldav.PutCalendarObjectOptions) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.objects[path] = data
+	return path, nil
+}
+
+func (b *memoryBackend) DeleteCalendarObject(ctx context.Context, path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.objects[path]; !ok {
+		return webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("object %s not found", path))
+	}
+	delete(b.objects, path)
+	return nil
+}
+
+func (b *memoryBackend) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.objects)
+}
+
+// putRaw кладёт объект мимо клиента, как если бы его создал мастер
+func (b *memoryBackend) putRaw(t *testing.T, name string, data string) {
+	t.Helper()
+
+	cal, err := ical.NewDecoder(strings.NewReader(strings.ReplaceAll(data, "\n", "\r\n"))).Decode()
+	if err != nil {
+		t.Fatalf("decoding %s: %v", name, err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[testCalendarPath+name] = cal
+}
+
+func newCalendarObject(path string, data *ical.Calendar) *caldav.CalendarObject {
+	return &caldav.CalendarObject{
+		Path:    path,
+		ModTime: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		ETag:    fmt.Sprintf("%q", path),
+		Data:    data,
+	}
+}
+
+func newTestCalDAV(t *testing.T, location *time.Location) (*CalDAVProvider, *memoryBackend) {
+	t.Helper()
+
+	backend := newMemoryBackend()
+	server := httptest.NewServer(&caldav.Handler{Backend: backend})
+	t.Cleanup(server.Close)
+
+	provider, err := NewCalDAVProvider(config.CalDAV{URL: server.URL + testCalendarPath}, location)
+	if err != nil {
+		t.Fatalf("NewCalDAVProvider: %v", err)
+	}
+	return provider, backend
+}
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("loading %s: %v", name, err)
+	}
+	return location
+}
+
+func TestCalDAVEventLifecycle(t *testing.T) {
+	ctx := context.Background()
+	location := mustLoadLocation(t, "Europe/Berlin")
+	provider, backend := newTestCalDAV(t, location)
+
+	if err := provider.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+
+	event := Event{
+		ID:          "appt0123456789abcdef",
+		Summary:     "Стрижка",
+		Description: "Клиент: Иван",
+		Location:    "Салон",
+		Start:       time.Date(2024, 3, 5, 10, 0, 0, 0, location),
+		End:         time.Date(2024, 3, 5, 11, 0, 0, 0, location),
+	}
+
+	id, err := provider.AddEvent(ctx, event)
+	if err != nil {
+		t.Fatalf("AddEvent: %v", err)
+	}
+	if id != event.ID {
+		t.Fatalf("AddEvent ID = %q, want preset %q", id, event.ID)
+	}
+	// Повтор после сбоя перезаписывает то же событие
+	if _, err := provider.AddEvent(ctx, event); err != nil {
+		t.Fatalf("repeated AddEvent: %v", err)
+	}
+	if backend.count() != 1 {
+		t.Fatalf("calendar has %d objects after repeated AddEvent, want 1", backend.count())
+	}
+
+	got, err := provider.GetEvent(ctx, event.ID)
+	if err != nil {
+		t.Fatalf("GetEvent: %v", err)
+	}
+	assertEvent(t, got, event)
+
+	event.Summary = "Стрижка и борода"
+	event.Start = event.Start.Add(time.Hour)
+	event.End = event.End.Add(time.Hour)
+	event.Confirmed = true
+	if err := provider.UpdateEvent(ctx, event); err != nil {
+		t.Fatalf("UpdateEvent: %v", err)
+	}
+	got, err = provider.GetEvent(ctx, event.ID)
+	if err != nil {
+		t.Fatalf("GetEvent after update: %v", err)
+	}
+	assertEvent(t, got, event)
+
+	if err := provider.RemoveEvent(ctx, event.ID); err != nil {
+		t.Fatalf("RemoveEvent: %v", err)
+	}
+	if backend.count() != 0 {
+		t.Fatalf("calendar has %d objects after RemoveEvent, want 0", backend.count())
+	}
+}
+
+func TestCalDAVEventNotFound(t *testing.T) {
+	ctx := context.Background()
+	location := mustLoadLocation(t, "Europe/Berlin")
+	provider, backend := newTestCalDAV(t, location)
+
+	// UID, в котором искомый ID лишь подстрока, не должен совпасть
+	backend.putRaw(t, "other.ics", `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//RU
+BEGIN:VEVENT
+UID:appt42-other
+DTSTAMP:20240301T000000Z
+DTSTART:20240305T090000Z
+DTEND:20240305T100000Z
+SUMMARY:Чужое
+END:VEVENT
+END:VCALENDAR
+`)
+
+	if _, err := provider.GetEvent(ctx, "appt42"); !errors.Is(err, ErrEventNotFound) {
+		t.Errorf("GetEvent error = %v, want ErrEventNotFound", err)
+	}
+	if err := provider.RemoveEvent(ctx, "appt42"); !errors.Is(err, ErrEventNotFound) {
+		t.Errorf("RemoveEvent error = %v, want ErrEventNotFound", err)
+	}
+
+	missing := Event{
+		ID:    "appt42",
+		Start: time.Date(2024, 3, 5, 10, 0, 0, 0, location),
+		End:   time.Date(2024, 3, 5, 11, 0, 0, 0, location),
+	}
+	if err := provider.UpdateEvent(ctx, missing); !errors.Is(err, ErrEventNotFound) {
+		t.Errorf("UpdateEvent error = %v, want ErrEventNotFound", err)
+	}
+	if backend.count() != 1 {
+		t.Errorf("UpdateEvent of a removed event recreated it: %d objects", backend.count())
+	}
+}
+
+func TestCalDAVAddEventAssignsID(t *testing.T) {
+	ctx := context.Background()
+	location := mustLoadLocation(t, "Europe/Berlin")
+	provider, _ := newTestCalDAV(t, location)
+
+	id, err := provider.AddEvent(ctx, Event{
+		Summary: "Без ID",
+		Start:   time.Date(2024, 3, 5, 10, 0, 0, 0, location),
+		End:     time.Date(2024, 3, 5, 11, 0, 0, 0, location),
+	})
+	if err != nil {
+		t.Fatalf("AddEvent: %v", err)
+	}
+	if id == "" {
+		t.Fatal("AddEvent returned an empty ID")
+	}
+	if _, err := provider.GetEvent(ctx, id); err != nil {
+		t.Errorf("GetEvent(%q): %v", id, err)
+	}
+}
+
+func TestCalDAVListEvents(t *testing.T) {
+	ctx := context.Background()
+	location := mustLoadLocation(t, "Europe/Berlin")
+	provider, backend := newTestCalDAV(t, location)
+
+	backend.putRaw(t, "single.ics", `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//RU
+BEGIN:VEVENT
+UID:single
+DTSTAMP:20240301T000000Z
+DTSTART;TZID=Europe/Berlin:20240306T120000
+DTEND;TZID=Europe/Berlin:20240306T130000
+SUMMARY:Обед
+TRANSP:TRANSPARENT
+END:VEVENT
+END:VCALENDAR
+`)
+	backend.putRaw(t, "outside.ics", `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//RU
+BEGIN:VEVENT
+UID:outside
+DTSTAMP:20240301T000000Z
+DTSTART:20240320T090000Z
+DTEND:20240320T100000Z
+SUMMARY:Позже
+END:VEVENT
+END:VCALENDAR
+`)
+	// Еженедельно по понедельникам с февраля: 4 марта исключено, 11 марта
+	// перенесено на вторник, 18 марта отменено
+	backend.putRaw(t, "weekly.ics", `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//RU
+BEGIN:VEVENT
+UID:weekly
+DTSTAMP:20240301T000000Z
+DTSTART;TZID=Europe/Berlin:20240205T090000
+DTEND;TZID=Europe/Berlin:20240205T100000
+RRULE:FREQ=WEEKLY;BYDAY=MO
+EXDATE;TZID=Europe/Berlin:20240304T090000
+SUMMARY:Спортзал
+END:VEVENT
+BEGIN:VEVENT
+UID:weekly
+DTSTAMP:20240301T000000Z
+RECURRENCE-ID;TZID=Europe/Berlin:20240311T090000
+DTSTART;TZID=Europe/Berlin:20240312T150000
+DTEND;TZID=Europe/Berlin:20240312T160000
+SUMMARY:Спортзал (перенос)
+END:VEVENT
+BEGIN:VEVENT
+UID:weekly
+DTSTAMP:20240301T000000Z
+RECURRENCE-ID;TZID=Europe/Berlin:20240318T090000
+DTSTART;TZID=Europe/Berlin:20240318T090000
+DTEND;TZID=Europe/Berlin:20240318T100000
+STATUS:CANCELLED
+SUMMARY:Спортзал
+END:VEVENT
+END:VCALENDAR
+`)
+	backend.putRaw(t, "broken.ics", `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//RU
+BEGIN:VEVENT
+UID:broken
+DTSTAMP:20240301T000000Z
+DTSTART:not-a-date
+DTEND:20240306T100000Z
+SUMMARY:Сломано
+END:VEVENT
+END:VCALENDAR
+`)
+
+	from := time.Date(2024, 3, 4, 0, 0, 0, 0, location)
+	to := time.Date(2024, 3, 19, 0, 0, 0, 0, location)
+	events, err := provider.ListEvents(ctx, from, to)
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+
+	got := make(map[string]Event, len(events))
+	for _, event := range events {
+		got[event.ID] = event
+	}
+
+	want := []Event{
+		{
+			ID:      "single",
+			Summary: "Обед",
+			Start:   time.Date(2024, 3, 6, 12, 0, 0, 0, location),
+			End:     time.Date(2024, 3, 6, 13, 0, 0, 0, location),
+			Free:    true,
+		},
+		{
+			ID:      "weekly_20240311T080000Z",
+			Summary: "Спортзал (перенос)",
+			Start:   time.Date(2024, 3, 12, 15, 0, 0, 0, location),
+			End:     time.Date(2024, 3, 12, 16, 0, 0, 0, location),
+		},
+	}
+	if len(events) != len(want) {
+		t.Errorf("ListEvents returned %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for _, w := range want {
+		event, ok := got[w.ID]
+		if !ok {
+			t.Errorf("event %s is missing", w.ID)
+			continue
+		}
+		assertEvent(t, event, w)
+	}
+}
+
+func TestCalDAVListEventsAcrossDST(t *testing.T) {
+	ctx := context.Background()
+	location := mustLoadLocation(t, "Europe/Berlin")
+	provider, backend := newTestCalDAV(t, location)
+
+	backend.putRaw(t, "daily.ics", `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//RU
+BEGIN:VEVENT
+UID:daily
+DTSTAMP:20240301T000000Z
+DTSTART;TZID=Europe/Berlin:20240329T100000
+DTEND;TZID=Europe/Berlin:20240329T110000
+RRULE:FREQ=DAILY;COUNT=5
+SUMMARY:Перерыв
+END:VEVENT
+END:VCALENDAR
+`)
+
+	events, err := provider.ListEvents(ctx,
+		time.Date(2024, 3, 29, 0, 0, 0, 0, location),
+		time.Date(2024, 4, 5, 0, 0, 0, 0, location))
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	if len(events) != 5 {
+		t.Fatalf("ListEvents returned %d occurrences, want 5", len(events))
+	}
+	for _, event := range events {
+		// Время на часах салона не сдвигается после перехода на летнее время 31 марта
+		if event.Start.Hour() != 10 || event.End.Sub(event.Start) != time.Hour {
+			t.Errorf("occurrence %s starts at %s, want 10:00 for one hour", event.ID, event.Start)
+		}
+	}
+}
+
+func assertEvent(t *testing.T, got, want Event) {
+	t.Helper()
+
+	if got.ID != want.ID || got.Summary != want.Summary || got.Description != want.Description ||
+		got.Location != want.Location || got.Confirmed != want.Confirmed || got.Free != want.Free {
+		t.Errorf("event = %+v, want %+v", got, want)
+	}
+	if !got.Start.Equal(want.Start) || !got.End.Equal(want.End) {
+		t.Errorf("event %s runs %s - %s, want %s - %s", want.ID, got.Start, got.End, want.Start, want.End)
+	}
+}
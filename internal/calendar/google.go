@@ -0,0 +1,459 @@
+package calendar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RudinMaxim/BarberBot.git/config"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+const (
+	defaultColorID   = "5"
+	confirmedColorID = "10"
+)
+
+const (
+	// GoogleOAuthCallbackPath адрес, на который Google возвращает мастера после согласия
+	GoogleOAuthCallbackPath = "/oauth/google/callback"
+	// oauthStateTTL сколько действует ссылка на согласие
+	oauthStateTTL = 15 * time.Minute
+)
+
+// ErrNotAuthorized мастер ещё не выдал доступ к календарю
+var ErrNotAuthorized = errors.New("google calendar is not authorized, run /calendar")
+
+// GoogleCalendarService провайдер Google Calendar. С OAuth до согласия мастера
+// сервис создаётся без клиента, и все операции возвращают ErrNotAuthorized.
+type GoogleCalendarService struct {
+	calendarID string
+	location   *time.Location
+	// oauth и tokens заданы только при auth: oauth
+	oauth  *oauth2.Config
+	tokens TokenStore
+
+	mu     sync.RWMutex
+	client *calendar.Service
+	// states выданные ссылки на согласие и срок их действия
+	states map[string]time.Time
+}
+
+func NewGoogleCalendarService(cfg config.GoogleCalendar, location *time.Location, tokens TokenStore) (*GoogleCalendarService, error) {
+	if cfg.Auth == config.GoogleAuthServiceAccount {
+		return newServiceAccountCalendar(cfg, location)
+	}
+
+	b, err := os.ReadFile(cfg.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать файл credentials: %w", err)
+	}
+
+	oauthConfig, err := google.ConfigFromJSON(b, calendar.CalendarScope)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось разобрать файл credentials: %w", err)
+	}
+	if cfg.RedirectURL != "" {
+		oauthConfig.RedirectURL = cfg.RedirectURL
+	}
+
+	g := &GoogleCalendarService{
+		calendarID: cfg.CalendarID,
+		location:   location,
+		oauth:      oauthConfig,
+		tokens:     tokens,
+		states:     make(map[string]time.Time),
+	}
+
+	ctx := context.Background()
+	token, err := g.loadToken(ctx, cfg.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		slog.Warn("google calendar is not authorized, an admin has to run /calendar")
+		return g, nil
+	}
+
+	if err := g.connect(ctx, token); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// newServiceAccountCalendar подключается ключом сервисного аккаунта, согласие мастера не нужно
+func newServiceAccountCalendar(cfg config.GoogleCalendar, location *time.Location) (*GoogleCalendarService, error) {
+	ctx := context.Background()
+	b, err := os.ReadFile(cfg.ServiceAccountFile)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать ключ сервисного аккаунта: %w", err)
+	}
+
+	credentials, err := google.CredentialsFromJSON(ctx, b, calendar.CalendarScope)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось разобрать ключ сервисного аккаунта: %w", err)
+	}
+
+	srv, err := calendar.NewService(ctx, option.WithCredentials(credentials))
+	if err != nil {
+		return nil, fmt.Errorf("не удалось инициализировать сервис календаря: %w", err)
+	}
+
+	return &GoogleCalendarService{
+		calendarID: cfg.CalendarID,
+		location:   location,
+		client:     srv,
+	}, nil
+}
+
+// loadToken читает токен из базы. При первом запуске переносит туда токен
+// из token_file, оставшийся от прежней версии.
+func (g *GoogleCalendarService) loadToken(ctx context.Context, tokenFile string) (*oauth2.Token, error) {
+	token, err := g.tokens.LoadToken(ctx)
+	if err != nil || token != nil {
+		return token, err
+	}
+	if tokenFile == "" {
+		return nil, nil
+	}
+
+	token, err = tokenFromFile(tokenFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать %s: %w", tokenFile, err)
+	}
+
+	if err := g.tokens.SaveToken(ctx, token); err != nil {
+		return nil, err
+	}
+	slog.Info("google calendar token moved to the database, the file can be deleted", "path", tokenFile)
+	return token, nil
+}
+
+func tokenFromFile(file string) (*oauth2.Token, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tok := &oauth2.Token{}
+	err = json.NewDecoder(f).Decode(tok)
+	return tok, err
+}
+
+// connect создаёт клиент календаря. Обновлённые токены сохраняются в базу.
+func (g *GoogleCalendarService) connect(ctx context.Context, token *oauth2.Token) error {
+	source := oauth2.ReuseTokenSource(token, &persistingTokenSource{
+		source: g.oauth.TokenSource(context.Background(), token),
+		store:  g.tokens,
+		last:   token,
+	})
+
+	srv, err := calendar.NewService(ctx, option.WithTokenSource(source))
+	if err != nil {
+		return fmt.Errorf("не удалось инициализировать сервис календаря: %w", err)
+	}
+
+	g.mu.Lock()
+	g.client = srv
+	g.mu.Unlock()
+	return nil
+}
+
+// AuthURL выдаёт ссылку на согласие. prompt=consent нужен, чтобы Google
+// выдал refresh token и при повторной авторизации.
+func (g *GoogleCalendarService) AuthURL() string {
+	state := uuid.NewString()
+	now := time.Now()
+
+	g.mu.Lock()
+	for s, expiresAt := range g.states {
+		if now.After(expiresAt) {
+			delete(g.states, s)
+		}
+	}
+	g.states[state] = now.Add(oauthStateTTL)
+	g.mu.Unlock()
+
+	return g.oauth.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+}
+
+// CheckState проверяет, что state выдан AuthURL и ещё действует. Каждый state одноразовый.
+func (g *GoogleCalendarService) CheckState(state string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	expiresAt, ok := g.states[state]
+	delete(g.states, state)
+	return ok && time.Now().Before(expiresAt)
+}
+
+// Authorize обменивает код согласия на токен, сохраняет его и подключает календарь
+func (g *GoogleCalendarService) Authorize(ctx context.Context, code string) error {
+	token, err := g.oauth.Exchange(ctx, code)
+	if err != nil {
+		return fmt.Errorf("не удалось получить токен: %w", err)
+	}
+	if err := g.tokens.SaveToken(ctx, token); err != nil {
+		return err
+	}
+	if err := g.connect(ctx, token); err != nil {
+		return err
+	}
+
+	config.LoggerFrom(ctx).Info("google calendar authorized")
+	return nil
+}
+
+func (g *GoogleCalendarService) Authorized() bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.client != nil
+}
+
+func (g *GoogleCalendarService) service() (*calendar.Service, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.client == nil {
+		return nil, ErrNotAuthorized
+	}
+	return g.client, nil
+}
+
+func (g *GoogleCalendarService) AddEvent(ctx context.Context, event Event) (string, error) {
+	srv, err := g.service()
+	if err != nil {
+		return "", err
+	}
+
+	// Google принимает ID от клиента; 409 значит, что событие с этим ID уже есть
+	created, err := srv.Events.Insert(g.calendarID, g.apply(&calendar.Event{Id: event.ID}, event)).Context(ctx).Do()
+	if event.ID != "" && apiErrorCode(err) == http.StatusConflict {
+		return event.ID, g.restoreEvent(ctx, srv, event)
+	}
+	if err != nil {
+		return "", fmt.Errorf("ошибка при создании события: %w", err)
+	}
+
+	return created.Id, nil
+}
+
+// restoreEvent переписывает событие, которое уже создала прошлая попытка. Удалённое
+// событие Google хранит со статусом cancelled и не даёт создать заново с тем же ID,
+// поэтому его нужно вернуть, иначе запись пропадёт из календаря.
+func (g *GoogleCalendarService) restoreEvent(ctx context.Context, srv *calendar.Service, event Event) error {
+	existing, err := srv.Events.Get(g.calendarID, event.ID).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("не удалось найти существующее событие: %w", err)
+	}
+
+	restored := existing.Status == "cancelled"
+	existing.Status = "confirmed"
+	if _, err := srv.Events.Update(g.calendarID, event.ID, g.apply(existing, event)).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("ошибка при обновлении существующего события: %w", err)
+	}
+
+	if restored {
+		config.LoggerFrom(ctx).Info("cancelled calendar event restored", "event_id", event.ID)
+	}
+	return nil
+}
+
+// UpdateEvent меняет только поля из Event, остальное (напоминания, гости) сохраняется
+func (g *GoogleCalendarService) UpdateEvent(ctx context.Context, event Event) error {
+	srv, err := g.service()
+	if err != nil {
+		return err
+	}
+
+	existing, err := srv.Events.Get(g.calendarID, event.ID).Context(ctx).Do()
+	if isGone(err) {
+		return ErrEventNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("не удалось найти событие: %w", err)
+	}
+	if existing.Status == "cancelled" {
+		return ErrEventNotFound
+	}
+
+	if _, err := srv.Events.Update(g.calendarID, event.ID, g.apply(existing, event)).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("ошибка при обновлении события: %w", err)
+	}
+
+	config.LoggerFrom(ctx).Info("calendar event updated", "event_id", event.ID)
+	return nil
+}
+
+func (g *GoogleCalendarService) RemoveEvent(ctx context.Context, eventID string) error {
+	srv, err := g.service()
+	if err != nil {
+		return err
+	}
+
+	err = srv.Events.Delete(g.calendarID, eventID).Context(ctx).Do()
+	if isGone(err) {
+		return ErrEventNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка при удалении события: %w", err)
+	}
+	config.LoggerFrom(ctx).Info("calendar event removed", "event_id", eventID)
+	return nil
+}
+
+// GetEvent возвращает событие по ID. Удалённые события Google отдаёт со статусом
+// cancelled или ошибкой 404/410, в обоих случаях возвращается ErrEventNotFound.
+func (g *GoogleCalendarService) GetEvent(ctx context.Context, eventID string) (Event, error) {
+	srv, err := g.service()
+	if err != nil {
+		return Event{}, err
+	}
+
+	item, err := srv.Events.Get(g.calendarID, eventID).Context(ctx).Do()
+	if isGone(err) {
+		return Event{}, ErrEventNotFound
+	}
+	if err != nil {
+		return Event{}, fmt.Errorf("ошибка при получении события: %w", err)
+	}
+	if item.Status == "cancelled" {
+		return Event{}, ErrEventNotFound
+	}
+
+	return newGoogleEvent(item, g.location)
+}
+
+// ListEvents возвращает события, пересекающие [from, to). Повторяющиеся события
+// разворачиваются в отдельные вхождения.
+func (g *GoogleCalendarService) ListEvents(ctx context.Context, from, to time.Time) ([]Event, error) {
+	srv, err := g.service()
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	err = srv.Events.List(g.calendarID).
+		TimeMin(from.Format(time.RFC3339)).
+		TimeMax(to.Format(time.RFC3339)).
+		SingleEvents(true).
+		OrderBy("startTime").
+		Pages(ctx, func(page *calendar.Events) error {
+			for _, item := range page.Items {
+				if item.Status == "cancelled" {
+					continue
+				}
+				event, err := newGoogleEvent(item, g.location)
+				if err != nil {
+					config.LoggerFrom(ctx).Warn("skipping unparsable calendar event", "event_id", item.Id, "error", err)
+					continue
+				}
+				events = append(events, event)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при получении событий: %w", err)
+	}
+
+	return events, nil
+}
+
+// Ping проверяет, что календарь доступен с текущим токеном
+func (g *GoogleCalendarService) Ping(ctx context.Context) error {
+	srv, err := g.service()
+	if err != nil {
+		return err
+	}
+
+	if _, err := srv.Calendars.Get(g.calendarID).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("календарь недоступен: %w", err)
+	}
+	return nil
+}
+
+// apply переносит поля Event в событие Google. Подтверждённые визиты
+// выделяются цветом и отметкой в заголовке, чтобы мастер видел их в календаре.
+// Время пишется в поясе салона со смещением на эту дату, чтобы событие
+// не съезжало при переводе часов.
+func (g *GoogleCalendarService) apply(target *calendar.Event, event Event) *calendar.Event {
+	target.Summary = event.summary()
+	target.Description = event.Description
+	target.Location = event.Location
+	target.Start = &calendar.EventDateTime{
+		DateTime: event.Start.In(g.location).Format(time.RFC3339),
+		TimeZone: g.location.String(),
+	}
+	target.End = &calendar.EventDateTime{
+		DateTime: event.End.In(g.location).Format(time.RFC3339),
+		TimeZone: g.location.String(),
+	}
+	target.ColorId = defaultColorID
+	if event.Confirmed {
+		target.ColorId = confirmedColorID
+	}
+	return target
+}
+
+// apiErrorCode возвращает HTTP-код ошибки Google API или 0
+func apiErrorCode(err error) int {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return 0
+}
+
+// isGone сообщает, что события нет: Google отвечает 404 или 410 для удалённых
+func isGone(err error) bool {
+	code := apiErrorCode(err)
+	return code == http.StatusNotFound || code == http.StatusGone
+}
+
+func newGoogleEvent(item *calendar.Event, location *time.Location) (Event, error) {
+	start, err := parseGoogleTime(item.Start, location)
+	if err != nil {
+		return Event{}, err
+	}
+	end, err := parseGoogleTime(item.End, location)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		ID:          item.Id,
+		Summary:     strings.TrimPrefix(item.Summary, confirmedPrefix),
+		Description: item.Description,
+		Location:    item.Location,
+		Start:       start.In(location),
+		End:         end.In(location),
+		Confirmed:   item.ColorId == confirmedColorID,
+		Free:        item.Transparency == "transparent",
+	}, nil
+}
+
+// parseGoogleTime разбирает время события. У событий на весь день есть только
+// дата, она считается в часовом поясе салона.
+func parseGoogleTime(value *calendar.EventDateTime, location *time.Location) (time.Time, error) {
+	if value == nil {
+		return time.Time{}, fmt.Errorf("событие без времени")
+	}
+	if value.DateTime != "" {
+		return time.Parse(time.RFC3339, value.DateTime)
+	}
+	return time.ParseInLocation("2006-01-02", value.Date, location)
+}
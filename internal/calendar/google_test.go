@@ -0,0 +1,181 @@
+package calendar
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+// fakeGoogleCalendar API Google Calendar в памяти. Как и Google, он хранит
+// удалённые события со статусом cancelled и отвечает 409 на вставку с их ID.
+type fakeGoogleCalendar struct {
+	mu     sync.Mutex
+	events map[string]*calendar.Event
+	order  []string
+}
+
+func (f *fakeGoogleCalendar) put(event *calendar.Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.events[event.Id]; !ok {
+		f.order = append(f.order, event.Id)
+	}
+	f.events[event.Id] = event
+}
+
+func (f *fakeGoogleCalendar) get(id string) *calendar.Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.events[id]
+}
+
+func (f *fakeGoogleCalendar) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const prefix = "/calendars/primary/events"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		list := &calendar.Events{}
+		for _, id := range f.order {
+			list.Items = append(list.Items, f.events[id])
+		}
+		json.NewEncoder(w).Encode(list)
+	case id == "" && r.Method == http.MethodPost:
+		var event calendar.Event
+		json.NewDecoder(r.Body).Decode(&event)
+		if _, ok := f.events[event.Id]; ok {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": 409, "message": "The requested identifier already exists."}})
+			return
+		}
+		f.events[event.Id] = &event
+		f.order = append(f.order, event.Id)
+		json.NewEncoder(w).Encode(&event)
+	case r.Method == http.MethodGet:
+		event, ok := f.events[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": 404, "message": "Not Found"}})
+			return
+		}
+		json.NewEncoder(w).Encode(event)
+	case r.Method == http.MethodPut:
+		var event calendar.Event
+		json.NewDecoder(r.Body).Decode(&event)
+		event.Id = id
+		f.events[id] = &event
+		json.NewEncoder(w).Encode(&event)
+	default:
+		http.Error(w, "unexpected request", http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestGoogleCalendar(t *testing.T, location *time.Location) (*GoogleCalendarService, *fakeGoogleCalendar) {
+	t.Helper()
+	fake := &fakeGoogleCalendar{events: make(map[string]*calendar.Event)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	srv, err := calendar.NewService(context.Background(),
+		option.WithEndpoint(server.URL+"/"),
+		option.WithHTTPClient(server.Client()),
+	)
+	if err != nil {
+		t.Fatalf("create calendar service: %v", err)
+	}
+	return &GoogleCalendarService{calendarID: "primary", location: location, client: srv}, fake
+}
+
+func TestGoogleAddEventRestoresCancelledEvent(t *testing.T) {
+	location, _ := time.LoadLocation("Asia/Yekaterinburg")
+	g, fake := newTestGoogleCalendar(t, location)
+	ctx := context.Background()
+
+	start := time.Date(2024, 5, 20, 10, 0, 0, 0, location)
+	event := Event{ID: "barberbot1", Summary: "Стрижка", Start: start, End: start.Add(time.Hour)}
+
+	// Прошлая попытка создала событие, а мастер его удалил
+	fake.put(&calendar.Event{Id: event.ID, Status: "cancelled", Summary: "Старое"})
+
+	id, err := g.AddEvent(ctx, event)
+	if err != nil {
+		t.Fatalf("AddEvent: %v", err)
+	}
+	if id != event.ID {
+		t.Errorf("AddEvent id = %q, want %q", id, event.ID)
+	}
+
+	stored := fake.get(event.ID)
+	if stored.Status != "confirmed" || stored.Summary != "Стрижка" {
+		t.Errorf("event after AddEvent = %q %q, want the cancelled event restored", stored.Status, stored.Summary)
+	}
+	if _, err := g.GetEvent(ctx, event.ID); err != nil {
+		t.Errorf("GetEvent after restore: %v", err)
+	}
+}
+
+func TestGoogleAddEventConflictUpdatesExistingEvent(t *testing.T) {
+	g, _ := newTestGoogleCalendar(t, time.UTC)
+	ctx := context.Background()
+
+	start := time.Date(2024, 5, 20, 10, 0, 0, 0, time.UTC)
+	event := Event{ID: "barberbot2", Summary: "Бритьё", Start: start, End: start.Add(30 * time.Minute)}
+	if _, err := g.AddEvent(ctx, event); err != nil {
+		t.Fatalf("AddEvent: %v", err)
+	}
+
+	// Повтор после таймаута, за это время запись перенесли
+	event.Start, event.End = event.Start.Add(time.Hour), event.End.Add(time.Hour)
+	if _, err := g.AddEvent(ctx, event); err != nil {
+		t.Fatalf("repeated AddEvent: %v", err)
+	}
+
+	got, err := g.GetEvent(ctx, event.ID)
+	if err != nil {
+		t.Fatalf("GetEvent: %v", err)
+	}
+	if !got.Start.Equal(event.Start) {
+		t.Errorf("event starts at %v, want %v after the repeated AddEvent", got.Start, event.Start)
+	}
+}
+
+func TestGoogleListEventsSkipsUnparsableEvents(t *testing.T) {
+	g, fake := newTestGoogleCalendar(t, time.UTC)
+
+	fake.put(&calendar.Event{
+		Id:    "good",
+		Start: &calendar.EventDateTime{DateTime: "2024-05-20T10:00:00Z"},
+		End:   &calendar.EventDateTime{DateTime: "2024-05-20T11:00:00Z"},
+	})
+	fake.put(&calendar.Event{Id: "no-time"})
+	fake.put(&calendar.Event{
+		Id:    "bad-time",
+		Start: &calendar.EventDateTime{DateTime: "20 мая, 12:00"},
+		End:   &calendar.EventDateTime{DateTime: "20 мая, 13:00"},
+	})
+	fake.put(&calendar.Event{Id: "removed", Status: "cancelled"})
+
+	from := time.Date(2024, 5, 20, 0, 0, 0, 0, time.UTC)
+	events, err := g.ListEvents(context.Background(), from, from.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	if len(events) != 1 || events[0].ID != "good" {
+		t.Errorf("ListEvents = %+v, want only the parsable event", events)
+	}
+}
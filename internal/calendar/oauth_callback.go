@@ -0,0 +1,41 @@
+package calendar
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// oauthExchangeTimeout ограничивает обмен кода на токен
+const oauthExchangeTimeout = 15 * time.Second
+
+// OAuthCallbackHandler принимает мастера, которого Google вернул после согласия,
+// и подключает календарь. Ответы короткие, их видит мастер в браузере.
+func OAuthCallbackHandler(authorizer Authorizer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		if reason := query.Get("error"); reason != "" {
+			slog.Warn("google calendar authorization declined", "reason", reason)
+			http.Error(w, "Доступ к календарю не выдан. Запросите новую ссылку командой /calendar.", http.StatusBadRequest)
+			return
+		}
+		if !authorizer.CheckState(query.Get("state")) {
+			http.Error(w, "Ссылка устарела. Запросите новую командой /calendar.", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), oauthExchangeTimeout)
+		defer cancel()
+
+		if err := authorizer.Authorize(ctx, query.Get("code")); err != nil {
+			slog.Error("error completing google calendar authorization", "error", err)
+			http.Error(w, "Не удалось подключить календарь, попробуйте ещё раз.", http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("Календарь подключён, можно вернуться в Telegram."))
+	})
+}
@@ -0,0 +1,189 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/RudinMaxim/BarberBot.git/common"
+	"github.com/RudinMaxim/BarberBot.git/config"
+	"github.com/RudinMaxim/BarberBot.git/internal/metrics"
+	"github.com/google/uuid"
+)
+
+const confirmedPrefix = "✅ "
+
+// ErrEventNotFound событие удалено из календаря или никогда в нём не было
+var ErrEventNotFound = errors.New("calendar event not found")
+
+// Event событие календаря. Start и End настоящие моменты времени,
+// провайдер сам переводит их в формат своего API.
+type Event struct {
+	ID          string
+	Summary     string
+	Description string
+	Location    string
+	Start       time.Time
+	End         time.Time
+	// Confirmed клиент подтвердил визит, провайдер помечает событие
+	Confirmed bool
+	// Free событие не занимает время мастера (отмечено как «свободен»)
+	Free bool
+}
+
+// CalendarProvider календарь мастера. Если у события в AddEvent задан ID, событие
+// создаётся под ним, и повтор вызова не создаёт дубль; иначе ID назначает провайдер.
+// UpdateEvent и RemoveEvent возвращают ErrEventNotFound, если события уже нет.
+type CalendarProvider interface {
+	AddEvent(ctx context.Context, event Event) (string, error)
+	UpdateEvent(ctx context.Context, event Event) error
+	RemoveEvent(ctx context.Context, eventID string) error
+	GetEvent(ctx context.Context, eventID string) (Event, error)
+	ListEvents(ctx context.Context, from, to time.Time) ([]Event, error)
+	Ping(ctx context.Context) error
+}
+
+// Authorizer календарь, доступ к которому мастер выдаёт через OAuth.
+// Согласие завершается либо на GoogleOAuthCallbackPath, либо кодом, присланным боту.
+type Authorizer interface {
+	AuthURL() string
+	CheckState(state string) bool
+	Authorize(ctx context.Context, code string) error
+	Authorized() bool
+}
+
+// NewProvider создаёт провайдера по calendar.provider. Для "none" возвращает nil:
+// бот работает без календаря. location часовой пояс салона: в нём создаются
+// события и читаются даты без пояса. tokens хранит OAuth-токен Google.
+func NewProvider(cfg config.Calendar, location *time.Location, tokens TokenStore) (CalendarProvider, error) {
+	var provider CalendarProvider
+	var err error
+
+	switch cfg.Provider {
+	case config.CalendarProviderNone:
+		return nil, nil
+	case config.CalendarProviderGoogle:
+		provider, err = NewGoogleCalendarService(cfg.Google, location, tokens)
+	case config.CalendarProviderCalDAV:
+		provider, err = NewCalDAVProvider(cfg.CalDAV, location)
+	default:
+		return nil, fmt.Errorf("unknown calendar provider %q", cfg.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return instrumented{provider: provider}, nil
+}
+
+// AuthorizerOf возвращает Authorizer провайдера, если доступ к нему выдаёт мастер
+func AuthorizerOf(provider CalendarProvider) (Authorizer, bool) {
+	if p, ok := provider.(instrumented); ok {
+		provider = p.provider
+	}
+	google, ok := provider.(*GoogleCalendarService)
+	if !ok || google.oauth == nil {
+		return nil, false
+	}
+	return google, true
+}
+
+// AppointmentEventID ID, под которым создаётся событие записи. Он выводится из ID
+// записи, поэтому повторная попытка создать событие не плодит дубли. Подходит
+// и для Google (только символы 0-9a-v), и для UID в CalDAV.
+func AppointmentEventID(appointmentID uuid.UUID) string {
+	return "appt" + strings.ReplaceAll(appointmentID.String(), "-", "")
+}
+
+// AppointmentIDFromEventID возвращает ID записи, если событие создано под ID из
+// AppointmentEventID. Так событие узнаётся, даже если запись ещё не успела его сохранить.
+func AppointmentIDFromEventID(eventID string) (uuid.UUID, bool) {
+	hex, ok := strings.CutPrefix(eventID, "appt")
+	if !ok || len(hex) != 32 {
+		return uuid.Nil, false
+	}
+	appointmentID, err := uuid.Parse(hex)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return appointmentID, true
+}
+
+// AppointmentEvent описывает запись клиента как событие календаря
+func AppointmentEvent(appointment *common.Appointment, client *common.Client) Event {
+	return Event{
+		ID:      appointment.CalendarEventID,
+		Summary: fmt.Sprintf("Запись на приём: %s", client.Name),
+		Description: fmt.Sprintf(
+			"Услуга: %s\nЦена: %.2f ₽\n\nДанные клиента:\nТелефон: %s\nTelegram: %s",
+			appointment.Name,
+			appointment.TotalPrice,
+			client.Phone,
+			client.Telegram,
+		),
+		Start:     appointment.StartTime,
+		End:       appointment.EndTime,
+		Confirmed: !appointment.ConfirmedAt.IsZero(),
+	}
+}
+
+// summary заголовок события с отметкой о подтверждении
+func (e Event) summary() string {
+	if e.Confirmed {
+		return confirmedPrefix + e.Summary
+	}
+	return e.Summary
+}
+
+// instrumented считает неудачные операции любого провайдера
+type instrumented struct {
+	provider CalendarProvider
+}
+
+func (p instrumented) AddEvent(ctx context.Context, event Event) (string, error) {
+	id, err := p.provider.AddEvent(ctx, event)
+	observe("create", err)
+	return id, err
+}
+
+func (p instrumented) UpdateEvent(ctx context.Context, event Event) error {
+	err := p.provider.UpdateEvent(ctx, event)
+	if !errors.Is(err, ErrEventNotFound) {
+		observe("update", err)
+	}
+	return err
+}
+
+func (p instrumented) RemoveEvent(ctx context.Context, eventID string) error {
+	err := p.provider.RemoveEvent(ctx, eventID)
+	if !errors.Is(err, ErrEventNotFound) {
+		observe("remove", err)
+	}
+	return err
+}
+
+func (p instrumented) GetEvent(ctx context.Context, eventID string) (Event, error) {
+	event, err := p.provider.GetEvent(ctx, eventID)
+	if !errors.Is(err, ErrEventNotFound) {
+		observe("get", err)
+	}
+	return event, err
+}
+
+func (p instrumented) ListEvents(ctx context.Context, from, to time.Time) ([]Event, error) {
+	events, err := p.provider.ListEvents(ctx, from, to)
+	observe("list", err)
+	return events, err
+}
+
+func (p instrumented) Ping(ctx context.Context) error {
+	return p.provider.Ping(ctx)
+}
+
+func observe(operation string, err error) {
+	if err != nil {
+		metrics.CalendarFailures.WithLabelValues(operation).Inc()
+	}
+}
@@ -0,0 +1,34 @@
+package calendar
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestAppointmentIDFromEventID(t *testing.T) {
+	appointmentID := uuid.MustParse("0f8fad5b-d9cb-469f-a165-70867728950e")
+
+	tests := []struct {
+		name    string
+		eventID string
+		want    uuid.UUID
+		wantOK  bool
+	}{
+		{"derived id", AppointmentEventID(appointmentID), appointmentID, true},
+		{"google generated id", "7kvhq3b8e1n0c2jq4d5m6p8r9s", uuid.Nil, false},
+		{"caldav occurrence", AppointmentEventID(appointmentID) + "_20240331T080000Z", uuid.Nil, false},
+		{"prefix only", "appt", uuid.Nil, false},
+		{"not hex", "appt" + "zz8fad5bd9cb469fa16570867728950e", uuid.Nil, false},
+		{"empty", "", uuid.Nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := AppointmentIDFromEventID(tt.eventID)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("AppointmentIDFromEventID(%q) = %s, %v, want %s, %v", tt.eventID, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
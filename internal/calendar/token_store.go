@@ -0,0 +1,126 @@
+package calendar
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/RudinMaxim/BarberBot.git/common"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TokenStore хранит OAuth-токен календаря между перезапусками
+type TokenStore interface {
+	// LoadToken возвращает nil без ошибки, если токена ещё нет
+	LoadToken(ctx context.Context) (*oauth2.Token, error)
+	SaveToken(ctx context.Context, token *oauth2.Token) error
+}
+
+// DBTokenStore хранит токен в таблице oauth_tokens, зашифрованным AES-256-GCM.
+// Ключ выводится из строки calendar.google.token_key через SHA-256.
+type DBTokenStore struct {
+	db       *gorm.DB
+	provider string
+	aead     cipher.AEAD
+}
+
+func NewDBTokenStore(db *gorm.DB, provider string, key string) (*DBTokenStore, error) {
+	if key == "" {
+		return nil, errors.New("не задан ключ шифрования токена")
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DBTokenStore{db: db, provider: provider, aead: aead}, nil
+}
+
+func (s *DBTokenStore) LoadToken(ctx context.Context) (*oauth2.Token, error) {
+	var stored common.OAuthToken
+	err := s.db.WithContext(ctx).First(&stored, "provider = ?", s.provider).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать токен: %w", err)
+	}
+
+	nonceSize := s.aead.NonceSize()
+	if len(stored.Token) < nonceSize {
+		return nil, errors.New("повреждённый токен")
+	}
+	plain, err := s.aead.Open(nil, stored.Token[:nonceSize], stored.Token[nonceSize:], []byte(s.provider))
+	if err != nil {
+		return nil, fmt.Errorf("не удалось расшифровать токен, возможно изменился ключ: %w", err)
+	}
+
+	token := &oauth2.Token{}
+	if err := json.Unmarshal(plain, token); err != nil {
+		return nil, fmt.Errorf("повреждённый токен: %w", err)
+	}
+	return token, nil
+}
+
+func (s *DBTokenStore) SaveToken(ctx context.Context, token *oauth2.Token) error {
+	plain, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	// Провайдер служит дополнительными данными: токен нельзя подложить в чужую строку
+	sealed := s.aead.Seal(nonce, nonce, plain, []byte(s.provider))
+
+	err = s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "provider"}},
+		DoUpdates: clause.AssignmentColumns([]string{"token", "updated_at"}),
+	}).Create(&common.OAuthToken{Provider: s.provider, Token: sealed}).Error
+	if err != nil {
+		return fmt.Errorf("не удалось сохранить токен: %w", err)
+	}
+	return nil
+}
+
+// persistingTokenSource сохраняет токен после каждого обновления, чтобы новый
+// access token и, если Google его сменил, refresh token пережили перезапуск
+type persistingTokenSource struct {
+	source oauth2.TokenSource
+	store  TokenStore
+	last   *oauth2.Token
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	if s.last != nil && s.last.AccessToken == token.AccessToken {
+		return token, nil
+	}
+
+	// Токен рабочий, поэтому ошибку сохранения только записываем: повторим при следующем запросе
+	if err := s.store.SaveToken(context.Background(), token); err != nil {
+		slog.Error("error saving refreshed calendar token", "error", err)
+		return token, nil
+	}
+	s.last = token
+	return token, nil
+}
@@ -0,0 +1,116 @@
+// Package metrics содержит метрики Prometheus, которые отдаются на /metrics.
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RudinMaxim/BarberBot.git/database"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "barberbot"
+
+// События жизненного цикла записи для Bookings
+const (
+	BookingCreated     = "created"
+	BookingCancelled   = "cancelled"
+	BookingRescheduled = "rescheduled"
+)
+
+var (
+	Bookings = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bookings_total",
+		Help:      "Appointments created, cancelled and rescheduled.",
+	}, []string{"event"})
+
+	TelegramErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_api_errors_total",
+		Help:      "Failed Telegram Bot API requests by method and HTTP status (0 for transport errors).",
+	}, []string{"method", "code"})
+
+	CalendarFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "calendar_sync_failures_total",
+		Help:      "Failed calendar operations.",
+	}, []string{"operation"})
+
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Repository cache lookups by cache and result (hit, miss, error).",
+	}, []string{"cache", "result"})
+
+	UpdateDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "update_duration_seconds",
+		Help:      "Time spent handling a Telegram update.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"type"})
+
+	RemindersPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "reminders_pending",
+		Help:      "Reminders waiting to be sent.",
+	})
+
+	CalendarOutbox = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "calendar_outbox_operations",
+		Help:      "Calendar operations waiting in the outbox (pending) or given up after all retries (dead).",
+	}, []string{"status"})
+)
+
+// Handler отдаёт метрики в формате Prometheus
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveCache учитывает результат чтения из кэша: err от RedisCache.Get
+func ObserveCache(cache string, err error) {
+	result := "hit"
+	switch {
+	case errors.Is(err, database.ErrCacheMiss):
+		result = "miss"
+	case err != nil:
+		result = "error"
+	}
+	CacheRequests.WithLabelValues(cache, result).Inc()
+}
+
+// ObserveUpdate записывает длительность обработки обновления с момента start
+func ObserveUpdate(updateType string, start time.Time) {
+	UpdateDuration.WithLabelValues(updateType).Observe(time.Since(start).Seconds())
+}
+
+// TelegramTransport считает ошибки запросов к Bot API. Метод берётся из
+// последнего сегмента пути, поэтому токен бота в метки не попадает.
+type TelegramTransport struct {
+	Base http.RoundTripper
+}
+
+func (t *TelegramTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	method := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		TelegramErrors.WithLabelValues(method, "0").Inc()
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		TelegramErrors.WithLabelValues(method, strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	return resp, nil
+}
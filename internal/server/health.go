@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK          = "ok"
+	StatusDegraded    = "degraded"
+	StatusUnavailable = "unavailable"
+	statusError       = "error"
+)
+
+// Check проверка одной зависимости. Сбой необязательной проверки (Optional)
+// переводит сервис в degraded, но не снимает готовность.
+type Check struct {
+	Name     string
+	Optional bool
+	Probe    func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Status    string `json:"status"`
+	Optional  bool   `json:"optional,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// LivenessHandler отвечает, пока процесс жив и обслуживает HTTP
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, http.StatusOK, healthResponse{Status: StatusOK})
+	})
+}
+
+// ReadinessHandler параллельно выполняет проверки зависимостей и отдаёт статус
+// каждой из них. Если упала хотя бы одна обязательная, отвечает 503.
+type ReadinessHandler struct {
+	checks  []Check
+	timeout time.Duration
+}
+
+func NewReadinessHandler(timeout time.Duration, checks ...Check) *ReadinessHandler {
+	return &ReadinessHandler{
+		checks:  checks,
+		timeout: timeout,
+	}
+}
+
+func (h *ReadinessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	results := make([]checkResult, len(h.checks))
+	var wg sync.WaitGroup
+	for i, check := range h.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	response := healthResponse{Status: StatusOK, Checks: make(map[string]checkResult, len(h.checks))}
+	code := http.StatusOK
+	for i, check := range h.checks {
+		result := results[i]
+		response.Checks[check.Name] = result
+		if result.Status == StatusOK {
+			continue
+		}
+		if check.Optional {
+			if response.Status == StatusOK {
+				response.Status = StatusDegraded
+			}
+			continue
+		}
+		response.Status = StatusUnavailable
+		code = http.StatusServiceUnavailable
+	}
+
+	writeHealth(w, code, response)
+}
+
+// runCheck не ждёт зависшую проверку дольше ctx, даже если Probe игнорирует контекст
+func runCheck(ctx context.Context, check Check) checkResult {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Probe(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := checkResult{
+		Status:    StatusOK,
+		Optional:  check.Optional,
+		LatencyMS: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Status = statusError
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func writeHealth(w http.ResponseWriter, code int, response healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func probe(err error) func(context.Context) error {
+	return func(context.Context) error { return err }
+}
+
+func TestReadinessHandler(t *testing.T) {
+	down := errors.New("connection refused")
+
+	tests := []struct {
+		name       string
+		database   error
+		redis      error
+		wantCode   int
+		wantStatus string
+		wantChecks map[string]string
+	}{
+		{"all up", nil, nil, http.StatusOK, StatusOK,
+			map[string]string{"database": StatusOK, "redis": StatusOK}},
+		{"database down", down, nil, http.StatusServiceUnavailable, StatusUnavailable,
+			map[string]string{"database": statusError, "redis": StatusOK}},
+		// Без Redis бот работает на запасных хранилищах
+		{"redis down", nil, down, http.StatusOK, StatusDegraded,
+			map[string]string{"database": StatusOK, "redis": statusError}},
+		{"both down", down, down, http.StatusServiceUnavailable, StatusUnavailable,
+			map[string]string{"database": statusError, "redis": statusError}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewReadinessHandler(time.Second,
+				Check{Name: "database", Probe: probe(tt.database)},
+				Check{Name: "redis", Optional: true, Probe: probe(tt.redis)},
+			)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", w.Code, tt.wantCode)
+			}
+			var response healthResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if response.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", response.Status, tt.wantStatus)
+			}
+			for name, want := range tt.wantChecks {
+				if got := response.Checks[name].Status; got != want {
+					t.Errorf("check %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadinessHandlerDoesNotWaitForHungProbe(t *testing.T) {
+	hung := make(chan struct{})
+	defer close(hung)
+
+	handler := NewReadinessHandler(20*time.Millisecond, Check{
+		Name:  "database",
+		Probe: func(context.Context) error { <-hung; return nil },
+	})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status code = %d, want 503 for a probe past the timeout", w.Code)
+	}
+}
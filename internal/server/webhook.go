@@ -0,0 +1,112 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+	maxUpdateSize     = 1 << 20
+)
+
+// WebhookHandler принимает обновления от Telegram и передаёт их в тот же канал,
+// который в режиме polling возвращает GetUpdatesChan.
+type WebhookHandler struct {
+	secret  string
+	updates chan tgbotapi.Update
+
+	// mu не даёт закрыть канал, пока в него пишет запрос
+	mu       sync.RWMutex
+	closed   bool
+	stopping chan struct{}
+	stopOnce sync.Once
+}
+
+func NewWebhookHandler(secret string, bufferSize int) *WebhookHandler {
+	return &WebhookHandler{
+		secret:   secret,
+		updates:  make(chan tgbotapi.Update, bufferSize),
+		stopping: make(chan struct{}),
+	}
+}
+
+// Close перестаёт принимать обновления и закрывает канал Updates. Запросы,
+// которые ждут места в очереди, получают 503, и Telegram повторит их после перезапуска.
+func (h *WebhookHandler) Close() {
+	h.stopOnce.Do(func() {
+		close(h.stopping)
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.closed = true
+		close(h.updates)
+	})
+}
+
+func (h *WebhookHandler) Updates() tgbotapi.UpdatesChannel {
+	return h.updates
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.Header.Get(secretTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.secret)) != 1 {
+		slog.Warn("rejected webhook request: invalid secret token", "remote_addr", r.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var update tgbotapi.Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateSize)).Decode(&update); err != nil {
+		slog.Error("error decoding webhook update", "error", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.closed {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Если очередь переполнена или бот останавливается, Telegram повторит доставку после ошибки
+	select {
+	case h.updates <- update:
+		w.WriteHeader(http.StatusOK)
+	case <-r.Context().Done():
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+	case <-h.stopping:
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+	}
+}
+
+// SetWebhook регистрирует вебхук вместе с secret_token, который Telegram
+// будет присылать в заголовке X-Telegram-Bot-Api-Secret-Token.
+func SetWebhook(bot *tgbotapi.BotAPI, baseURL, path, secret string) error {
+	params := tgbotapi.Params{}
+	params["url"] = strings.TrimRight(baseURL, "/") + path
+	params.AddNonEmpty("secret_token", secret)
+	params["allowed_updates"] = `["message","callback_query"]`
+
+	resp, err := bot.MakeRequest("setWebhook", params)
+	if err != nil {
+		return fmt.Errorf("failed to set webhook: %w", err)
+	}
+	if !resp.Ok {
+		return fmt.Errorf("telegram rejected webhook: %s", resp.Description)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func webhookRequest(secret string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"update_id":1}`))
+	if secret != "" {
+		r.Header.Set(secretTokenHeader, secret)
+	}
+	return r
+}
+
+func TestWebhookHandlerChecksSecretToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		secret   string
+		wantCode int
+	}{
+		{"missing header", "", http.StatusForbidden},
+		{"wrong secret", "guess", http.StatusForbidden},
+		{"secret prefix", "secre", http.StatusForbidden},
+		{"correct secret", "secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewWebhookHandler("secret", 1)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, webhookRequest(tt.secret))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			queued := len(handler.Updates())
+			if wantQueued := tt.wantCode == http.StatusOK; (queued == 1) != wantQueued {
+				t.Errorf("queued %d updates, want update accepted = %v", queued, wantQueued)
+			}
+		})
+	}
+}
+
+func TestWebhookHandlerCloseReleasesWaitingRequests(t *testing.T) {
+	handler := NewWebhookHandler("secret", 1)
+	handler.ServeHTTP(httptest.NewRecorder(), webhookRequest("secret"))
+
+	// Очередь заполнена: второй запрос ждёт места, пока бот не начнёт останавливаться
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, webhookRequest("secret"))
+		done <- w.Code
+	}()
+
+	select {
+	case code := <-done:
+		t.Fatalf("request into a full queue finished with %d before Close", code)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	handler.Close()
+	select {
+	case code := <-done:
+		if code != http.StatusServiceUnavailable {
+			t.Errorf("waiting request got %d, want 503", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not release the waiting request")
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, webhookRequest("secret"))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("request after Close got %d, want 503", w.Code)
+	}
+
+	// Принятое до остановки обновление остаётся в канале, затем канал закрыт
+	if update, ok := <-handler.Updates(); !ok || update.UpdateID != 1 {
+		t.Errorf("buffered update = %+v, %v, want update 1", update, ok)
+	}
+	if _, ok := <-handler.Updates(); ok {
+		t.Error("updates channel is open after Close")
+	}
+}